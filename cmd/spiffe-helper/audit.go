@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spiffe/spiffe-helper/pkg/audit"
+)
+
+const verifyAuditLogCommand = "verify-audit-log"
+
+// verifyAuditLog implements 'spiffe-helper verify-audit-log <file>...', which
+// checks the hash chain of each audit log and returns the exit status
+func verifyAuditLog(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags(verifyAuditLogCommand, stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: spiffe-helper %s <audit log file>...\n", verifyAuditLogCommand)
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	exitStatus := 0
+	for _, filename := range flags.Args() {
+		count, err := audit.VerifyFile(filename)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: verification failed after %d entries: %v\n", filename, count, err)
+			exitStatus = 1
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: %d entries verified\n", filename, count)
+	}
+
+	return exitStatus
+}
@@ -1,19 +1,31 @@
 package config
 
 import (
+	"context"
 	"errors"
-	"flag"
 	"fmt"
+	"io"
 	"io/fs"
+	"net"
 	"os"
+	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
 	"github.com/hashicorp/hcl/hcl/token"
 	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
 	"github.com/spiffe/spiffe-helper/pkg/health"
+	"github.com/spiffe/spiffe-helper/pkg/logfile"
 	"github.com/spiffe/spiffe-helper/pkg/sidecar"
+	"github.com/spiffe/spiffe-helper/pkg/systemd"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 const (
@@ -23,37 +35,125 @@ const (
 	defaultJWTBundleFileMode = 0600
 	defaultJWTSVIDFileMode   = 0600
 	defaultBindPort          = 8081
+	defaultGRPCBindPort      = 8082
+	defaultUnixSocketMode    = 0660
+	defaultCmdOutputMaxSize  = 100
+	defaultCmdOutputBackups  = 3
+	defaultLogFileMaxSize    = 100
+	defaultLogFileBackups    = 3
+	defaultAuditLogFileMode  = 0600
 	defaultLivenessPath      = "/live"
 	defaultReadinessPath     = "/ready"
+	defaultMetricsPath       = "/metrics"
+	defaultStatusPath        = "/status"
+	defaultTracingService    = "spiffe-helper"
 )
 
+// Formats of the helper's logs
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var logFormats = []string{logFormatText, logFormatJSON}
+
+// How the stdin of 'cmd' is connected
+const (
+	cmdStdinInherit = "inherit"
+	cmdStdinNull    = "null"
+	cmdStdinClosed  = "closed"
+)
+
+var cmdStdinModes = []string{cmdStdinInherit, cmdStdinNull, cmdStdinClosed}
+
+// Where the stdout and stderr of 'cmd' go
+const (
+	cmdOutputInherit = "inherit"
+	cmdOutputLog     = "log"
+	cmdOutputFile    = "file"
+	cmdOutputDiscard = "discard"
+)
+
+var cmdOutputModes = []string{cmdOutputInherit, cmdOutputLog, cmdOutputFile, cmdOutputDiscard}
+
 type Config struct {
-	AddIntermediatesToBundle bool          `hcl:"add_intermediates_to_bundle"`
-	AgentAddress             string        `hcl:"agent_address"`
-	Cmd                      string        `hcl:"cmd"`
-	CmdArgs                  string        `hcl:"cmd_args"`
-	PIDFilename              string        `hcl:"pid_file_name"`
-	CertDir                  string        `hcl:"cert_dir"`
-	CertFileMode             int           `hcl:"cert_file_mode"`
-	KeyFileMode              int           `hcl:"key_file_mode"`
-	JWTBundleFileMode        int           `hcl:"jwt_bundle_file_mode"`
-	JWTSVIDFileMode          int           `hcl:"jwt_svid_file_mode"`
-	IncludeFederatedDomains  bool          `hcl:"include_federated_domains"`
-	RenewSignal              string        `hcl:"renew_signal"`
-	DaemonMode               *bool         `hcl:"daemon_mode"`
-	HealthCheck              health.Config `hcl:"health_checks"`
-	Hint                     string        `hcl:"hint"`
-	ParallelRequests         int           `hcl:"parallel_requests"`
+	AddIntermediatesToBundle bool              `hcl:"add_intermediates_to_bundle"`
+	AgentAddress             string            `hcl:"agent_address"`
+	Cmd                      string            `hcl:"cmd"`
+	CmdArgs                  interface{}       `hcl:"cmd_args"`
+	CmdArgsParser            string            `hcl:"cmd_args_parser"`
+	CmdEnv                   map[string]string `hcl:"cmd_env"`
+	CmdEnvIncludeJWTSVIDs    bool              `hcl:"cmd_env_include_jwt_svids"`
+	CmdStdin                 string            `hcl:"cmd_stdin"`
+	CmdStdout                string            `hcl:"cmd_stdout"`
+	CmdStderr                string            `hcl:"cmd_stderr"`
+	CmdStdoutFilename        string            `hcl:"cmd_stdout_file_name"`
+	CmdStderrFilename        string            `hcl:"cmd_stderr_file_name"`
+	CmdOutputMaxSizeMB       int               `hcl:"cmd_output_max_size_mb"`
+	CmdOutputMaxBackups      int               `hcl:"cmd_output_max_backups"`
+	CmdStopSignal            string            `hcl:"cmd_stop_signal"`
+	CmdStopTimeout           string            `hcl:"cmd_stop_timeout"`
+	PIDFilename              string            `hcl:"pid_file_name"`
+	CertDir                  string            `hcl:"cert_dir"`
+	CertFileMode             int               `hcl:"cert_file_mode"`
+	KeyFileMode              int               `hcl:"key_file_mode"`
+	JWTBundleFileMode        int               `hcl:"jwt_bundle_file_mode"`
+	JWTSVIDFileMode          int               `hcl:"jwt_svid_file_mode"`
+	IncludeFederatedDomains  bool              `hcl:"include_federated_domains"`
+	RenewSignal              string            `hcl:"renew_signal"`
+	DaemonMode               *bool             `hcl:"daemon_mode"`
+	HealthCheck              health.Config     `hcl:"health_checks"`
+	Hint                     string            `hcl:"hint"`
+	ParallelRequests         int               `hcl:"parallel_requests"`
+	LogLevel                 string            `hcl:"log_level"`
+	LogFormat                string            `hcl:"log_format"`
+	LogFile                  string            `hcl:"log_file"`
+	LogFileMaxSizeMB         int               `hcl:"log_file_max_size_mb"`
+	LogFileMaxBackups        int               `hcl:"log_file_max_backups"`
+	AuditLogFile             string            `hcl:"audit_log_file"`
+	AuditLogFileMode         int               `hcl:"audit_log_file_mode"`
+	TracingOTLPEndpoint      string            `hcl:"tracing_otlp_endpoint"`
+	TracingOTLPInsecure      bool              `hcl:"tracing_otlp_insecure"`
+	TracingServiceName       string            `hcl:"tracing_service_name"`
 
 	// x509 configuration
 	SVIDFilename       string `hcl:"svid_file_name"`
 	SVIDKeyFilename    string `hcl:"svid_key_file_name"`
 	SVIDBundleFilename string `hcl:"svid_bundle_file_name"`
 
+	// Further X.509 SVID outputs, one per x509_svid block
+	X509SVIDs []X509SVIDConfig `hcl:"x509_svid"`
+
 	// JWT configuration
 	JWTSVIDs          []JWTConfig `hcl:"jwt_svids"`
 	JWTBundleFilename string      `hcl:"jwt_bundle_file_name"`
 
+	// Notification targets
+	NotifyTargets     []NotifyTargetConfig `hcl:"notify_targets"`
+	NotifyDebounce    string               `hcl:"notify_debounce"`
+	NotifyMaxDelay    string               `hcl:"notify_max_delay"`
+	NotifyMinInterval string               `hcl:"notify_min_interval"`
+
+	UnusedKeyPositions map[string][]token.Pos `hcl:",unusedKeyPositions"`
+
+	// Where the keys are set in the configuration file, see Validate
+	positions map[string]token.Pos
+
+	// The values of the keys with references as written, see Marshal
+	references map[string]string
+}
+
+type X509SVIDConfig struct {
+	Hint                     string `hcl:"hint"`
+	SPIFFEID                 string `hcl:"spiffe_id"`
+	SVIDFilename             string `hcl:"svid_file_name"`
+	SVIDKeyFilename          string `hcl:"svid_key_file_name"`
+	SVIDBundleFilename       string `hcl:"svid_bundle_file_name"`
+	CertFileMode             int    `hcl:"cert_file_mode"`
+	KeyFileMode              int    `hcl:"key_file_mode"`
+	AddIntermediatesToBundle bool   `hcl:"add_intermediates_to_bundle"`
+	IncludeFederatedDomains  bool   `hcl:"include_federated_domains"`
+
 	UnusedKeyPositions map[string][]token.Pos `hcl:",unusedKeyPositions"`
 }
 
@@ -65,46 +165,110 @@ type JWTConfig struct {
 	UnusedKeyPositions map[string][]token.Pos `hcl:",unusedKeyPositions"`
 }
 
-// ParseConfigFile parses the given HCL file into a Config struct
+type NotifyTargetConfig struct {
+	PIDFilename       string   `hcl:"pid_file_name"`
+	ProcessName       string   `hcl:"process_name"`
+	CmdlineRegex      string   `hcl:"cmdline_regex"`
+	CgroupPath        string   `hcl:"cgroup_path"`
+	SystemdUnit       string   `hcl:"systemd_unit"`
+	SystemdUnitAction string   `hcl:"systemd_unit_action"`
+	RenewSignal       string   `hcl:"renew_signal"`
+	CredentialTypes   []string `hcl:"credential_types"`
+
+	UnusedKeyPositions map[string][]token.Pos `hcl:",unusedKeyPositions"`
+}
+
+// ParseConfigFile parses the given file into a Config struct, detecting
+// its format from its extension
 func ParseConfigFile(file string) (*Config, error) {
-	dat, err := os.ReadFile(file)
-	if err != nil {
-		return nil, err
+	return ParseConfigFileWithFormat(file, DetectFormat(file))
+}
+
+// ParseConfigFileWithFormat parses the given HCL, JSON or YAML file into a
+// Config struct, expanding references to environment variables and files in
+// string values
+func ParseConfigFileWithFormat(file string, format string) (*Config, error) {
+	return parseConfig(file, format, nil)
+}
+
+// parseConfig parses the configuration file, if any, and replaces the keys
+// set by the overrides before decoding it
+func parseConfig(file string, format string, overrides *Overrides) (*Config, error) {
+	root := &ast.File{Node: &ast.ObjectList{}}
+	refs := make(references)
+	if file != "" {
+		if err := validateFormat(format); err != nil {
+			return nil, err
+		}
+		dat, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		root, err = parseFile(file, format, dat)
+		if err != nil {
+			return nil, err
+		}
+		if err := expandValues(root, refs); err != nil {
+			return nil, err
+		}
+	}
+	if list, ok := root.Node.(*ast.ObjectList); ok {
+		if err := overrides.apply(list, refs); err != nil {
+			return nil, err
+		}
+		listBlocks(list)
 	}
 
 	config := new(Config)
-	if err := hcl.Decode(config, string(dat)); err != nil {
+	if err := hcl.DecodeObject(config, root); err != nil {
 		return nil, err
 	}
+	if list, ok := root.Node.(*ast.ObjectList); ok {
+		config.positions = keyPositions(list)
+		config.references = refs.keys(list)
+	}
 
 	return config, nil
 }
 
-// ParseConfigFlagOverrides handles command line arguments that override config file settings
-func (c *Config) ParseConfigFlagOverrides(daemonModeFlag bool, daemonModeFlagName string) {
-	if isFlagPassed(daemonModeFlagName) {
-		c.DaemonMode = &daemonModeFlag
-	} else if c.DaemonMode == nil {
-		daemonMode := true
-		c.DaemonMode = &daemonMode
+// listBlocks turns each block of a list of blocks written as repeated blocks,
+// such as x509_svid, into a list of that one block. The HCL decoder would
+// otherwise decode every key of the block into an element of its own.
+func listBlocks(list *ast.ObjectList) {
+	for _, item := range list.Items {
+		block, ok := item.Val.(*ast.ObjectType)
+		if !ok || len(item.Keys) != 1 || !slices.Contains(hclRepeatedBlocks, objectKey(item)) {
+			continue
+		}
+		item.Val = &ast.ListType{Lbrack: block.Lbrace, List: []ast.Node{block}, Rbrack: block.Rbrace}
 	}
 }
 
+// ValidateConfig checks the configuration and sets the defaults of the keys
+// that aren't set. It returns the first problem found, without its
+// position. Validate returns all of them.
 func (c *Config) ValidateConfig(log logrus.FieldLogger) error {
 	if err := c.checkForUnknownConfig(); err != nil {
 		return err
 	}
-
-	if err := validateOSConfig(c); err != nil {
-		return err
+	if problems := c.validate(log); len(problems) > 0 {
+		return problems[0].Err
 	}
 
-	for _, jwtConfig := range c.JWTSVIDs {
+	return nil
+}
+
+func (c *Config) validate(log logrus.FieldLogger) []*Problem {
+	p := &problems{config: c}
+	validateOSConfig(c, p)
+
+	for i, jwtConfig := range c.JWTSVIDs {
 		if jwtConfig.JWTSVIDFilename == "" {
-			return errors.New("'jwt_file_name' is required in 'jwt_svids'")
+			p.add(fmt.Sprintf("jwt_svids[%d].jwt_svid_file_name", i), errors.New("'jwt_file_name' is required in 'jwt_svids'"))
 		}
 		if jwtConfig.JWTAudience == "" {
-			return errors.New("'jwt_audience' is required in 'jwt_svids'")
+			p.add(fmt.Sprintf("jwt_svids[%d].jwt_audience", i), errors.New("'jwt_audience' is required in 'jwt_svids'"))
 		}
 	}
 
@@ -117,7 +281,7 @@ func (c *Config) ValidateConfig(log logrus.FieldLogger) error {
 			log.Warn("SPIRE_AGENT_ADDRESS is deprecated and will be removed in 0.10.0. Use SPIFFE_ENDPOINT_SOCKET instead.")
 			c.AgentAddress = spireAgentAddress
 		case spireAgentAddress != "" && spiffeEndpointSocket != "":
-			return errors.New("both SPIRE_AGENT_ADDRESS and SPIFFE_ENDPOINT_SOCKET set. Use SPIFFE_ENDPOINT_SOCKET only. Support for SPIRE_AGENT_ADDRESS is deprecated and will be removed in 0.10.0")
+			p.add("", errors.New("both SPIRE_AGENT_ADDRESS and SPIFFE_ENDPOINT_SOCKET set. Use SPIFFE_ENDPOINT_SOCKET only. Support for SPIRE_AGENT_ADDRESS is deprecated and will be removed in 0.10.0"))
 		case spireAgentAddress == "" && spiffeEndpointSocket != "":
 			c.AgentAddress = spiffeEndpointSocket
 		default:
@@ -133,62 +297,154 @@ func (c *Config) ValidateConfig(log logrus.FieldLogger) error {
 			log.Warn("renew_signal is set but daemon_mode is false. renew_signal will be ignored. This may become an error in a future release.")
 		}
 		if c.PIDFilename != "" {
-			return errors.New("pid_file_name is set but daemon_mode is false. pid_file_name is only supported in daemon_mode")
+			p.add("pid_file_name", errors.New("pid_file_name is set but daemon_mode is false. pid_file_name is only supported in daemon_mode"))
 		}
 	}
 
 	if c.PIDFilename != "" && c.RenewSignal == "" {
-		return errors.New("must specify renew_signal when using pid_file_name")
+		p.add("pid_file_name", errors.New("must specify renew_signal when using pid_file_name"))
+	}
+
+	validateLogging(c, p)
+	validateTracing(c, p)
+	validateAuditLog(c, p)
+
+	validateCmdArgs(c, log, p)
+	validateCmdStdio(c, p)
+
+	validateNotifyTargets(c, p)
+	for _, duration := range []struct {
+		key, value string
+	}{
+		{"cmd_stop_timeout", c.CmdStopTimeout},
+		{"health_checks.readiness_min_lifetime", c.HealthCheck.ReadinessMinLifetime},
+		{"health_checks.liveness_update_window", c.HealthCheck.LivenessUpdateWindow},
+		{"notify_debounce", c.NotifyDebounce},
+		{"notify_max_delay", c.NotifyMaxDelay},
+		{"notify_min_interval", c.NotifyMinInterval},
+	} {
+		if _, err := parseDuration(duration.key, duration.value); err != nil {
+			p.add(duration.key, err)
+		}
 	}
 
 	x509Enabled, err := validateX509Config(c)
 	if err != nil {
-		return err
+		p.add(firstSetKey(c, "svid_file_name", "svid_key_file_name", "svid_bundle_file_name"), err)
 	}
 
 	jwtBundleEnabled, jwtSVIDsEnabled := validateJWTConfig(c)
 
-	if !x509Enabled && !jwtBundleEnabled && !jwtSVIDsEnabled {
-		return errors.New("at least one of the sets ('svid_file_name', 'svid_key_file_name', 'svid_bundle_file_name'), 'jwt_svids', or 'jwt_bundle_file_name' must be fully specified")
+	x509Enabled = x509Enabled || len(c.X509SVIDs) > 0
+	if err == nil && !x509Enabled && !jwtBundleEnabled && !jwtSVIDsEnabled {
+		p.add("", errors.New("at least one of the sets ('svid_file_name', 'svid_key_file_name', 'svid_bundle_file_name'), 'x509_svid', 'jwt_svids', or 'jwt_bundle_file_name' must be fully specified"))
 	}
 
-	if c.CertFileMode < 0 {
-		return errors.New("cert file mode must be positive")
-	} else if c.CertFileMode == 0 {
-		c.CertFileMode = defaultCertFileMode
-	}
-	if c.KeyFileMode < 0 {
-		return errors.New("key file mode must be positive")
-	} else if c.KeyFileMode == 0 {
-		c.KeyFileMode = defaultKeyFileMode
-	}
-	if c.JWTBundleFileMode < 0 {
-		return errors.New("jwt bundle file mode must be positive")
-	} else if c.JWTBundleFileMode == 0 {
-		c.JWTBundleFileMode = defaultJWTBundleFileMode
-	}
-	if c.JWTSVIDFileMode < 0 {
-		return errors.New("jwt svid file mode must be positive")
-	} else if c.JWTSVIDFileMode == 0 {
-		c.JWTSVIDFileMode = defaultJWTSVIDFileMode
+	for _, fileMode := range []struct {
+		key, name    string
+		mode         *int
+		defaultValue int
+	}{
+		{"cert_file_mode", "cert file mode", &c.CertFileMode, defaultCertFileMode},
+		{"key_file_mode", "key file mode", &c.KeyFileMode, defaultKeyFileMode},
+		{"jwt_bundle_file_mode", "jwt bundle file mode", &c.JWTBundleFileMode, defaultJWTBundleFileMode},
+		{"jwt_svid_file_mode", "jwt svid file mode", &c.JWTSVIDFileMode, defaultJWTSVIDFileMode},
+	} {
+		if *fileMode.mode < 0 {
+			p.add(fileMode.key, fmt.Errorf("%s must be positive", fileMode.name))
+		} else if *fileMode.mode == 0 {
+			*fileMode.mode = fileMode.defaultValue
+		}
 	}
 
-	if c.HealthCheck.ListenerEnabled {
+	validateX509SVIDs(c, p)
+
+	if c.HealthCheck.ListenerEnabled || c.HealthCheck.GRPCListenerEnabled {
 		if c.HealthCheck.BindPort < 0 {
-			return errors.New("bind port must be positive")
+			p.add("health_checks.bind_port", errors.New("bind port must be positive"))
 		}
 		if c.HealthCheck.BindPort == 0 {
 			c.HealthCheck.BindPort = defaultBindPort
 		}
+		if c.HealthCheck.GRPCBindPort < 0 {
+			p.add("health_checks.grpc_bind_port", errors.New("grpc bind port must be positive"))
+		}
+		if c.HealthCheck.GRPCBindPort == 0 {
+			c.HealthCheck.GRPCBindPort = defaultGRPCBindPort
+		}
+		if c.HealthCheck.ListenerEnabled && c.HealthCheck.GRPCListenerEnabled && c.HealthCheck.BindPort == c.HealthCheck.GRPCBindPort {
+			p.add("health_checks.grpc_bind_port", errors.New("health_checks.bind_port and health_checks.grpc_bind_port must differ"))
+		}
 		if c.HealthCheck.LivenessPath == "" {
 			c.HealthCheck.LivenessPath = defaultLivenessPath
 		}
 		if c.HealthCheck.ReadinessPath == "" {
 			c.HealthCheck.ReadinessPath = defaultReadinessPath
 		}
+		if c.HealthCheck.MetricsPath == "" {
+			c.HealthCheck.MetricsPath = defaultMetricsPath
+		}
+		if c.HealthCheck.StatusPath == "" {
+			c.HealthCheck.StatusPath = defaultStatusPath
+		}
+		validateHealthPaths(&c.HealthCheck, p)
+		validateHealthListener(&c.HealthCheck, x509Enabled, p)
 	}
 
-	return nil
+	return p.list
+}
+
+// validateHealthPaths checks that every endpoint of the HTTP health server has
+// a path of its own
+func validateHealthPaths(c *health.Config, p *problems) {
+	users := make(map[string]string)
+	for _, endpoint := range []struct {
+		key, path string
+	}{
+		{"health_checks.liveness_path", c.LivenessPath},
+		{"health_checks.readiness_path", c.ReadinessPath},
+		{"health_checks.metrics_path", c.MetricsPath},
+		{"health_checks.status_path", c.StatusPath},
+	} {
+		if endpoint.path == "" {
+			continue
+		}
+		if user, ok := users[endpoint.path]; ok {
+			p.add(endpoint.key, fmt.Errorf("%s %q is also used by %s", endpoint.key, endpoint.path, user))
+			continue
+		}
+		users[endpoint.path] = endpoint.key
+	}
+}
+
+// validateHealthListener checks where and how the health server listens
+func validateHealthListener(c *health.Config, x509Enabled bool, p *problems) {
+	if c.UnixSocketPath != "" && c.BindAddress != "" {
+		p.add("health_checks.unix_socket_path", errors.New("health_checks.bind_address and health_checks.unix_socket_path are mutually exclusive"))
+	}
+	if c.UnixSocketPath != "" && c.GRPCListenerEnabled {
+		p.add("health_checks.grpc_listener_enabled", errors.New("the gRPC health service can't listen on health_checks.unix_socket_path, so health_checks.grpc_listener_enabled can't be set with it"))
+	}
+	if c.UnixSocketFileMode < 0 {
+		p.add("health_checks.unix_socket_file_mode", errors.New("health_checks.unix_socket_file_mode must be positive"))
+	} else if c.UnixSocketFileMode == 0 {
+		c.UnixSocketFileMode = defaultUnixSocketMode
+	}
+
+	if !c.TLSEnabled {
+		if len(c.TLSAllowedSPIFFEIDs) > 0 {
+			p.add("health_checks.tls_allowed_spiffe_ids", errors.New("health_checks.tls_allowed_spiffe_ids is set but health_checks.tls_enabled is false"))
+		}
+		return
+	}
+	if !x509Enabled {
+		p.add("health_checks.tls_enabled", errors.New("health_checks.tls_enabled requires 'svid_file_name', 'svid_key_file_name' and 'svid_bundle_file_name', or an 'x509_svid', as the health server uses the helper's X.509 SVID"))
+	}
+	for _, allowedID := range c.TLSAllowedSPIFFEIDs {
+		if _, err := spiffeid.FromString(allowedID); err != nil {
+			p.add("health_checks.tls_allowed_spiffe_ids", fmt.Errorf("invalid health_checks.tls_allowed_spiffe_ids: %w", err))
+		}
+	}
 }
 
 func (c *Config) checkForUnknownConfig() error {
@@ -196,30 +452,74 @@ func (c *Config) checkForUnknownConfig() error {
 		return fmt.Errorf("unknown top level key(s): %s", mapKeysToString(c.UnusedKeyPositions))
 	}
 
+	for i, x509SVID := range c.X509SVIDs {
+		if len(x509SVID.UnusedKeyPositions) != 0 {
+			return fmt.Errorf("unknown key(s) in x509_svid[%d]: %s", i, mapKeysToString(x509SVID.UnusedKeyPositions))
+		}
+	}
+
 	for i, jwtSVID := range c.JWTSVIDs {
 		if len(jwtSVID.UnusedKeyPositions) != 0 {
 			return fmt.Errorf("unknown key(s) in jwt_svids[%d]: %s", i, mapKeysToString(jwtSVID.UnusedKeyPositions))
 		}
 	}
 
+	for i, notifyTarget := range c.NotifyTargets {
+		if len(notifyTarget.UnusedKeyPositions) != 0 {
+			return fmt.Errorf("unknown key(s) in notify_targets[%d]: %s", i, mapKeysToString(notifyTarget.UnusedKeyPositions))
+		}
+	}
+
 	return nil
 }
 
-func ParseConfig(configFile string, daemonModeFlag bool, daemonModeFlagName string) (*Config, error) {
-	hclConfig, err := ParseConfigFile(configFile)
+// ParseConfig parses the configuration file and applies the flags and
+// environment variables that override it. An empty configFile runs with the
+// overrides only, and an empty configFormat detects the format from the
+// file extension.
+func ParseConfig(configFile string, configFormat string, overrides *Overrides) (*Config, error) {
+	if configFormat == "" {
+		configFormat = DetectFormat(configFile)
+	}
+	hclConfig, err := parseConfig(configFile, configFormat, overrides)
 	if err != nil {
+		if configFile == "" {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to parse %q: %w", configFile, err)
 	}
-	hclConfig.ParseConfigFlagOverrides(daemonModeFlag, daemonModeFlagName)
+	if hclConfig.DaemonMode == nil {
+		daemonMode := true
+		hclConfig.DaemonMode = &daemonMode
+	}
 	return hclConfig, nil
 }
 
 func NewSidecarConfig(config *Config, log logrus.FieldLogger) *sidecar.Config {
+	// Durations and cmd_args have already been checked by ValidateConfig
+	notifyDebounce, _ := parseDuration("notify_debounce", config.NotifyDebounce)
+	notifyMaxDelay, _ := parseDuration("notify_max_delay", config.NotifyMaxDelay)
+	notifyMinInterval, _ := parseDuration("notify_min_interval", config.NotifyMinInterval)
+	cmdStopTimeout, _ := parseDuration("cmd_stop_timeout", config.CmdStopTimeout)
+	readinessMinLifetime, _ := parseDuration("health_checks.readiness_min_lifetime", config.HealthCheck.ReadinessMinLifetime)
+	livenessUpdateWindow, _ := parseDuration("health_checks.liveness_update_window", config.HealthCheck.LivenessUpdateWindow)
+	cmdArgs, cmdArgv, _ := config.cmdArgs()
+	cmdStdin, cmdStdout, cmdStderr := config.cmdStdio(log)
+
 	sidecarConfig := &sidecar.Config{
 		AddIntermediatesToBundle: config.AddIntermediatesToBundle,
 		AgentAddress:             config.AgentAddress,
 		Cmd:                      config.Cmd,
-		CmdArgs:                  config.CmdArgs,
+		CmdArgs:                  cmdArgs,
+		CmdArgsParser:            config.CmdArgsParser,
+		CmdArgv:                  cmdArgv,
+		CmdEnv:                   config.CmdEnv,
+		CmdEnvIncludeJWTSVIDs:    config.CmdEnvIncludeJWTSVIDs,
+		CmdStdin:                 cmdStdin,
+		CmdStdout:                cmdStdout,
+		CmdStderr:                cmdStderr,
+		CmdStopSignal:            config.CmdStopSignal,
+		CmdStopTimeout:           cmdStopTimeout,
 		PIDFilename:              config.PIDFilename,
 		CertDir:                  config.CertDir,
 		CertFileMode:             fs.FileMode(config.CertFileMode),
@@ -235,6 +535,25 @@ func NewSidecarConfig(config *Config, log logrus.FieldLogger) *sidecar.Config {
 		SVIDBundleFilename:       config.SVIDBundleFilename,
 		ParallelRequests:         config.ParallelRequests,
 		Hint:                     config.Hint,
+		NotifyDebounce:           notifyDebounce,
+		NotifyMaxDelay:           notifyMaxDelay,
+		NotifyMinInterval:        notifyMinInterval,
+		ReadinessMinLifetime:     readinessMinLifetime,
+		LivenessUpdateWindow:     livenessUpdateWindow,
+	}
+
+	for _, x509SVID := range config.X509SVIDs {
+		sidecarConfig.X509SVIDs = append(sidecarConfig.X509SVIDs, sidecar.X509SVIDConfig{
+			Hint:                     x509SVID.Hint,
+			SPIFFEID:                 x509SVID.SPIFFEID,
+			SVIDFilename:             x509SVID.SVIDFilename,
+			SVIDKeyFilename:          x509SVID.SVIDKeyFilename,
+			SVIDBundleFilename:       x509SVID.SVIDBundleFilename,
+			CertFileMode:             fs.FileMode(x509SVID.CertFileMode),
+			KeyFileMode:              fs.FileMode(x509SVID.KeyFileMode),
+			AddIntermediatesToBundle: x509SVID.AddIntermediatesToBundle,
+			IncludeFederatedDomains:  x509SVID.IncludeFederatedDomains,
+		})
 	}
 
 	for _, jwtSVID := range config.JWTSVIDs {
@@ -245,6 +564,19 @@ func NewSidecarConfig(config *Config, log logrus.FieldLogger) *sidecar.Config {
 		})
 	}
 
+	for _, notifyTarget := range config.NotifyTargets {
+		sidecarConfig.NotifyTargets = append(sidecarConfig.NotifyTargets, sidecar.NotifyTarget{
+			PIDFilename:       notifyTarget.PIDFilename,
+			ProcessName:       notifyTarget.ProcessName,
+			CmdlineRegex:      notifyTarget.CmdlineRegex,
+			CgroupPath:        notifyTarget.CgroupPath,
+			SystemdUnit:       notifyTarget.SystemdUnit,
+			SystemdUnitAction: notifyTarget.SystemdUnitAction,
+			RenewSignal:       notifyTarget.RenewSignal,
+			CredentialTypes:   notifyTarget.CredentialTypes,
+		})
+	}
+
 	return sidecarConfig
 }
 
@@ -257,12 +589,394 @@ func validateX509Config(c *Config) (bool, error) {
 	return x509EmptyCount == 0, nil
 }
 
+// validateX509SVIDs checks the x509_svid blocks, and defaults their file
+// modes to cert_file_mode and key_file_mode. Every X.509 SVID output must
+// write files of its own, which aren't written by the JWT outputs either.
+func validateX509SVIDs(c *Config, p *problems) {
+	writers := make(map[string]string)
+	claim := func(key, name string) {
+		if writer, ok := writers[name]; ok {
+			p.add(key, fmt.Errorf("%s %q is also written by %s", key, name, writer))
+			return
+		}
+		writers[name] = key
+	}
+	if c.JWTBundleFilename != "" {
+		writers[c.JWTBundleFilename] = "jwt_bundle_file_name"
+	}
+	for i, jwtConfig := range c.JWTSVIDs {
+		if jwtConfig.JWTSVIDFilename != "" {
+			writers[jwtConfig.JWTSVIDFilename] = fmt.Sprintf("jwt_svids[%d].jwt_svid_file_name", i)
+		}
+	}
+	if c.SVIDFilename != "" && c.SVIDKeyFilename != "" && c.SVIDBundleFilename != "" {
+		claim("svid_file_name", c.SVIDFilename)
+		claim("svid_key_file_name", c.SVIDKeyFilename)
+		claim("svid_bundle_file_name", c.SVIDBundleFilename)
+	}
+
+	for i := range c.X509SVIDs {
+		x509SVID := &c.X509SVIDs[i]
+		prefix := fmt.Sprintf("x509_svid[%d].", i)
+		for _, file := range []struct {
+			key, name string
+		}{
+			{"svid_file_name", x509SVID.SVIDFilename},
+			{"svid_key_file_name", x509SVID.SVIDKeyFilename},
+			{"svid_bundle_file_name", x509SVID.SVIDBundleFilename},
+		} {
+			if file.name == "" {
+				p.add(prefix+file.key, fmt.Errorf("'%s' is required in 'x509_svid'", file.key))
+				continue
+			}
+			claim(prefix+file.key, file.name)
+		}
+
+		if x509SVID.Hint != "" && x509SVID.SPIFFEID != "" {
+			p.add(prefix+"spiffe_id", errors.New("only one of 'hint' and 'spiffe_id' can be set in 'x509_svid'"))
+		}
+		if x509SVID.SPIFFEID != "" {
+			if _, err := spiffeid.FromString(x509SVID.SPIFFEID); err != nil {
+				p.add(prefix+"spiffe_id", fmt.Errorf("invalid %sspiffe_id: %w", prefix, err))
+			}
+		}
+
+		for _, fileMode := range []struct {
+			key          string
+			mode         *int
+			defaultValue int
+		}{
+			{"cert_file_mode", &x509SVID.CertFileMode, c.CertFileMode},
+			{"key_file_mode", &x509SVID.KeyFileMode, c.KeyFileMode},
+		} {
+			if *fileMode.mode < 0 {
+				p.add(prefix+fileMode.key, fmt.Errorf("%s%s must be positive", prefix, fileMode.key))
+			} else if *fileMode.mode == 0 {
+				*fileMode.mode = fileMode.defaultValue
+			}
+		}
+	}
+}
+
 func validateJWTConfig(c *Config) (bool, bool) {
 	jwtBundleEmptyCount := countEmpty(c.JWTBundleFilename)
 
 	return jwtBundleEmptyCount == 0, len(c.JWTSVIDs) > 0
 }
 
+func validateCmdArgs(c *Config, log logrus.FieldLogger, p *problems) {
+	cmdArgs, cmdArgv, err := c.cmdArgs()
+	if err != nil {
+		p.add("cmd_args", err)
+		return
+	}
+
+	if c.CmdArgsParser != "" && !slices.Contains(sidecar.CmdArgsParsers, c.CmdArgsParser) {
+		p.add("cmd_args_parser", fmt.Errorf("unknown cmd_args_parser %q, must be one of: %s", c.CmdArgsParser, strings.Join(sidecar.CmdArgsParsers, ",")))
+		return
+	}
+	if cmdArgv != nil {
+		if c.CmdArgsParser != "" {
+			p.add("cmd_args_parser", errors.New("cmd_args_parser is set but cmd_args is a list. cmd_args_parser only applies when cmd_args is a string"))
+		}
+		return
+	}
+
+	if _, err := sidecar.ParseCmdArgs(cmdArgs, c.CmdArgsParser); err != nil {
+		p.add("cmd_args", fmt.Errorf("invalid cmd_args: %w", err))
+		return
+	}
+	if cmdArgs != "" && c.CmdArgsParser == "" {
+		log.Warn("cmd_args as a string is deprecated. Use a list of arguments, or set cmd_args_parser = \"shell\" to split it with shell quoting rules. This may become an error in a future release.")
+	}
+}
+
+func validateCmdStdio(c *Config, p *problems) {
+	if c.CmdStdin != "" && !slices.Contains(cmdStdinModes, c.CmdStdin) {
+		p.add("cmd_stdin", fmt.Errorf("unknown cmd_stdin %q, must be one of: %s", c.CmdStdin, strings.Join(cmdStdinModes, ",")))
+	}
+
+	for _, output := range []struct {
+		key, mode, filenameKey, filename string
+	}{
+		{"cmd_stdout", c.CmdStdout, "cmd_stdout_file_name", c.CmdStdoutFilename},
+		{"cmd_stderr", c.CmdStderr, "cmd_stderr_file_name", c.CmdStderrFilename},
+	} {
+		if output.mode != "" && !slices.Contains(cmdOutputModes, output.mode) {
+			p.add(output.key, fmt.Errorf("unknown %s %q, must be one of: %s", output.key, output.mode, strings.Join(cmdOutputModes, ",")))
+			continue
+		}
+		if output.mode == cmdOutputFile && output.filename == "" {
+			p.add(output.key, fmt.Errorf("'%s' is required when %s is %q", output.filenameKey, output.key, cmdOutputFile))
+		}
+		if output.mode != cmdOutputFile && output.filename != "" {
+			p.add(output.filenameKey, fmt.Errorf("'%s' is set but %s is not %q", output.filenameKey, output.key, cmdOutputFile))
+		}
+	}
+
+	if c.CmdOutputMaxSizeMB < 0 {
+		p.add("cmd_output_max_size_mb", errors.New("cmd_output_max_size_mb must be positive"))
+	}
+	if c.CmdOutputMaxBackups < 0 {
+		p.add("cmd_output_max_backups", errors.New("cmd_output_max_backups must be positive"))
+	}
+}
+
+func validateLogging(c *Config, p *problems) {
+	if c.LogLevel != "" {
+		if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+			p.add("log_level", fmt.Errorf("invalid log_level: %w", err))
+		}
+	}
+	if c.LogFormat != "" && !slices.Contains(logFormats, c.LogFormat) {
+		p.add("log_format", fmt.Errorf("unknown log_format %q, must be one of: %s", c.LogFormat, strings.Join(logFormats, ",")))
+	}
+
+	if c.LogFile == "" && (c.LogFileMaxSizeMB != 0 || c.LogFileMaxBackups != 0) {
+		p.add(firstSetKey(c, "log_file_max_size_mb", "log_file_max_backups"), errors.New("log_file_max_size_mb and log_file_max_backups require log_file"))
+	}
+	if c.LogFileMaxSizeMB < 0 {
+		p.add("log_file_max_size_mb", errors.New("log_file_max_size_mb must be positive"))
+	} else if c.LogFileMaxSizeMB == 0 {
+		c.LogFileMaxSizeMB = defaultLogFileMaxSize
+	}
+	if c.LogFileMaxBackups < 0 {
+		p.add("log_file_max_backups", errors.New("log_file_max_backups must be positive"))
+	} else if c.LogFileMaxBackups == 0 {
+		c.LogFileMaxBackups = defaultLogFileBackups
+	}
+}
+
+// ConfigureLogger applies log_level, log_format and log_file to the logger.
+// The config must have been validated.
+func (c *Config) ConfigureLogger(logger *logrus.Logger) {
+	c.ReconfigureLogger(logger)
+	if c.LogFile != "" {
+		logger.SetOutput(logfile.New(c.LogFile, int64(c.LogFileMaxSizeMB)*1024*1024, c.LogFileMaxBackups))
+	}
+}
+
+// ReconfigureLogger applies log_level and log_format to a logger already
+// configured by ConfigureLogger, when the configuration is reloaded
+func (c *Config) ReconfigureLogger(logger *logrus.Logger) {
+	level := logrus.InfoLevel
+	if c.LogLevel != "" {
+		level, _ = logrus.ParseLevel(c.LogLevel)
+	}
+	logger.SetLevel(level)
+	if c.LogFormat == logFormatJSON {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logger.SetFormatter(&logrus.TextFormatter{})
+	}
+}
+
+func validateAuditLog(c *Config, p *problems) {
+	if c.AuditLogFile == "" && c.AuditLogFileMode != 0 {
+		p.add("audit_log_file_mode", errors.New("audit_log_file_mode requires audit_log_file"))
+	}
+	if c.AuditLogFileMode < 0 {
+		p.add("audit_log_file_mode", errors.New("audit_log_file_mode must be positive"))
+	} else if c.AuditLogFileMode == 0 {
+		c.AuditLogFileMode = defaultAuditLogFileMode
+	}
+}
+
+func validateTracing(c *Config, p *problems) {
+	if c.TracingOTLPEndpoint == "" {
+		if c.TracingOTLPInsecure || c.TracingServiceName != "" {
+			p.add(firstSetKey(c, "tracing_otlp_insecure", "tracing_service_name"), errors.New("tracing_otlp_insecure and tracing_service_name require tracing_otlp_endpoint"))
+		}
+		return
+	}
+	if _, _, err := net.SplitHostPort(c.TracingOTLPEndpoint); err != nil {
+		p.add("tracing_otlp_endpoint", fmt.Errorf("invalid tracing_otlp_endpoint: %w", err))
+	}
+	if c.TracingServiceName == "" {
+		c.TracingServiceName = defaultTracingService
+	}
+}
+
+// NewTracerProvider returns a TracerProvider exporting spans over OTLP/gRPC
+// to tracing_otlp_endpoint, or nil if tracing is disabled. It must be shut
+// down to flush the spans still buffered.
+func (c *Config) NewTracerProvider(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	if c.TracingOTLPEndpoint == "" {
+		return nil, nil
+	}
+
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(c.TracingOTLPEndpoint)}
+	if c.TracingOTLPInsecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(c.TracingServiceName))),
+	), nil
+}
+
+// cmdStdio returns the stdin, stdout and stderr to connect to 'cmd'. nil
+// means the helper's own. Files written to must be closed by the caller once
+// 'cmd' has exited.
+func (c *Config) cmdStdio(log logrus.FieldLogger) (io.Reader, io.Writer, io.Writer) {
+	var stdin io.Reader
+	switch c.CmdStdin {
+	case cmdStdinNull:
+		stdin = sidecar.StdinNull
+	case cmdStdinClosed:
+		stdin = sidecar.StdinClosed
+	}
+
+	maxSizeMB, maxBackups := c.cmdOutputRotation()
+	var stdoutFile *logfile.Writer
+	output := func(stream string, mode string, filename string, level logrus.Level) io.Writer {
+		switch mode {
+		case cmdOutputLog:
+			return sidecar.NewLogWriter(log.WithFields(logrus.Fields{
+				"cmd":    c.Cmd,
+				"stream": stream,
+			}), level)
+		case cmdOutputFile:
+			// stdout and stderr may share a file, which must then be
+			// rotated by a single writer
+			if stdoutFile != nil && stdoutFile.Filename() == filename {
+				return stdoutFile
+			}
+			w := logfile.New(filename, int64(maxSizeMB)*1024*1024, maxBackups)
+			if stream == "stdout" {
+				stdoutFile = w
+			}
+			return w
+		case cmdOutputDiscard:
+			return io.Discard
+		default:
+			return nil
+		}
+	}
+
+	stdout := output("stdout", c.CmdStdout, c.CmdStdoutFilename, logrus.InfoLevel)
+	stderr := output("stderr", c.CmdStderr, c.CmdStderrFilename, logrus.WarnLevel)
+	return stdin, stdout, stderr
+}
+
+// cmdOutputRotation returns cmd_output_max_size_mb and cmd_output_max_backups,
+// or their defaults if they aren't set
+func (c *Config) cmdOutputRotation() (int, int) {
+	maxSizeMB, maxBackups := c.CmdOutputMaxSizeMB, c.CmdOutputMaxBackups
+	if maxSizeMB == 0 {
+		maxSizeMB = defaultCmdOutputMaxSize
+	}
+	if maxBackups == 0 {
+		maxBackups = defaultCmdOutputBackups
+	}
+	return maxSizeMB, maxBackups
+}
+
+// cmdArgs returns cmd_args either as a string to be parsed, or as an
+// argument vector if it was given as a list.
+func (c *Config) cmdArgs() (string, []string, error) {
+	switch cmdArgs := c.CmdArgs.(type) {
+	case nil:
+		return "", nil, nil
+	case string:
+		return cmdArgs, nil, nil
+	case []interface{}:
+		cmdArgv := make([]string, 0, len(cmdArgs))
+		for i, arg := range cmdArgs {
+			s, ok := arg.(string)
+			if !ok {
+				return "", nil, fmt.Errorf("cmd_args[%d] must be a string", i)
+			}
+			cmdArgv = append(cmdArgv, s)
+		}
+		return "", cmdArgv, nil
+	case []string:
+		return "", cmdArgs, nil
+	default:
+		return "", nil, errors.New("cmd_args must be a string or a list of strings")
+	}
+}
+
+func validateNotifyTargets(c *Config, p *problems) {
+	if len(c.NotifyTargets) > 0 && c.DaemonMode != nil && !*c.DaemonMode {
+		p.add("notify_targets", errors.New("notify_targets is set but daemon_mode is false. notify_targets is only supported in daemon_mode"))
+	}
+
+	for i, notifyTarget := range c.NotifyTargets {
+		key := fmt.Sprintf("notify_targets[%d]", i)
+		selectorsEmptyCount := countEmpty(notifyTarget.ProcessName, notifyTarget.CmdlineRegex, notifyTarget.CgroupPath)
+		if notifyTarget.SystemdUnit != "" {
+			if notifyTarget.PIDFilename != "" || selectorsEmptyCount != 3 {
+				p.add(key+".systemd_unit", fmt.Errorf("'systemd_unit' cannot be combined with 'pid_file_name', 'process_name', 'cmdline_regex' or 'cgroup_path' in notify_targets[%d]", i))
+			}
+			if notifyTarget.RenewSignal != "" {
+				p.add(key+".renew_signal", fmt.Errorf("'renew_signal' cannot be combined with 'systemd_unit' in notify_targets[%d]", i))
+			}
+			if notifyTarget.SystemdUnitAction != "" && !slices.Contains(systemd.UnitActions, notifyTarget.SystemdUnitAction) {
+				p.add(key+".systemd_unit_action", fmt.Errorf("unknown systemd unit action %q in notify_targets[%d], must be one of: %s", notifyTarget.SystemdUnitAction, i, strings.Join(systemd.UnitActions, ",")))
+			}
+		} else {
+			if notifyTarget.PIDFilename == "" && selectorsEmptyCount == 3 {
+				p.add(key, fmt.Errorf("one of 'pid_file_name', 'process_name', 'cmdline_regex', 'cgroup_path' or 'systemd_unit' is required in notify_targets[%d]", i))
+			}
+			if notifyTarget.PIDFilename != "" && selectorsEmptyCount != 3 {
+				p.add(key+".pid_file_name", fmt.Errorf("'pid_file_name' cannot be combined with 'process_name', 'cmdline_regex' or 'cgroup_path' in notify_targets[%d]", i))
+			}
+			if notifyTarget.SystemdUnitAction != "" {
+				p.add(key+".systemd_unit_action", fmt.Errorf("'systemd_unit_action' requires 'systemd_unit' in notify_targets[%d]", i))
+			}
+			if notifyTarget.RenewSignal == "" {
+				p.add(key, fmt.Errorf("'renew_signal' is required in notify_targets[%d]", i))
+			}
+		}
+		if notifyTarget.CmdlineRegex != "" {
+			if _, err := regexp.Compile(notifyTarget.CmdlineRegex); err != nil {
+				p.add(key+".cmdline_regex", fmt.Errorf("invalid 'cmdline_regex' in notify_targets[%d]: %w", i, err))
+			}
+		}
+		for _, credentialType := range notifyTarget.CredentialTypes {
+			if !slices.Contains(sidecar.CredentialTypes, credentialType) {
+				p.add(key+".credential_types", fmt.Errorf("unknown credential type %q in notify_targets[%d], must be one of: %s", credentialType, i, strings.Join(sidecar.CredentialTypes, ",")))
+			}
+		}
+	}
+}
+
+// parseDuration parses a duration such as "1m30s" from the config. Empty
+// values mean zero.
+func parseDuration(key string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("%s must be positive", key)
+	}
+
+	return duration, nil
+}
+
+// firstSetKey returns the first of the keys that is set in the
+// configuration file, or the first key if none is, to report a problem
+// involving several keys
+func firstSetKey(c *Config, keys ...string) string {
+	for _, key := range keys {
+		if _, ok := c.positions[key]; ok {
+			return key
+		}
+	}
+	return keys[0]
+}
+
 func countEmpty(configs ...string) int {
 	cnt := 0
 	for _, config := range configs {
@@ -274,17 +988,6 @@ func countEmpty(configs ...string) int {
 	return cnt
 }
 
-func isFlagPassed(name string) bool {
-	var found bool
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == name {
-			found = true
-		}
-	})
-
-	return found
-}
-
 func mapKeysToString[V any](myMap map[string]V) string {
 	keys := make([]string, 0, len(myMap))
 	for key := range myMap {
@@ -3,6 +3,14 @@
 
 package config
 
-func validateOSConfig(*Config) error {
-	return nil
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func validateOSConfig(c *Config, p *problems) {
+	if c.CmdStopSignal != "" && unix.SignalNum(c.CmdStopSignal) == 0 {
+		p.add("cmd_stop_signal", fmt.Errorf("unknown cmd_stop_signal %q, must be a signal name such as SIGTERM", c.CmdStopSignal))
+	}
 }
@@ -1,55 +1,66 @@
 package config
 
 import (
-	"flag"
+	"context"
+	"encoding/json"
+	"io"
 	"os"
+	"path"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spiffe/spiffe-helper/pkg/health"
+	"github.com/spiffe/spiffe-helper/pkg/logfile"
+	"github.com/spiffe/spiffe-helper/pkg/sidecar"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-const (
-	daemonModeFlagName = "daemon-mode"
-)
-
 func TestParseConfig(t *testing.T) {
-	c, err := ParseConfigFile("testdata/helper.conf")
-
-	assert.NoError(t, err)
-	assert.NoError(t, c.checkForUnknownConfig())
-
-	expectedAgentAddress := "/tmp/spire-agent/public/api.sock"
-	expectedCmd := "hot-restarter.py"
-	expectedCmdArgs := "start_envoy.sh"
-	expectedCertDir := "certs"
-	expectedRenewSignal := "SIGHUP"
-	expectedSVIDFilename := "svid.pem"
-	expectedKeyFilename := "svid_key.pem"
-	expectedSVIDBundleFilename := "svid_bundle.pem"
-	expectedJWTSVIDFilename := "jwt_svid.token"
-	expectedJWTBundleFilename := "jwt_bundle.json"
-	expectedJWTAudience := "your-audience"
-	expectedJWTExtraAudiences := []string{"your-extra-audience-1", "your-extra-audience-2"}
-
-	assert.Equal(t, expectedAgentAddress, c.AgentAddress)
-	assert.Equal(t, expectedCmd, c.Cmd)
-	assert.Equal(t, expectedCmdArgs, c.CmdArgs)
-	assert.Equal(t, expectedCertDir, c.CertDir)
-	assert.Equal(t, expectedRenewSignal, c.RenewSignal)
-	assert.Equal(t, expectedSVIDFilename, c.SVIDFilename)
-	assert.Equal(t, expectedKeyFilename, c.SVIDKeyFilename)
-	assert.Equal(t, expectedSVIDBundleFilename, c.SVIDBundleFilename)
-	assert.Equal(t, expectedJWTSVIDFilename, c.JWTSVIDs[0].JWTSVIDFilename)
-	assert.Equal(t, expectedJWTBundleFilename, c.JWTBundleFilename)
-	assert.Equal(t, expectedJWTAudience, c.JWTSVIDs[0].JWTAudience)
-	assert.Equal(t, expectedJWTExtraAudiences, c.JWTSVIDs[0].JWTExtraAudiences)
-	assert.True(t, c.AddIntermediatesToBundle)
-	assert.Equal(t, 444, c.CertFileMode)
-	assert.Equal(t, 444, c.KeyFileMode)
-	assert.Equal(t, 444, c.JWTBundleFileMode)
-	assert.Equal(t, 444, c.JWTSVIDFileMode)
+	// The same configuration in every format
+	for _, file := range []string{"testdata/helper.conf", "testdata/helper.json", "testdata/helper.yaml"} {
+		t.Run(file, func(t *testing.T) {
+			c, err := ParseConfigFile(file)
+
+			assert.NoError(t, err)
+			assert.NoError(t, c.checkForUnknownConfig())
+
+			expectedAgentAddress := "/tmp/spire-agent/public/api.sock"
+			expectedCmd := "hot-restarter.py"
+			expectedCmdArgs := "start_envoy.sh"
+			expectedCertDir := "certs"
+			expectedRenewSignal := "SIGHUP"
+			expectedSVIDFilename := "svid.pem"
+			expectedKeyFilename := "svid_key.pem"
+			expectedSVIDBundleFilename := "svid_bundle.pem"
+			expectedJWTSVIDFilename := "jwt_svid.token"
+			expectedJWTBundleFilename := "jwt_bundle.json"
+			expectedJWTAudience := "your-audience"
+			expectedJWTExtraAudiences := []string{"your-extra-audience-1", "your-extra-audience-2"}
+
+			assert.Equal(t, expectedAgentAddress, c.AgentAddress)
+			assert.Equal(t, expectedCmd, c.Cmd)
+			assert.Equal(t, expectedCmdArgs, c.CmdArgs)
+			assert.Equal(t, expectedCertDir, c.CertDir)
+			assert.Equal(t, expectedRenewSignal, c.RenewSignal)
+			assert.Equal(t, expectedSVIDFilename, c.SVIDFilename)
+			assert.Equal(t, expectedKeyFilename, c.SVIDKeyFilename)
+			assert.Equal(t, expectedSVIDBundleFilename, c.SVIDBundleFilename)
+			assert.Equal(t, expectedJWTSVIDFilename, c.JWTSVIDs[0].JWTSVIDFilename)
+			assert.Equal(t, expectedJWTBundleFilename, c.JWTBundleFilename)
+			assert.Equal(t, expectedJWTAudience, c.JWTSVIDs[0].JWTAudience)
+			assert.Equal(t, expectedJWTExtraAudiences, c.JWTSVIDs[0].JWTExtraAudiences)
+			assert.True(t, c.AddIntermediatesToBundle)
+			assert.Equal(t, 444, c.CertFileMode)
+			assert.Equal(t, 444, c.KeyFileMode)
+			assert.Equal(t, 444, c.JWTBundleFileMode)
+			assert.Equal(t, 444, c.JWTSVIDFileMode)
+		})
+	}
 }
 
 func TestValidateConfig(t *testing.T) {
@@ -86,6 +97,16 @@ func TestValidateConfig(t *testing.T) {
 				JWTBundleFilename: "bundle.json",
 			},
 		},
+		{
+			name: "no error with x509_svid blocks only",
+			config: &Config{
+				AgentAddress: "path",
+				X509SVIDs: []X509SVIDConfig{
+					{SPIFFEID: "spiffe://example.org/db", SVIDFilename: "db.pem", SVIDKeyFilename: "db_key.pem", SVIDBundleFilename: "db_bundle.pem"},
+					{Hint: "web", SVIDFilename: "web.pem", SVIDKeyFilename: "web_key.pem", SVIDBundleFilename: "web_bundle.pem"},
+				},
+			},
+		},
 		{
 			name: "no error in oneshot mode",
 			config: &Config{
@@ -101,7 +122,7 @@ func TestValidateConfig(t *testing.T) {
 			config: &Config{
 				AgentAddress: "path",
 			},
-			expectError: "at least one of the sets ('svid_file_name', 'svid_key_file_name', 'svid_bundle_file_name'), 'jwt_svids', or 'jwt_bundle_file_name' must be fully specified",
+			expectError: "at least one of the sets ('svid_file_name', 'svid_key_file_name', 'svid_bundle_file_name'), 'x509_svid', 'jwt_svids', or 'jwt_bundle_file_name' must be fully specified",
 		},
 		{
 			name: "missing svid config",
@@ -111,6 +132,80 @@ func TestValidateConfig(t *testing.T) {
 			},
 			expectError: "all or none of 'svid_file_name', 'svid_key_file_name', 'svid_bundle_file_name' must be specified",
 		},
+		{
+			name: "missing x509_svid file",
+			config: &Config{
+				AgentAddress: "path",
+				X509SVIDs:    []X509SVIDConfig{{SVIDFilename: "db.pem", SVIDKeyFilename: "db_key.pem"}},
+			},
+			expectError: "'svid_bundle_file_name' is required in 'x509_svid'",
+		},
+		{
+			name: "x509_svid with both hint and spiffe_id",
+			config: &Config{
+				AgentAddress: "path",
+				X509SVIDs: []X509SVIDConfig{
+					{Hint: "db", SPIFFEID: "spiffe://example.org/db", SVIDFilename: "db.pem", SVIDKeyFilename: "db_key.pem", SVIDBundleFilename: "db_bundle.pem"},
+				},
+			},
+			expectError: "only one of 'hint' and 'spiffe_id' can be set in 'x509_svid'",
+		},
+		{
+			name: "invalid x509_svid spiffe_id",
+			config: &Config{
+				AgentAddress: "path",
+				X509SVIDs: []X509SVIDConfig{
+					{SPIFFEID: "example.org/db", SVIDFilename: "db.pem", SVIDKeyFilename: "db_key.pem", SVIDBundleFilename: "db_bundle.pem"},
+				},
+			},
+			expectError: "invalid x509_svid[0].spiffe_id: scheme is missing or invalid",
+		},
+		{
+			name: "x509_svid writing the file of another output",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				X509SVIDs: []X509SVIDConfig{
+					{Hint: "db", SVIDFilename: "db.pem", SVIDKeyFilename: "db_key.pem", SVIDBundleFilename: "bundle.pem"},
+				},
+			},
+			expectError: `x509_svid[0].svid_bundle_file_name "bundle.pem" is also written by svid_bundle_file_name`,
+		},
+		{
+			name: "x509_svid writing the file of a JWT output",
+			config: &Config{
+				AgentAddress:      "path",
+				JWTBundleFilename: "jwt_bundle.json",
+				JWTSVIDs:          []JWTConfig{{JWTAudience: "aud", JWTSVIDFilename: "jwt.token"}},
+				X509SVIDs: []X509SVIDConfig{
+					{Hint: "db", SVIDFilename: "jwt.token", SVIDKeyFilename: "db_key.pem", SVIDBundleFilename: "db_bundle.pem"},
+				},
+			},
+			expectError: `x509_svid[0].svid_file_name "jwt.token" is also written by jwt_svids[0].jwt_svid_file_name`,
+		},
+		{
+			name: "svid_file_name writing the JWT bundle",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "jwt_bundle.json",
+				JWTBundleFilename:  "jwt_bundle.json",
+			},
+			expectError: `svid_bundle_file_name "jwt_bundle.json" is also written by jwt_bundle_file_name`,
+		},
+		{
+			name: "negative x509_svid key_file_mode",
+			config: &Config{
+				AgentAddress: "path",
+				X509SVIDs: []X509SVIDConfig{
+					{SVIDFilename: "db.pem", SVIDKeyFilename: "db_key.pem", SVIDBundleFilename: "db_bundle.pem", KeyFileMode: -1},
+				},
+			},
+			expectError: "x509_svid[0].key_file_mode must be positive",
+		},
 		{
 			name: "missing jwt audience",
 			config: &Config{
@@ -184,6 +279,589 @@ func TestValidateConfig(t *testing.T) {
 			},
 			skipWindows: true,
 		},
+		{
+			name: "no error with notify_targets",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				NotifyTargets: []NotifyTargetConfig{
+					{
+						PIDFilename:     "pidfile",
+						RenewSignal:     "SIGHUP",
+						CredentialTypes: []string{"x509", "jwt_svid", "jwt_bundle"},
+					},
+					{
+						PIDFilename: "other-pidfile",
+						RenewSignal: "SIGUSR1",
+					},
+				},
+			},
+			skipWindows: true,
+		},
+		{
+			name: "renew_signal required in notify_targets",
+			config: &Config{
+				NotifyTargets: []NotifyTargetConfig{{
+					PIDFilename: "pidfile",
+				}},
+			},
+			expectError: "'renew_signal' is required in notify_targets[0]",
+			skipWindows: true,
+		},
+		{
+			name: "pid_file_name required in notify_targets",
+			config: &Config{
+				NotifyTargets: []NotifyTargetConfig{{
+					RenewSignal: "SIGHUP",
+				}},
+			},
+			expectError: "one of 'pid_file_name', 'process_name', 'cmdline_regex', 'cgroup_path' or 'systemd_unit' is required in notify_targets[0]",
+			skipWindows: true,
+		},
+		{
+			name: "no error with notify_targets selecting processes",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				NotifyTargets: []NotifyTargetConfig{
+					{
+						ProcessName: "nginx",
+						RenewSignal: "SIGHUP",
+					},
+					{
+						CmdlineRegex: "^envoy .*-c /etc/envoy.yaml",
+						CgroupPath:   "/kubepods/pod1",
+						RenewSignal:  "SIGHUP",
+					},
+				},
+			},
+			skipWindows: true,
+		},
+		{
+			name: "pid_file_name combined with process selectors in notify_targets",
+			config: &Config{
+				NotifyTargets: []NotifyTargetConfig{{
+					PIDFilename: "pidfile",
+					ProcessName: "nginx",
+					RenewSignal: "SIGHUP",
+				}},
+			},
+			expectError: "'pid_file_name' cannot be combined with 'process_name', 'cmdline_regex' or 'cgroup_path' in notify_targets[0]",
+			skipWindows: true,
+		},
+		{
+			name: "no error with notify_targets reloading systemd units",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				NotifyTargets: []NotifyTargetConfig{
+					{
+						SystemdUnit: "nginx.service",
+					},
+					{
+						SystemdUnit:       "envoy.service",
+						SystemdUnitAction: "restart",
+					},
+				},
+			},
+			skipWindows: true,
+		},
+		{
+			name: "systemd_unit combined with process selectors in notify_targets",
+			config: &Config{
+				NotifyTargets: []NotifyTargetConfig{{
+					SystemdUnit: "nginx.service",
+					ProcessName: "nginx",
+				}},
+			},
+			expectError: "'systemd_unit' cannot be combined with 'pid_file_name', 'process_name', 'cmdline_regex' or 'cgroup_path' in notify_targets[0]",
+			skipWindows: true,
+		},
+		{
+			name: "systemd_unit combined with renew_signal in notify_targets",
+			config: &Config{
+				NotifyTargets: []NotifyTargetConfig{{
+					SystemdUnit: "nginx.service",
+					RenewSignal: "SIGHUP",
+				}},
+			},
+			expectError: "'renew_signal' cannot be combined with 'systemd_unit' in notify_targets[0]",
+			skipWindows: true,
+		},
+		{
+			name: "unknown systemd_unit_action in notify_targets",
+			config: &Config{
+				NotifyTargets: []NotifyTargetConfig{{
+					SystemdUnit:       "nginx.service",
+					SystemdUnitAction: "stop",
+				}},
+			},
+			expectError: "unknown systemd unit action \"stop\" in notify_targets[0], must be one of: reload,restart,try-restart,reload-or-restart",
+			skipWindows: true,
+		},
+		{
+			name: "invalid cmdline_regex in notify_targets",
+			config: &Config{
+				NotifyTargets: []NotifyTargetConfig{{
+					CmdlineRegex: "(",
+					RenewSignal:  "SIGHUP",
+				}},
+			},
+			expectError: "invalid 'cmdline_regex' in notify_targets[0]: error parsing regexp: missing closing ): `(`",
+			skipWindows: true,
+		},
+		{
+			name: "unknown credential type in notify_targets",
+			config: &Config{
+				NotifyTargets: []NotifyTargetConfig{{
+					PIDFilename:     "pidfile",
+					RenewSignal:     "SIGHUP",
+					CredentialTypes: []string{"x509", "jwt"},
+				}},
+			},
+			expectError: "unknown credential type \"jwt\" in notify_targets[0], must be one of: x509,jwt_svid,jwt_bundle",
+			skipWindows: true,
+		},
+		{
+			name: "notify_targets set in !daemon_mode",
+			config: &Config{
+				DaemonMode: &[]bool{false}[0],
+				NotifyTargets: []NotifyTargetConfig{{
+					PIDFilename: "pidfile",
+					RenewSignal: "SIGHUP",
+				}},
+			},
+			expectError: "notify_targets is set but daemon_mode is false. notify_targets is only supported in daemon_mode",
+			skipWindows: true,
+		},
+		{
+			name: "no error with notify_debounce, notify_max_delay and notify_min_interval",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				NotifyDebounce:     "500ms",
+				NotifyMaxDelay:     "5s",
+				NotifyMinInterval:  "1m",
+			},
+		},
+		{
+			name: "negative notify_max_delay",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				NotifyMaxDelay:     "-1s",
+			},
+			expectError: "notify_max_delay must be positive",
+		},
+		{
+			name: "invalid notify_debounce",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				NotifyDebounce:     "5",
+			},
+			expectError: "invalid notify_debounce: time: missing unit in duration \"5\"",
+		},
+		{
+			name: "negative notify_min_interval",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				NotifyMinInterval:  "-1s",
+			},
+			expectError: "notify_min_interval must be positive",
+		},
+		{
+			name: "cmd_args with shell parser",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdArgs:            "-c 'echo hello'",
+				CmdArgsParser:      "shell",
+			},
+		},
+		{
+			name: "unknown cmd_args_parser",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdArgs:            "-c 'echo hello'",
+				CmdArgsParser:      "csv",
+			},
+			expectError: "unknown cmd_args_parser \"csv\", must be one of: legacy,shell",
+		},
+		{
+			name: "invalid cmd_args",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdArgs:            "-c 'echo hello",
+				CmdArgsParser:      "shell",
+			},
+			expectError: "invalid cmd_args: unterminated single-quoted string",
+		},
+		{
+			name: "cmd_args list with cmd_args_parser",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdArgs:            []interface{}{"-c", "echo hello"},
+				CmdArgsParser:      "shell",
+			},
+			expectError: "cmd_args_parser is set but cmd_args is a list. cmd_args_parser only applies when cmd_args is a string",
+		},
+		{
+			name: "cmd_args list with non-string",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdArgs:            []interface{}{"--port", 8080},
+			},
+			expectError: "cmd_args[1] must be a string",
+		},
+		{
+			name: "cmd_args neither string nor list",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdArgs:            8080,
+			},
+			expectError: "cmd_args must be a string or a list of strings",
+		},
+		{
+			name: "invalid cmd_stop_timeout",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdStopTimeout:     "soon",
+			},
+			expectError: "invalid cmd_stop_timeout: time: invalid duration \"soon\"",
+		},
+		{
+			name: "invalid cmd_stop_signal",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdStopSignal:      "SIGSTOPPLEASE",
+			},
+			expectError: "unknown cmd_stop_signal \"SIGSTOPPLEASE\", must be a signal name such as SIGTERM",
+			skipWindows: true,
+		},
+		{
+			name: "invalid health_checks.readiness_min_lifetime",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				HealthCheck: health.Config{
+					ReadinessMinLifetime: "-5m",
+				},
+			},
+			expectError: "health_checks.readiness_min_lifetime must be positive",
+		},
+		{
+			name: "invalid health_checks.liveness_update_window",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				HealthCheck: health.Config{
+					LivenessUpdateWindow: "1 hour",
+				},
+			},
+			expectError: "invalid health_checks.liveness_update_window: time: unknown unit \" hour\" in duration \"1 hour\"",
+		},
+		{
+			name: "health listener on a Unix socket with TLS",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				HealthCheck: health.Config{
+					ListenerEnabled:     true,
+					UnixSocketPath:      "/run/spiffe-helper/health.sock",
+					TLSEnabled:          true,
+					TLSAllowedSPIFFEIDs: []string{"spiffe://example.org/prometheus"},
+				},
+			},
+		},
+		{
+			name: "health gRPC listener on the HTTP port",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				HealthCheck: health.Config{
+					ListenerEnabled:     true,
+					GRPCListenerEnabled: true,
+					GRPCBindPort:        8081,
+				},
+			},
+			expectError: "health_checks.bind_port and health_checks.grpc_bind_port must differ",
+		},
+		{
+			name: "invalid log_level",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				LogLevel:           "verbose",
+			},
+			expectError: "invalid log_level: not a valid logrus Level: \"verbose\"",
+		},
+		{
+			name: "unknown log_format",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				LogFormat:          "logfmt",
+			},
+			expectError: "unknown log_format \"logfmt\", must be one of: text,json",
+		},
+		{
+			name: "log file rotation without log_file",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				LogFileMaxBackups:  5,
+			},
+			expectError: "log_file_max_size_mb and log_file_max_backups require log_file",
+		},
+		{
+			name: "audit_log_file_mode without audit_log_file",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				AuditLogFileMode:   0640,
+			},
+			expectError: "audit_log_file_mode requires audit_log_file",
+		},
+		{
+			name: "negative audit_log_file_mode",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				AuditLogFile:       "audit.log",
+				AuditLogFileMode:   -1,
+			},
+			expectError: "audit_log_file_mode must be positive",
+		},
+		{
+			name: "invalid tracing_otlp_endpoint",
+			config: &Config{
+				AgentAddress:        "path",
+				SVIDFilename:        "cert.pem",
+				SVIDKeyFilename:     "key.pem",
+				SVIDBundleFilename:  "bundle.pem",
+				TracingOTLPEndpoint: "collector",
+			},
+			expectError: "invalid tracing_otlp_endpoint: address collector: missing port in address",
+		},
+		{
+			name: "tracing options without tracing_otlp_endpoint",
+			config: &Config{
+				AgentAddress:        "path",
+				SVIDFilename:        "cert.pem",
+				SVIDKeyFilename:     "key.pem",
+				SVIDBundleFilename:  "bundle.pem",
+				TracingOTLPInsecure: true,
+			},
+			expectError: "tracing_otlp_insecure and tracing_service_name require tracing_otlp_endpoint",
+		},
+		{
+			name: "health bind_address and unix_socket_path",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				HealthCheck: health.Config{
+					ListenerEnabled: true,
+					BindAddress:     "127.0.0.1",
+					UnixSocketPath:  "/run/spiffe-helper/health.sock",
+				},
+			},
+			expectError: "health_checks.bind_address and health_checks.unix_socket_path are mutually exclusive",
+		},
+		{
+			name: "health gRPC and unix_socket_path",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				HealthCheck: health.Config{
+					ListenerEnabled:     true,
+					GRPCListenerEnabled: true,
+					UnixSocketPath:      "/run/spiffe-helper/health.sock",
+				},
+			},
+			expectError: "the gRPC health service can't listen on health_checks.unix_socket_path, so health_checks.grpc_listener_enabled can't be set with it",
+		},
+		{
+			name: "health TLS without X.509 SVID",
+			config: &Config{
+				AgentAddress:      "path",
+				JWTBundleFilename: "bundle.json",
+				HealthCheck: health.Config{
+					ListenerEnabled: true,
+					TLSEnabled:      true,
+				},
+			},
+			expectError: "health_checks.tls_enabled requires 'svid_file_name', 'svid_key_file_name' and 'svid_bundle_file_name', or an 'x509_svid', as the health server uses the helper's X.509 SVID",
+		},
+		{
+			name: "health TLS allowlist without TLS",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				HealthCheck: health.Config{
+					ListenerEnabled:     true,
+					TLSAllowedSPIFFEIDs: []string{"spiffe://example.org/prometheus"},
+				},
+			},
+			expectError: "health_checks.tls_allowed_spiffe_ids is set but health_checks.tls_enabled is false",
+		},
+		{
+			name: "invalid health TLS allowlist",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				HealthCheck: health.Config{
+					ListenerEnabled:     true,
+					TLSEnabled:          true,
+					TLSAllowedSPIFFEIDs: []string{"prometheus"},
+				},
+			},
+			expectError: "invalid health_checks.tls_allowed_spiffe_ids: scheme is missing or invalid",
+		},
+		{
+			name: "cmd stdio",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdStdin:           "null",
+				CmdStdout:          "log",
+				CmdStderr:          "file",
+				CmdStderrFilename:  "stderr.log",
+			},
+		},
+		{
+			name: "unknown cmd_stdin",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdStdin:           "tty",
+			},
+			expectError: "unknown cmd_stdin \"tty\", must be one of: inherit,null,closed",
+		},
+		{
+			name: "unknown cmd_stdout",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdStdout:          "syslog",
+			},
+			expectError: "unknown cmd_stdout \"syslog\", must be one of: inherit,log,file,discard",
+		},
+		{
+			name: "cmd_stderr file without file name",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdStderr:          "file",
+			},
+			expectError: "'cmd_stderr_file_name' is required when cmd_stderr is \"file\"",
+		},
+		{
+			name: "cmd_stdout_file_name without file output",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdStdout:          "log",
+				CmdStdoutFilename:  "stdout.log",
+			},
+			expectError: "'cmd_stdout_file_name' is set but cmd_stdout is not \"file\"",
+		},
+		{
+			name: "negative cmd_output_max_size_mb",
+			config: &Config{
+				AgentAddress:       "path",
+				SVIDFilename:       "cert.pem",
+				SVIDKeyFilename:    "key.pem",
+				SVIDBundleFilename: "bundle.pem",
+				CmdOutputMaxSizeMB: -1,
+			},
+			expectError: "cmd_output_max_size_mb must be positive",
+		},
+		{
+			name: "negative cmd_output_max_backups",
+			config: &Config{
+				AgentAddress:        "path",
+				SVIDFilename:        "cert.pem",
+				SVIDKeyFilename:     "key.pem",
+				SVIDBundleFilename:  "bundle.pem",
+				CmdOutputMaxBackups: -1,
+			},
+			expectError: "cmd_output_max_backups must be positive",
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.skipWindows && os.Getenv("GOOS") == "windows" {
@@ -202,6 +880,196 @@ func TestValidateConfig(t *testing.T) {
 	}
 }
 
+func TestCmdArgs(t *testing.T) {
+	for _, tt := range []struct {
+		name            string
+		hcl             string
+		expectedCmdArgs string
+		expectedCmdArgv []string
+		expectWarning   bool
+	}{
+		{
+			name:            "list",
+			hcl:             `cmd_args = ["-c", "echo 'hello world'", ""]`,
+			expectedCmdArgv: []string{"-c", "echo 'hello world'", ""},
+		},
+		{
+			name:            "empty list",
+			hcl:             `cmd_args = []`,
+			expectedCmdArgv: []string{},
+		},
+		{
+			name:            "legacy string",
+			hcl:             `cmd_args = "-c \"echo hello\""`,
+			expectedCmdArgs: `-c "echo hello"`,
+			expectWarning:   true,
+		},
+		{
+			name: "shell string",
+			hcl: `cmd_args = "-c 'echo hello'"
+cmd_args_parser = "shell"`,
+			expectedCmdArgs: "-c 'echo hello'",
+		},
+		{
+			name: "unset",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := new(Config)
+			require.NoError(t, hcl.Decode(c, tt.hcl))
+			c.AgentAddress = "path"
+			c.JWTBundleFilename = "bundle.json"
+
+			log, hook := test.NewNullLogger()
+			require.NoError(t, c.ValidateConfig(log))
+			if tt.expectWarning {
+				require.NotNil(t, hook.LastEntry())
+				assert.Contains(t, hook.LastEntry().Message, "cmd_args as a string is deprecated")
+			} else {
+				assert.Nil(t, hook.LastEntry())
+			}
+
+			sidecarConfig := NewSidecarConfig(c, log)
+			assert.Equal(t, tt.expectedCmdArgs, sidecarConfig.CmdArgs)
+			assert.Equal(t, tt.expectedCmdArgv, sidecarConfig.CmdArgv)
+			assert.Equal(t, c.CmdArgsParser, sidecarConfig.CmdArgsParser)
+		})
+	}
+}
+
+func TestCmdStdio(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{
+		AgentAddress:      "path",
+		JWTBundleFilename: "bundle.json",
+		Cmd:               "envoy",
+		CmdStdin:          "closed",
+		CmdStdout:         "file",
+		CmdStdoutFilename: path.Join(dir, "envoy.log"),
+		CmdStderr:         "file",
+		CmdStderrFilename: path.Join(dir, "envoy.log"),
+	}
+	log, _ := test.NewNullLogger()
+	require.NoError(t, config.ValidateConfig(log))
+	// Defaults are applied when building the writers, not by validation
+	assert.Zero(t, config.CmdOutputMaxSizeMB)
+	assert.Zero(t, config.CmdOutputMaxBackups)
+
+	sidecarConfig := NewSidecarConfig(config, log)
+	assert.Equal(t, sidecar.StdinClosed, sidecarConfig.CmdStdin)
+	require.IsType(t, &logfile.Writer{}, sidecarConfig.CmdStdout)
+	assert.Equal(t, path.Join(dir, "envoy.log"), sidecarConfig.CmdStdout.(*logfile.Writer).Filename())
+	// stdout and stderr sharing a file share its writer
+	assert.Same(t, sidecarConfig.CmdStdout, sidecarConfig.CmdStderr)
+
+	config.CmdStdin = "null"
+	config.CmdStdout = "log"
+	config.CmdStdoutFilename = ""
+	config.CmdStderr = "discard"
+	config.CmdStderrFilename = ""
+	sidecarConfig = NewSidecarConfig(config, log)
+	assert.Equal(t, sidecar.StdinNull, sidecarConfig.CmdStdin)
+	assert.IsType(t, &sidecar.LogWriter{}, sidecarConfig.CmdStdout)
+	assert.Equal(t, io.Discard, sidecarConfig.CmdStderr)
+
+	// stdout is logged at info and stderr at warning
+	log, hook := test.NewNullLogger()
+	config.CmdStderr = "log"
+	sidecarConfig = NewSidecarConfig(config, log)
+	_, err := sidecarConfig.CmdStdout.Write([]byte("out\n"))
+	require.NoError(t, err)
+	_, err = sidecarConfig.CmdStderr.Write([]byte("err\n"))
+	require.NoError(t, err)
+	require.Len(t, hook.AllEntries(), 2)
+	assert.Equal(t, logrus.InfoLevel, hook.AllEntries()[0].Level)
+	assert.Equal(t, logrus.WarnLevel, hook.AllEntries()[1].Level)
+	assert.Equal(t, "err", hook.AllEntries()[1].Message)
+
+	// The helper's own stdio is used by default
+	sidecarConfig = NewSidecarConfig(&Config{}, log)
+	assert.Nil(t, sidecarConfig.CmdStdin)
+	assert.Nil(t, sidecarConfig.CmdStdout)
+	assert.Nil(t, sidecarConfig.CmdStderr)
+}
+
+func TestConfigureLogger(t *testing.T) {
+	logFile := path.Join(t.TempDir(), "helper.log")
+	config := &Config{
+		AgentAddress:      "path",
+		JWTBundleFilename: "bundle.json",
+		LogLevel:          "debug",
+		LogFormat:         "json",
+		LogFile:           logFile,
+	}
+	log, _ := test.NewNullLogger()
+	require.NoError(t, config.ValidateConfig(log))
+	assert.Equal(t, 100, config.LogFileMaxSizeMB)
+	assert.Equal(t, 3, config.LogFileMaxBackups)
+
+	logger := logrus.New()
+	config.ConfigureLogger(logger)
+	assert.Equal(t, logrus.DebugLevel, logger.GetLevel())
+	assert.IsType(t, &logrus.JSONFormatter{}, logger.Formatter)
+	require.IsType(t, &logfile.Writer{}, logger.Out)
+	assert.Equal(t, logFile, logger.Out.(*logfile.Writer).Filename())
+
+	logger.WithField("spiffe_id", "spiffe://example.org/workload").Debug("X.509 certificates updated")
+	content, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(content, &entry))
+	assert.Equal(t, "debug", entry["level"])
+	assert.Equal(t, "X.509 certificates updated", entry["msg"])
+	assert.Equal(t, "spiffe://example.org/workload", entry["spiffe_id"])
+
+	// Reloading the configuration changes the level and format, but keeps
+	// writing to the same file
+	(&Config{LogFile: logFile}).ReconfigureLogger(logger)
+	assert.Equal(t, logrus.InfoLevel, logger.GetLevel())
+	assert.IsType(t, &logrus.TextFormatter{}, logger.Formatter)
+	assert.Equal(t, logFile, logger.Out.(*logfile.Writer).Filename())
+
+	// The defaults leave the logger as it is
+	logger = logrus.New()
+	(&Config{}).ConfigureLogger(logger)
+	assert.Equal(t, logrus.InfoLevel, logger.GetLevel())
+	assert.IsType(t, &logrus.TextFormatter{}, logger.Formatter)
+	assert.Equal(t, os.Stderr, logger.Out)
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	ctx := context.Background()
+	log, _ := test.NewNullLogger()
+
+	// Tracing is disabled by default
+	config := &Config{AgentAddress: "path", JWTBundleFilename: "bundle.json"}
+	require.NoError(t, config.ValidateConfig(log))
+	tracerProvider, err := config.NewTracerProvider(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, tracerProvider)
+
+	config = &Config{
+		AgentAddress:        "path",
+		JWTBundleFilename:   "bundle.json",
+		TracingOTLPEndpoint: "localhost:4317",
+		TracingOTLPInsecure: true,
+	}
+	require.NoError(t, config.ValidateConfig(log))
+	assert.Equal(t, "spiffe-helper", config.TracingServiceName)
+	tracerProvider, err = config.NewTracerProvider(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, tracerProvider)
+
+	_, span := tracerProvider.Tracer("test").Start(ctx, "test")
+	assert.True(t, span.IsRecording())
+	span.End()
+
+	// Nothing is listening, so the span is dropped once the timeout expires
+	shutdownCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_ = tracerProvider.Shutdown(shutdownCtx)
+}
+
 func TestDetectsUnknownConfig(t *testing.T) {
 	tempDir := t.TempDir()
 	for _, tt := range []struct {
@@ -252,6 +1120,31 @@ func TestDetectsUnknownConfig(t *testing.T) {
 				`,
 			expectError: "unknown key(s) in jwt_svids[1]: bar,foo",
 		},
+		{
+			name: "Unknown configuration in x509_svid",
+			config: `
+				cmd = "echo"
+				x509_svid {
+					svid_file_name = "db.pem"
+					foo = "bar"
+				}
+				`,
+			expectError: "unknown key(s) in x509_svid[0]: foo",
+		},
+		{
+			name: "Unknown configuration in notify target",
+			config: `
+				cmd = "echo"
+				notify_targets = [
+						{
+							pid_file_name = "/run/app.pid",
+							renew_signal = "SIGHUP",
+							foo = "bar"
+						}
+					    ]
+				`,
+			expectError: "unknown key(s) in notify_targets[0]: foo",
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			configFile, err := os.CreateTemp(tempDir, "spiffe-helper")
@@ -273,6 +1166,29 @@ func TestDetectsUnknownConfig(t *testing.T) {
 	}
 }
 
+func TestListBlocks(t *testing.T) {
+	root, err := hcl.Parse(`
+		x509_svid {
+			svid_file_name = "db.pem"
+		}
+		health_checks {
+			listener_enabled = true
+		}
+		jwt_svids {
+			jwt_audience = "aud"
+		}
+	`)
+	require.NoError(t, err)
+	list := root.Node.(*ast.ObjectList)
+	listBlocks(list)
+
+	// Only the blocks that are documented as repeated become lists
+	require.Len(t, list.Items, 3)
+	assert.IsType(t, &ast.ListType{}, list.Items[0].Val)
+	assert.IsType(t, &ast.ObjectType{}, list.Items[1].Val)
+	assert.IsType(t, &ast.ObjectType{}, list.Items[2].Val)
+}
+
 func TestDefaultAgentAddress(t *testing.T) {
 	for _, tt := range []struct {
 		name                    string
@@ -350,12 +1266,40 @@ func TestNewSidecarConfig(t *testing.T) {
 		CertDir:                 "my-cert-dir",
 		SVIDKeyFilename:         "my-key",
 		IncludeFederatedDomains: true,
+		X509SVIDs: []X509SVIDConfig{
+			{
+				SPIFFEID:                 "spiffe://example.org/db",
+				SVIDFilename:             "my-db-svid",
+				SVIDKeyFilename:          "my-db-key",
+				SVIDBundleFilename:       "my-db-bundle",
+				CertFileMode:             0640,
+				KeyFileMode:              0400,
+				AddIntermediatesToBundle: true,
+			},
+		},
 		JWTSVIDs: []JWTConfig{
 			{
 				JWTAudience:     "my-audience",
 				JWTSVIDFilename: "my-jwt-filename",
 			},
 		},
+		NotifyTargets: []NotifyTargetConfig{
+			{
+				PIDFilename:     "my-pid-file",
+				RenewSignal:     "SIGHUP",
+				CredentialTypes: []string{"jwt_svid"},
+			},
+		},
+		NotifyDebounce:        "2s",
+		NotifyMinInterval:     "1m",
+		CmdEnv:                map[string]string{"APP_MODE": "production"},
+		CmdEnvIncludeJWTSVIDs: true,
+		CmdStopSignal:         "SIGINT",
+		CmdStopTimeout:        "30s",
+		HealthCheck: health.Config{
+			ReadinessMinLifetime: "5m",
+			LivenessUpdateWindow: "1h",
+		},
 	}
 
 	sidecarConfig := NewSidecarConfig(config, nil)
@@ -367,6 +1311,17 @@ func TestNewSidecarConfig(t *testing.T) {
 	assert.Equal(t, config.SVIDKeyFilename, sidecarConfig.SVIDKeyFilename)
 	assert.Equal(t, config.IncludeFederatedDomains, sidecarConfig.IncludeFederatedDomains)
 
+	// Ensure X.509 SVID outputs were populated correctly
+	assert.Equal(t, []sidecar.X509SVIDConfig{{
+		SPIFFEID:                 "spiffe://example.org/db",
+		SVIDFilename:             "my-db-svid",
+		SVIDKeyFilename:          "my-db-key",
+		SVIDBundleFilename:       "my-db-bundle",
+		CertFileMode:             0640,
+		KeyFileMode:              0400,
+		AddIntermediatesToBundle: true,
+	}}, sidecarConfig.X509SVIDs)
+
 	// Ensure JWT Config was populated correctly
 	require.Len(t, sidecarConfig.JWTSVIDs, len(config.JWTSVIDs))
 	for i := range config.JWTSVIDs {
@@ -374,25 +1329,24 @@ func TestNewSidecarConfig(t *testing.T) {
 		assert.Equal(t, config.JWTSVIDs[i].JWTSVIDFilename, sidecarConfig.JWTSVIDs[i].JWTSVIDFilename)
 	}
 
-	// Ensure empty fields were not populated
-	assert.Empty(t, sidecarConfig.SVIDFilename)
-	assert.Empty(t, sidecarConfig.RenewSignal)
-}
-
-func TestDaemonModeFlag(t *testing.T) {
-	config := &Config{
-		SVIDFilename:       "cert.pem",
-		SVIDKeyFilename:    "key.pem",
-		SVIDBundleFilename: "bundle.pem",
+	// Ensure notify targets were populated correctly
+	require.Len(t, sidecarConfig.NotifyTargets, len(config.NotifyTargets))
+	for i := range config.NotifyTargets {
+		assert.Equal(t, config.NotifyTargets[i].PIDFilename, sidecarConfig.NotifyTargets[i].PIDFilename)
+		assert.Equal(t, config.NotifyTargets[i].RenewSignal, sidecarConfig.NotifyTargets[i].RenewSignal)
+		assert.Equal(t, config.NotifyTargets[i].CredentialTypes, sidecarConfig.NotifyTargets[i].CredentialTypes)
 	}
 
-	daemonModeFlag := flag.Bool(daemonModeFlagName, true, "Toggle running as a daemon to rotate X.509/JWT or just fetch and exit")
-	flag.Parse()
-
-	err := flag.Set(daemonModeFlagName, "false")
-	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, sidecarConfig.NotifyDebounce)
+	assert.Equal(t, time.Minute, sidecarConfig.NotifyMinInterval)
+	assert.Equal(t, config.CmdEnv, sidecarConfig.CmdEnv)
+	assert.True(t, sidecarConfig.CmdEnvIncludeJWTSVIDs)
+	assert.Equal(t, "SIGINT", sidecarConfig.CmdStopSignal)
+	assert.Equal(t, 30*time.Second, sidecarConfig.CmdStopTimeout)
+	assert.Equal(t, 5*time.Minute, sidecarConfig.ReadinessMinLifetime)
+	assert.Equal(t, time.Hour, sidecarConfig.LivenessUpdateWindow)
 
-	config.ParseConfigFlagOverrides(*daemonModeFlag, daemonModeFlagName)
-	require.NotNil(t, config.DaemonMode)
-	assert.False(t, *config.DaemonMode)
+	// Ensure empty fields were not populated
+	assert.Empty(t, sidecarConfig.SVIDFilename)
+	assert.Empty(t, sidecarConfig.RenewSignal)
 }
@@ -5,9 +5,14 @@ package config
 
 import "errors"
 
-func validateOSConfig(c *Config) error {
+func validateOSConfig(c *Config, p *problems) {
 	if c.RenewSignal != "" {
-		return errors.New("sending signals is not supported on windows")
+		p.add("renew_signal", errors.New("sending signals is not supported on windows"))
+	}
+	if c.CmdStopSignal != "" {
+		p.add("cmd_stop_signal", errors.New("cmd_stop_signal is not supported on windows"))
+	}
+	if len(c.NotifyTargets) > 0 {
+		p.add("notify_targets", errors.New("notify_targets is not supported on windows"))
 	}
-	return nil
 }
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the configuration file
+const (
+	FormatHCL  = "hcl"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+var formats = []string{FormatHCL, FormatJSON, FormatYAML}
+
+// DetectFormat returns the format of a configuration file from its
+// extension. Files without a .json, .yaml or .yml extension are HCL.
+func DetectFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	default:
+		return FormatHCL
+	}
+}
+
+func validateFormat(format string) error {
+	switch format {
+	case FormatHCL, FormatJSON, FormatYAML:
+		return nil
+	default:
+		return fmt.Errorf("unknown config format %q, must be one of: %s", format, strings.Join(formats, ","))
+	}
+}
+
+// parseFile parses the content of a configuration file into a HCL syntax
+// tree. JSON and YAML files are converted to the tree a HCL file with the
+// same content would produce, so every format is decoded into the same
+// struct, with the positions of unknown keys pointing into the original
+// file.
+func parseFile(file string, format string, data []byte) (*ast.File, error) {
+	switch format {
+	case FormatHCL:
+		return hcl.ParseBytes(data)
+	case FormatJSON:
+		// JSON is parsed as YAML, which it is a subset of, once it's known
+		// to be valid JSON
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return parseYAML(file, data)
+	case FormatYAML:
+		return parseYAML(file, data)
+	default:
+		return nil, validateFormat(format)
+	}
+}
+
+func parseYAML(file string, data []byte) (*ast.File, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	// An empty file has no content
+	if len(document.Content) == 0 {
+		return &ast.File{Node: &ast.ObjectList{}}, nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d, column %d: expected a mapping of configuration keys", root.Line, root.Column)
+	}
+
+	objectType, err := yamlToHCL(file, root)
+	if err != nil {
+		return nil, err
+	}
+	return &ast.File{Node: objectType.(*ast.ObjectType).List}, nil
+}
+
+// yamlToHCL converts a YAML node into the matching HCL node
+func yamlToHCL(file string, node *yaml.Node) (ast.Node, error) {
+	pos := token.Pos{Filename: file, Line: node.Line, Column: node.Column}
+
+	switch node.Kind {
+	case yaml.AliasNode:
+		return yamlToHCL(file, node.Alias)
+	case yaml.MappingNode:
+		list := &ast.ObjectList{}
+		for i := 0; i < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			// A key without a value is left unset
+			if value.ShortTag() == "!!null" {
+				continue
+			}
+			val, err := yamlToHCL(file, value)
+			if err != nil {
+				return nil, err
+			}
+			// HCL only reports unknown keys that are identifiers or come
+			// from JSON
+			list.Add(&ast.ObjectItem{
+				Keys: []*ast.ObjectKey{{Token: token.Token{
+					Type: token.STRING,
+					Pos:  token.Pos{Filename: file, Line: key.Line, Column: key.Column},
+					Text: strconv.Quote(key.Value),
+					JSON: true,
+				}}},
+				Val: val,
+			})
+		}
+		return &ast.ObjectType{List: list, Lbrace: pos}, nil
+	case yaml.SequenceNode:
+		list := &ast.ListType{Lbrack: pos}
+		for _, element := range node.Content {
+			val, err := yamlToHCL(file, element)
+			if err != nil {
+				return nil, err
+			}
+			list.Add(val)
+		}
+		return list, nil
+	case yaml.ScalarNode:
+		return yamlScalarToHCL(pos, node)
+	default:
+		return nil, fmt.Errorf("line %d, column %d: unsupported value", node.Line, node.Column)
+	}
+}
+
+// yamlScalarToHCL converts a YAML scalar into a HCL literal. Strings are
+// marked as coming from JSON, so that they are unquoted without HCL's
+// handling of interpolations.
+func yamlScalarToHCL(pos token.Pos, node *yaml.Node) (ast.Node, error) {
+	tok := token.Token{Pos: pos, JSON: true}
+	switch node.ShortTag() {
+	case "!!int":
+		var value int64
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		tok.Type, tok.Text = token.NUMBER, strconv.FormatInt(value, 10)
+	case "!!float":
+		var value float64
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		tok.Type, tok.Text = token.FLOAT, strconv.FormatFloat(value, 'g', -1, 64)
+	case "!!bool":
+		var value bool
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		tok.Type, tok.Text = token.BOOL, strconv.FormatBool(value)
+	default:
+		tok.Type, tok.Text = token.STRING, strconv.Quote(node.Value)
+	}
+	return &ast.LiteralType{Token: tok}, nil
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectFormat(t *testing.T) {
+	assert.Equal(t, FormatHCL, DetectFormat("helper.conf"))
+	assert.Equal(t, FormatHCL, DetectFormat("helper.hcl"))
+	assert.Equal(t, FormatHCL, DetectFormat("helper"))
+	assert.Equal(t, FormatJSON, DetectFormat("/etc/spiffe-helper/helper.json"))
+	assert.Equal(t, FormatYAML, DetectFormat("helper.yaml"))
+	assert.Equal(t, FormatYAML, DetectFormat("helper.YML"))
+}
+
+func TestParseConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(name string, content string) string {
+		file := path.Join(dir, name)
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+		return file
+	}
+
+	hclFile := writeConfig("helper.conf", `
+		agent_address = "/tmp/agent.sock"
+		daemon_mode = false
+		cmd = "envoy"
+		cmd_args = ["-c", "envoy.yaml"]
+		cmd_env = { LOG_LEVEL = "debug" }
+		cert_dir = "certs"
+		cert_file_mode = 0640
+		svid_file_name = "svid.pem"
+		svid_key_file_name = "svid_key.pem"
+		svid_bundle_file_name = "svid_bundle.pem"
+		x509_svid {
+			spiffe_id = "spiffe://example.org/db"
+			svid_file_name = "db.pem"
+			svid_key_file_name = "db_key.pem"
+			svid_bundle_file_name = "db_bundle.pem"
+			add_intermediates_to_bundle = true
+		}
+		x509_svid {
+			hint = "web"
+			svid_file_name = "web.pem"
+			svid_key_file_name = "web_key.pem"
+			svid_bundle_file_name = "web_bundle.pem"
+		}
+		jwt_svids = [
+			{ jwt_audience = "aud-1", jwt_svid_file_name = "jwt-1.token" },
+			{ jwt_audience = "aud-2", jwt_extra_audiences = ["extra"], jwt_svid_file_name = "jwt-2.token" },
+		]
+		health_checks {
+			listener_enabled = true
+			bind_port = 8000
+		}
+		notify_targets = [
+			{ process_name = "envoy", renew_signal = "SIGUSR1", credential_types = ["x509"] },
+		]
+	`)
+	jsonFile := writeConfig("helper.json", `{
+		"agent_address": "/tmp/agent.sock",
+		"daemon_mode": false,
+		"cmd": "envoy",
+		"cmd_args": ["-c", "envoy.yaml"],
+		"cmd_env": {"LOG_LEVEL": "debug"},
+		"cert_dir": "certs",
+		"cert_file_mode": 416,
+		"svid_file_name": "svid.pem",
+		"svid_key_file_name": "svid_key.pem",
+		"svid_bundle_file_name": "svid_bundle.pem",
+		"x509_svid": [
+			{"spiffe_id": "spiffe://example.org/db", "svid_file_name": "db.pem", "svid_key_file_name": "db_key.pem", "svid_bundle_file_name": "db_bundle.pem", "add_intermediates_to_bundle": true},
+			{"hint": "web", "svid_file_name": "web.pem", "svid_key_file_name": "web_key.pem", "svid_bundle_file_name": "web_bundle.pem"}
+		],
+		"jwt_svids": [
+			{"jwt_audience": "aud-1", "jwt_svid_file_name": "jwt-1.token"},
+			{"jwt_audience": "aud-2", "jwt_extra_audiences": ["extra"], "jwt_svid_file_name": "jwt-2.token"}
+		],
+		"health_checks": {"listener_enabled": true, "bind_port": 8000},
+		"notify_targets": [
+			{"process_name": "envoy", "renew_signal": "SIGUSR1", "credential_types": ["x509"]}
+		]
+	}`)
+	yamlFile := writeConfig("helper.yaml", `
+agent_address: /tmp/agent.sock
+daemon_mode: false
+cmd: envoy
+cmd_args: [-c, envoy.yaml]
+cmd_env:
+  LOG_LEVEL: debug
+cert_dir: certs
+cert_file_mode: 0640
+svid_file_name: svid.pem
+svid_key_file_name: svid_key.pem
+svid_bundle_file_name: svid_bundle.pem
+x509_svid:
+  - spiffe_id: spiffe://example.org/db
+    svid_file_name: db.pem
+    svid_key_file_name: db_key.pem
+    svid_bundle_file_name: db_bundle.pem
+    add_intermediates_to_bundle: true
+  - hint: web
+    svid_file_name: web.pem
+    svid_key_file_name: web_key.pem
+    svid_bundle_file_name: web_bundle.pem
+jwt_svids:
+  - jwt_audience: aud-1
+    jwt_svid_file_name: jwt-1.token
+  - jwt_audience: aud-2
+    jwt_extra_audiences: [extra]
+    jwt_svid_file_name: jwt-2.token
+health_checks:
+  listener_enabled: true
+  bind_port: 8000
+notify_targets:
+  - process_name: envoy
+    renew_signal: SIGUSR1
+    credential_types: [x509]
+`)
+
+	expected, err := ParseConfigFile(hclFile)
+	require.NoError(t, err)
+	assert.Equal(t, 0640, expected.CertFileMode)
+	// Each x509_svid block is one output
+	require.Len(t, expected.X509SVIDs, 2)
+	assert.Equal(t, "db.pem", expected.X509SVIDs[0].SVIDFilename)
+	assert.Equal(t, "web", expected.X509SVIDs[1].Hint)
+	// Positions are specific to each file
+	expected.positions = nil
+	for _, file := range []string{jsonFile, yamlFile} {
+		c, err := ParseConfigFile(file)
+		require.NoError(t, err)
+		c.positions = nil
+		assert.Equal(t, expected, c, file)
+	}
+
+	// The format can be set regardless of the extension
+	yamlConf := writeConfig("yaml.conf", "agent_address: /tmp/agent.sock\n")
+	c, err := ParseConfigFileWithFormat(yamlConf, FormatYAML)
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/agent.sock", c.AgentAddress)
+
+	_, err = ParseConfigFileWithFormat(yamlConf, "toml")
+	require.EqualError(t, err, `unknown config format "toml", must be one of: hcl,json,yaml`)
+
+	_, err = ParseConfigFile(writeConfig("invalid.json", `{"agent_address": "/tmp/agent.sock",}`))
+	require.EqualError(t, err, "invalid character '}' looking for beginning of object key string")
+
+	_, err = ParseConfigFile(writeConfig("list.yaml", "- agent_address: /tmp/agent.sock\n"))
+	require.EqualError(t, err, "line 1, column 1: expected a mapping of configuration keys")
+
+	// An empty file is an empty configuration, as with HCL
+	c, err = ParseConfigFile(writeConfig("empty.yaml", ""))
+	require.NoError(t, err)
+	assert.Equal(t, &Config{}, c)
+}
+
+// Unknown keys and invalid values are reported the same way in every format
+func TestParseConfigFormatErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name        string
+		hcl         string
+		json        string
+		yaml        string
+		expectError string
+	}{
+		{
+			name:        "unknown top level keys",
+			hcl:         `agent_address = "/tmp"` + "\n" + `foo = "bar"` + "\n" + `bar = "foo"`,
+			json:        `{"agent_address": "/tmp", "foo": "bar", "bar": "foo"}`,
+			yaml:        "agent_address: /tmp\nfoo: bar\nbar: foo\n",
+			expectError: "unknown top level key(s): bar,foo",
+		},
+		{
+			name:        "unknown key in jwt svid",
+			hcl:         `jwt_svids = [{ jwt_audience = "aud", jwt_svid_file_name = "jwt.token", foo = "bar" }]`,
+			json:        `{"jwt_svids": [{"jwt_audience": "aud", "jwt_svid_file_name": "jwt.token", "foo": "bar"}]}`,
+			yaml:        "jwt_svids:\n  - jwt_audience: aud\n    jwt_svid_file_name: jwt.token\n    foo: bar\n",
+			expectError: "unknown key(s) in jwt_svids[0]: foo",
+		},
+		{
+			name:        "unknown key in notify target",
+			hcl:         `cmd = "echo"` + "\n" + `notify_targets = [{ pid_file_name = "/run/app.pid", renew_signal = "SIGHUP", foo = "bar" }]`,
+			json:        `{"cmd": "echo", "notify_targets": [{"pid_file_name": "/run/app.pid", "renew_signal": "SIGHUP", "foo": "bar"}]}`,
+			yaml:        "cmd: echo\nnotify_targets:\n  - pid_file_name: /run/app.pid\n    renew_signal: SIGHUP\n    foo: bar\n",
+			expectError: "unknown key(s) in notify_targets[0]: foo",
+		},
+		{
+			name:        "invalid value",
+			hcl:         `agent_address = "/tmp"` + "\n" + `jwt_bundle_file_name = "bundle.json"` + "\n" + `notify_debounce = "soon"`,
+			json:        `{"agent_address": "/tmp", "jwt_bundle_file_name": "bundle.json", "notify_debounce": "soon"}`,
+			yaml:        "agent_address: /tmp\njwt_bundle_file_name: bundle.json\nnotify_debounce: soon\n",
+			expectError: `invalid notify_debounce: time: invalid duration "soon"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for format, content := range map[string]string{FormatHCL: tt.hcl, FormatJSON: tt.json, FormatYAML: tt.yaml} {
+				file := path.Join(dir, "helper."+format)
+				require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+
+				c, err := ParseConfigFile(file)
+				require.NoError(t, err, format)
+				log, _ := test.NewNullLogger()
+				require.EqualError(t, c.ValidateConfig(log), tt.expectError, format)
+			}
+		})
+	}
+
+	// Unknown keys point into the file they were read from
+	file := path.Join(dir, "positions.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("agent_address: /tmp\n\nfoo: bar\n"), 0600))
+	c, err := ParseConfigFile(file)
+	require.NoError(t, err)
+	require.Len(t, c.UnusedKeyPositions["foo"], 1)
+	pos := c.UnusedKeyPositions["foo"][0]
+	assert.Equal(t, file, pos.Filename)
+	assert.Equal(t, 3, pos.Line)
+	assert.Equal(t, 1, pos.Column)
+}
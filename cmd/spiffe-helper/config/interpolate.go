@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+)
+
+var envVarNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// expandValues replaces the references to environment variables and files
+// in every string value of the syntax tree, before it is decoded:
+//
+//   - ${NAME} is replaced with the value of the environment variable NAME,
+//     which must be set
+//   - ${NAME:-default} is replaced with default if NAME is unset or empty
+//   - ${file:/path} is replaced with the content of the file, without
+//     trailing newlines
+//   - $${ is replaced with a literal ${
+//
+// The values with references are recorded in refs as they were written.
+func expandValues(root ast.Node, refs references) error {
+	var err error
+	ast.Walk(root, func(node ast.Node) (ast.Node, bool) {
+		literal, ok := node.(*ast.LiteralType)
+		if err != nil || !ok || (literal.Token.Type != token.STRING && literal.Token.Type != token.HEREDOC) {
+			return node, err == nil
+		}
+
+		written := literal.Token.Value().(string)
+		value, expandErr := expand(written)
+		if expandErr != nil {
+			err = fmt.Errorf("line %d, column %d: %w", literal.Token.Pos.Line, literal.Token.Pos.Column, expandErr)
+			return node, false
+		}
+		literal.Token.Type = token.STRING
+		literal.Token.Text = strconv.Quote(value)
+		literal.Token.JSON = true
+		refs.record(literal, written)
+		return node, false
+	})
+	return err
+}
+
+// references maps the string values that were expanded to the values as
+// written, so that print-config doesn't show the secrets they resolve to
+type references map[*ast.LiteralType]string
+
+func (r references) record(literal *ast.LiteralType, written string) {
+	// Values with only literal $${ are printed the same when expanded
+	if strings.Contains(strings.ReplaceAll(written, "$${", ""), "${") {
+		r[literal] = written
+	}
+}
+
+// keys returns the values as written of the keys whose values had
+// references, by the lower-cased path of the key, e.g. cmd_env.token or
+// jwt_svids[0].jwt_audience. Elements of lists are indexed like blocks.
+func (r references) keys(root *ast.ObjectList) map[string]string {
+	if len(r) == 0 {
+		return nil
+	}
+
+	keys := make(map[string]string)
+	var walkItems func(list *ast.ObjectList, prefix string)
+	var walk func(node ast.Node, path string)
+	walk = func(node ast.Node, path string) {
+		switch node := node.(type) {
+		case *ast.LiteralType:
+			if written, ok := r[node]; ok {
+				keys[path] = written
+			}
+		case *ast.ListType:
+			for i, element := range node.List {
+				walk(element, fmt.Sprintf("%s[%d]", path, i))
+			}
+		case *ast.ObjectType:
+			walkItems(node.List, path+".")
+		}
+	}
+	walkItems = func(list *ast.ObjectList, prefix string) {
+		// Repeated blocks are each a list of one block
+		lists := make(map[string]int)
+		for _, item := range list.Items {
+			if len(item.Keys) == 0 {
+				continue
+			}
+			itemKeys := make([]string, len(item.Keys))
+			for i, key := range item.Keys {
+				value, _ := key.Token.Value().(string)
+				itemKeys[i] = strings.ToLower(value)
+			}
+			key := prefix + strings.Join(itemKeys, ".")
+
+			list, ok := item.Val.(*ast.ListType)
+			if !ok {
+				walk(item.Val, key)
+				continue
+			}
+			for _, element := range list.List {
+				walk(element, fmt.Sprintf("%s[%d]", key, lists[key]))
+				lists[key]++
+			}
+		}
+	}
+	walkItems(root, "")
+	return keys
+}
+
+func expand(value string) (string, error) {
+	var expanded strings.Builder
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			expanded.WriteString(value)
+			return expanded.String(), nil
+		}
+		if start > 0 && value[start-1] == '$' {
+			expanded.WriteString(value[:start-1] + "${")
+			value = value[start+2:]
+			continue
+		}
+		end := strings.IndexByte(value[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference %q", value[start:])
+		}
+
+		replacement, err := resolveReference(value[start+2 : start+end])
+		if err != nil {
+			return "", err
+		}
+		expanded.WriteString(value[:start] + replacement)
+		value = value[start+end+1:]
+	}
+}
+
+func resolveReference(reference string) (string, error) {
+	if file, ok := strings.CutPrefix(reference, "file:"); ok {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("unable to read ${%s}: %w", reference, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+
+	name, defaultValue, hasDefault := strings.Cut(reference, ":-")
+	if !envVarNameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid reference ${%s}, must be ${NAME}, ${NAME:-default} or ${file:/path}", reference)
+	}
+	value, ok := os.LookupEnv(name)
+	switch {
+	case hasDefault && value == "":
+		return defaultValue, nil
+	case !ok:
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpand(t *testing.T) {
+	t.Setenv("HELPER_CERT_DIR", "/run/certs")
+	t.Setenv("HELPER_EMPTY", "")
+	secretFile := path.Join(t.TempDir(), "audience")
+	require.NoError(t, os.WriteFile(secretFile, []byte("spiffe-helper\n"), 0600))
+	missingFile := path.Join(t.TempDir(), "missing")
+	_, errMissingFile := os.ReadFile(missingFile)
+
+	for _, tt := range []struct {
+		name        string
+		value       string
+		expected    string
+		expectError string
+	}{
+		{
+			name:     "no references",
+			value:    "/run/certs",
+			expected: "/run/certs",
+		},
+		{
+			name:     "environment variable",
+			value:    "${HELPER_CERT_DIR}/svid.pem",
+			expected: "/run/certs/svid.pem",
+		},
+		{
+			name:     "several references",
+			value:    "${HELPER_CERT_DIR}:${HELPER_CERT_DIR}",
+			expected: "/run/certs:/run/certs",
+		},
+		{
+			name:     "empty environment variable",
+			value:    "[${HELPER_EMPTY}]",
+			expected: "[]",
+		},
+		{
+			name:     "default of a set variable",
+			value:    "${HELPER_CERT_DIR:-/tmp}",
+			expected: "/run/certs",
+		},
+		{
+			name:     "default of an unset variable",
+			value:    "${HELPER_UNSET:-/tmp}",
+			expected: "/tmp",
+		},
+		{
+			name:     "default of an empty variable",
+			value:    "${HELPER_EMPTY:-/tmp}",
+			expected: "/tmp",
+		},
+		{
+			name:     "file",
+			value:    "${file:" + secretFile + "}",
+			expected: "spiffe-helper",
+		},
+		{
+			name:     "escaped reference",
+			value:    "$${HELPER_CERT_DIR} is ${HELPER_CERT_DIR}",
+			expected: "${HELPER_CERT_DIR} is /run/certs",
+		},
+		{
+			name:     "lone dollar",
+			value:    "$HOME costs $5",
+			expected: "$HOME costs $5",
+		},
+		{
+			name:        "unset variable",
+			value:       "${HELPER_UNSET}",
+			expectError: "environment variable HELPER_UNSET is not set",
+		},
+		{
+			name:        "missing file",
+			value:       "${file:" + missingFile + "}",
+			expectError: "unable to read ${file:" + missingFile + "}: " + errMissingFile.Error(),
+		},
+		{
+			name:        "invalid name",
+			value:       "${HELPER CERT DIR}",
+			expectError: "invalid reference ${HELPER CERT DIR}, must be ${NAME}, ${NAME:-default} or ${file:/path}",
+		},
+		{
+			name:        "unterminated reference",
+			value:       "/run/${HELPER_CERT_DIR",
+			expectError: `unterminated reference "${HELPER_CERT_DIR"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			expanded, err := expand(tt.value)
+			if tt.expectError != "" {
+				require.EqualError(t, err, tt.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, expanded)
+		})
+	}
+}
+
+func TestParseConfigFileExpandsValues(t *testing.T) {
+	t.Setenv("HELPER_CERT_DIR", "/run/certs")
+	t.Setenv("HELPER_AUDIENCE", "spiffe-helper")
+	t.Setenv("HELPER_BIND_PORT", "9000")
+	dir := t.TempDir()
+
+	for _, tt := range []struct {
+		format      string
+		config      string
+		unset       string
+		expectError string
+	}{
+		{
+			format: FormatHCL,
+			config: `
+cert_dir = "${HELPER_CERT_DIR}"
+svid_file_name = "${HELPER_SVID_FILE_NAME:-svid.pem}"
+cmd_args = ["--audience", "${HELPER_AUDIENCE}"]
+jwt_svids = [{ jwt_audience = "${HELPER_AUDIENCE}", jwt_svid_file_name = "jwt.token" }]
+health_checks {
+  bind_port = "${HELPER_BIND_PORT}"
+}
+`,
+			unset: `
+cert_dir = "/run/certs"
+jwt_svids = [
+  { jwt_audience = "${HELPER_UNSET}", jwt_svid_file_name = "jwt.token" },
+]
+`,
+			expectError: "line 4, column 20: environment variable HELPER_UNSET is not set",
+		},
+		{
+			format: FormatJSON,
+			config: `{
+  "cert_dir": "${HELPER_CERT_DIR}",
+  "svid_file_name": "${HELPER_SVID_FILE_NAME:-svid.pem}",
+  "cmd_args": ["--audience", "${HELPER_AUDIENCE}"],
+  "jwt_svids": [{"jwt_audience": "${HELPER_AUDIENCE}", "jwt_svid_file_name": "jwt.token"}],
+  "health_checks": {"bind_port": "${HELPER_BIND_PORT}"}
+}`,
+			unset: `{
+  "cert_dir": "/run/certs",
+  "jwt_svids": [
+    {"jwt_audience": "${HELPER_UNSET}", "jwt_svid_file_name": "jwt.token"}
+  ]
+}`,
+			expectError: "line 4, column 22: environment variable HELPER_UNSET is not set",
+		},
+		{
+			format: FormatYAML,
+			config: `
+cert_dir: ${HELPER_CERT_DIR}
+svid_file_name: ${HELPER_SVID_FILE_NAME:-svid.pem}
+cmd_args: [--audience, "${HELPER_AUDIENCE}"]
+jwt_svids:
+  - jwt_audience: ${HELPER_AUDIENCE}
+    jwt_svid_file_name: jwt.token
+health_checks:
+  bind_port: ${HELPER_BIND_PORT}
+`,
+			unset: `
+cert_dir: /run/certs
+jwt_svids:
+  - jwt_audience: ${HELPER_UNSET}
+    jwt_svid_file_name: jwt.token
+`,
+			expectError: "line 4, column 19: environment variable HELPER_UNSET is not set",
+		},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			file := path.Join(dir, "helper."+tt.format)
+			require.NoError(t, os.WriteFile(file, []byte(tt.config), 0600))
+			c, err := ParseConfigFile(file)
+			require.NoError(t, err)
+			assert.Equal(t, "/run/certs", c.CertDir)
+			assert.Equal(t, "svid.pem", c.SVIDFilename)
+			assert.Equal(t, []interface{}{"--audience", "spiffe-helper"}, c.CmdArgs)
+			assert.Equal(t, "spiffe-helper", c.JWTSVIDs[0].JWTAudience)
+			assert.Equal(t, 9000, c.HealthCheck.BindPort)
+
+			require.NoError(t, os.WriteFile(file, []byte(tt.unset), 0600))
+			_, err = ParseConfigFile(file)
+			require.EqualError(t, err, tt.expectError)
+		})
+	}
+}
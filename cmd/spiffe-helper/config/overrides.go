@@ -0,0 +1,186 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+)
+
+// EnvPrefix is prepended to the upper-cased name of a top level key to get
+// the environment variable overriding it, e.g.
+// SPIFFE_HELPER_CONFIG_AGENT_ADDRESS. It differs from the prefix of the
+// variables set for 'cmd', so a helper launched by another one doesn't take
+// them as overrides.
+const EnvPrefix = "SPIFFE_HELPER_CONFIG_"
+
+// Overrides holds the flags registered for every top level key, which
+// override the configuration file along with the SPIFFE_HELPER_CONFIG_*
+// environment variables. Flags take precedence over environment variables.
+// Environment variables set to an empty string are ignored, so a key can
+// only be set to an empty string with its flag.
+type Overrides struct {
+	keys      []string
+	kinds     map[string]reflect.Kind
+	flags     map[string]*overrideFlag
+	lookupEnv func(string) (string, bool)
+}
+
+// overrideFlag records the value of a flag, and whether it was set
+type overrideFlag struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (f *overrideFlag) String() string {
+	return f.value
+}
+
+func (f *overrideFlag) Set(value string) error {
+	f.value = value
+	f.set = true
+	return nil
+}
+
+func (f *overrideFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// RegisterOverrideFlags registers a flag for every top level key of Config
+// in the flag set, named after the key with dashes, e.g. -agent-address.
+// Keys that are strings take the flag value as is, while other keys take
+// it in HCL syntax, e.g. -jwt-svids '[{ jwt_audience = "envoy" }]' or
+// -health-checks '{ listener_enabled = true }'. cmd_args takes either.
+func RegisterOverrideFlags(flags *flag.FlagSet) *Overrides {
+	o := &Overrides{
+		kinds:     make(map[string]reflect.Kind),
+		flags:     make(map[string]*overrideFlag),
+		lookupEnv: os.LookupEnv,
+	}
+
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		key := strings.Split(field.Tag.Get("hcl"), ",")[0]
+		if key == "" {
+			continue
+		}
+
+		kind := field.Type.Kind()
+		if kind == reflect.Pointer {
+			kind = field.Type.Elem().Kind()
+		}
+		overrideFlag := &overrideFlag{isBool: kind == reflect.Bool}
+		o.keys = append(o.keys, key)
+		o.kinds[key] = kind
+		o.flags[key] = overrideFlag
+
+		usage := fmt.Sprintf("Overrides %s in the config file, as does %s", key, envVarName(key))
+		switch kind {
+		case reflect.Interface:
+			usage += ". A string, or a list in HCL syntax"
+		case reflect.Map, reflect.Slice, reflect.Struct:
+			usage += ". In HCL syntax"
+		}
+		flags.Var(overrideFlag, flagName(key), usage)
+	}
+
+	return o
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+func envVarName(key string) string {
+	return EnvPrefix + strings.ToUpper(key)
+}
+
+// apply replaces the keys of the configuration file that are overridden by
+// flags or environment variables set to a non-empty value. The values with
+// references are recorded in refs.
+func (o *Overrides) apply(root *ast.ObjectList, refs references) error {
+	if o == nil {
+		return nil
+	}
+
+	for _, key := range o.keys {
+		value, source := o.flags[key].value, "flag -"+flagName(key)
+		if !o.flags[key].set {
+			var ok bool
+			value, ok = o.lookupEnv(envVarName(key))
+			if !ok || value == "" {
+				continue
+			}
+			source = "environment variable " + envVarName(key)
+		}
+
+		item, err := o.parse(key, value, refs)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", source, err)
+		}
+
+		items := root.Items[:0]
+		for _, fileItem := range root.Items {
+			if len(fileItem.Keys) == 0 || !strings.EqualFold(fileItem.Keys[0].Token.Value().(string), key) {
+				items = append(items, fileItem)
+			}
+		}
+		root.Items = append(items, item)
+	}
+
+	return nil
+}
+
+// parse converts the value of an override into the item it replaces the
+// configuration file's with
+func (o *Overrides) parse(key string, value string, refs references) (*ast.ObjectItem, error) {
+	isString := o.kinds[key] == reflect.String ||
+		(o.kinds[key] == reflect.Interface && !strings.HasPrefix(strings.TrimSpace(value), "["))
+
+	var item *ast.ObjectItem
+	switch {
+	case isString:
+		expanded, err := expand(value)
+		if err != nil {
+			return nil, err
+		}
+		literal := &ast.LiteralType{Token: token.Token{
+			Type: token.STRING,
+			Text: strconv.Quote(expanded),
+			JSON: true,
+		}}
+		refs.record(literal, value)
+		item = &ast.ObjectItem{
+			Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: key}}},
+			Val:  literal,
+		}
+	default:
+		file, err := hcl.Parse(key + " = " + value)
+		if err != nil {
+			return nil, err
+		}
+		if err := expandValues(file, refs); err != nil {
+			return nil, err
+		}
+		list := file.Node.(*ast.ObjectList)
+		if len(list.Items) != 1 {
+			return nil, fmt.Errorf("expected a single value, got %q", value)
+		}
+		item = list.Items[0]
+	}
+
+	// Type errors are reported against the override rather than the
+	// configuration as a whole
+	if err := hcl.DecodeObject(new(Config), &ast.ObjectList{Items: []*ast.ObjectItem{item}}); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
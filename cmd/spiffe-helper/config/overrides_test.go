@@ -0,0 +1,171 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spiffe/spiffe-helper/pkg/health"
+	"github.com/spiffe/spiffe-helper/pkg/sidecar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOverrides(t *testing.T, args []string, env map[string]string) *Overrides {
+	flags := flag.NewFlagSet("spiffe-helper", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	overrides := RegisterOverrideFlags(flags)
+	overrides.lookupEnv = func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	require.NoError(t, flags.Parse(args))
+	return overrides
+}
+
+func TestRegisterOverrideFlags(t *testing.T) {
+	flags := flag.NewFlagSet("spiffe-helper", flag.ContinueOnError)
+	RegisterOverrideFlags(flags)
+
+	for _, name := range []string{"agent-address", "cmd-args", "daemon-mode", "health-checks", "jwt-svids", "svid-file-name"} {
+		assert.NotNil(t, flags.Lookup(name), name)
+	}
+	assert.Nil(t, flags.Lookup(""))
+	assert.Equal(t, "Overrides agent_address in the config file, as does SPIFFE_HELPER_CONFIG_AGENT_ADDRESS", flags.Lookup("agent-address").Usage)
+}
+
+func TestParseConfigOverrides(t *testing.T) {
+	configFile := path.Join(t.TempDir(), "helper.conf")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+agent_address = "/tmp/file/api.sock"
+cert_dir = "/tmp/file/certs"
+svid_file_name = "svid.pem"
+daemon_mode = true
+`), 0600))
+
+	t.Run("precedence", func(t *testing.T) {
+		overrides := newTestOverrides(t,
+			[]string{"-agent-address", "/tmp/flag/api.sock", "-daemon-mode=false"},
+			map[string]string{
+				"SPIFFE_HELPER_CONFIG_AGENT_ADDRESS":  "/tmp/env/api.sock",
+				"SPIFFE_HELPER_CONFIG_CERT_DIR":       "/tmp/env/certs",
+				"SPIFFE_HELPER_CONFIG_SVID_FILE_NAME": "",
+			})
+
+		c, err := ParseConfig(configFile, "", overrides)
+		require.NoError(t, err)
+		assert.Equal(t, "/tmp/flag/api.sock", c.AgentAddress)
+		assert.Equal(t, "/tmp/env/certs", c.CertDir)
+		assert.Equal(t, "svid.pem", c.SVIDFilename)
+		require.NotNil(t, c.DaemonMode)
+		assert.False(t, *c.DaemonMode)
+		assert.NoError(t, c.checkForUnknownConfig())
+	})
+
+	t.Run("no config file", func(t *testing.T) {
+		overrides := newTestOverrides(t, []string{
+			"-agent-address", "/tmp/flag/api.sock",
+			"-cmd-args", "-c envoy.yaml",
+			"-cert-file-mode", "0444",
+			"-health-checks", `{ listener_enabled = true, bind_port = 9000 }`,
+			"-jwt-svids", `[{ jwt_audience = "envoy", jwt_svid_file_name = "jwt.token" }]`,
+			"-cmd-env", `{ LOG_LEVEL = "debug" }`,
+		}, map[string]string{
+			"SPIFFE_HELPER_CONFIG_ADD_INTERMEDIATES_TO_BUNDLE": "true",
+		})
+
+		c, err := ParseConfig("", "", overrides)
+		require.NoError(t, err)
+		assert.Equal(t, "/tmp/flag/api.sock", c.AgentAddress)
+		assert.Equal(t, "-c envoy.yaml", c.CmdArgs)
+		assert.Equal(t, 0444, c.CertFileMode)
+		assert.Equal(t, health.Config{ListenerEnabled: true, BindPort: 9000}, c.HealthCheck)
+		require.Len(t, c.JWTSVIDs, 1)
+		assert.Equal(t, "envoy", c.JWTSVIDs[0].JWTAudience)
+		assert.Equal(t, "jwt.token", c.JWTSVIDs[0].JWTSVIDFilename)
+		assert.Equal(t, map[string]string{"LOG_LEVEL": "debug"}, c.CmdEnv)
+		assert.True(t, c.AddIntermediatesToBundle)
+		require.NotNil(t, c.DaemonMode)
+		assert.True(t, *c.DaemonMode)
+		assert.NoError(t, c.checkForUnknownConfig())
+	})
+
+	t.Run("variables set for cmd", func(t *testing.T) {
+		// A helper launched by another one inherits the variables set for
+		// its cmd, which aren't overrides
+		overrides := newTestOverrides(t, nil, map[string]string{
+			sidecar.EnvCertDir: "/tmp/parent/certs",
+		})
+
+		c, err := ParseConfig(configFile, "", overrides)
+		require.NoError(t, err)
+		assert.Equal(t, "/tmp/file/certs", c.CertDir)
+		for _, key := range overrides.keys {
+			assert.NotEqual(t, sidecar.EnvCertDir, envVarName(key))
+		}
+	})
+
+	t.Run("list of cmd_args", func(t *testing.T) {
+		overrides := newTestOverrides(t, []string{"-cmd-args", `["-c", "envoy yaml"]`}, nil)
+
+		c, err := ParseConfig("", "", overrides)
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{"-c", "envoy yaml"}, c.CmdArgs)
+	})
+
+	t.Run("references", func(t *testing.T) {
+		t.Setenv("HELPER_CERT_DIR", "/run/certs")
+		overrides := newTestOverrides(t, []string{
+			"-cert-dir", "${HELPER_CERT_DIR}",
+			"-jwt-svids", `[{ jwt_audience = "${HELPER_AUDIENCE:-envoy}" }]`,
+		}, nil)
+
+		c, err := ParseConfig("", "", overrides)
+		require.NoError(t, err)
+		assert.Equal(t, "/run/certs", c.CertDir)
+		assert.Equal(t, "envoy", c.JWTSVIDs[0].JWTAudience)
+	})
+
+	for _, tt := range []struct {
+		name        string
+		args        []string
+		env         map[string]string
+		expectError string
+	}{
+		{
+			name:        "invalid number",
+			args:        []string{"-parallel-requests", "many"},
+			expectError: "invalid flag -parallel-requests: ",
+		},
+		{
+			name:        "invalid environment variable",
+			env:         map[string]string{"SPIFFE_HELPER_CONFIG_DAEMON_MODE": "maybe"},
+			expectError: "invalid environment variable SPIFFE_HELPER_CONFIG_DAEMON_MODE: ",
+		},
+		{
+			name:        "unset reference",
+			args:        []string{"-cert-dir", "${HELPER_UNSET}"},
+			expectError: "invalid flag -cert-dir: environment variable HELPER_UNSET is not set",
+		},
+		{
+			name:        "several values",
+			args:        []string{"-health-checks", `{} cert_dir = "/tmp"`},
+			expectError: `invalid flag -health-checks: expected a single value, got "{} cert_dir = \"/tmp\""`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			overrides := newTestOverrides(t, tt.args, tt.env)
+			_, err := ParseConfig("", "", overrides)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectError)
+		})
+	}
+
+	t.Run("invalid config file", func(t *testing.T) {
+		_, err := ParseConfig(path.Join(t.TempDir(), "missing.conf"), "", newTestOverrides(t, nil, nil))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "missing.conf")
+	})
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Marshal encodes the configuration in the given format, which is hcl or
+// json, such that parsing it gives the same configuration. Keys that aren't
+// set are left out, so the configuration should have been validated to
+// show the defaults that are used. Defaults that only apply along with a key
+// that isn't set, such as log_file_max_size_mb without log_file, are left
+// out too, so the result validates again. The rotation of the output files
+// of cmd is defaulted here, as validation leaves it unset. Values with
+// references to environment variables and files are written as they were
+// in the configuration, rather than with the secrets they may resolve to.
+func (c *Config) Marshal(format string) ([]byte, error) {
+	config := *c
+	if config.CmdStdout == cmdOutputFile || config.CmdStderr == cmdOutputFile {
+		config.CmdOutputMaxSizeMB, config.CmdOutputMaxBackups = config.cmdOutputRotation()
+	}
+	if config.LogFile == "" {
+		config.LogFileMaxSizeMB, config.LogFileMaxBackups = 0, 0
+	}
+	if config.AuditLogFile == "" {
+		config.AuditLogFileMode = 0
+	}
+
+	root := structToObject(reflect.ValueOf(config), "", config.references)
+	switch format {
+	case FormatHCL:
+		var b bytes.Buffer
+		writeHCL(&b, root, "")
+		return b.Bytes(), nil
+	case FormatJSON:
+		data, err := json.MarshalIndent(root, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q, must be one of: %s,%s", format, FormatHCL, FormatJSON)
+	}
+}
+
+// object is a block of keys, such as health_checks, in the order of the
+// fields of the struct it was converted from
+type object []objectField
+
+// mapping is a map, such as cmd_env, with its keys sorted
+type mapping []objectField
+
+type objectField struct {
+	key   string
+	value interface{}
+}
+
+// fileMode is written in octal, where the format allows it
+type fileMode int
+
+// structToObject converts a struct whose keys are under prefix. refs holds
+// the values of the keys with references as written, by their path.
+func structToObject(v reflect.Value, prefix string, refs map[string]string) object {
+	var o object
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := strings.Split(field.Tag.Get("hcl"), ",")[0]
+		if key == "" || !field.IsExported() || v.Field(i).IsZero() {
+			continue
+		}
+		value := toValue(v.Field(i), prefix+key, refs)
+		if _, ok := value.(written); !ok && field.Type.Kind() == reflect.Int && strings.HasSuffix(key, "_mode") {
+			value = fileMode(v.Field(i).Int())
+		}
+		o = append(o, objectField{key: key, value: value})
+	}
+	return o
+}
+
+// written is a value with references, as written in the configuration
+type written string
+
+func toValue(v reflect.Value, path string, refs map[string]string) interface{} {
+	if value, ok := refs[strings.ToLower(path)]; ok {
+		return written(value)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return toValue(v.Elem(), path, refs)
+	case reflect.Struct:
+		return structToObject(v, path+".", refs)
+	case reflect.Slice:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = toValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), refs)
+		}
+		return list
+	case reflect.Map:
+		var m mapping
+		for _, key := range v.MapKeys() {
+			m = append(m, objectField{key: key.String(), value: toValue(v.MapIndex(key), path+"."+key.String(), refs)})
+		}
+		sort.Slice(m, func(i, j int) bool { return m[i].key < m[j].key })
+		return m
+	case reflect.String:
+		// References would be expanded again when parsing
+		return strings.ReplaceAll(v.String(), "${", "$${")
+	default:
+		return v.Interface()
+	}
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	return marshalFields(o)
+}
+
+func (m mapping) MarshalJSON() ([]byte, error) {
+	return marshalFields(m)
+}
+
+func marshalFields(fields []objectField) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// hclRepeatedBlocks are the lists of blocks written as one block per
+// element, as they are documented. Only these are parsed from that syntax.
+var hclRepeatedBlocks = []string{"x509_svid"}
+
+// writeHCL writes the keys of an object, with the blocks it contains in the
+// block syntax
+func writeHCL(b *bytes.Buffer, o object, indent string) {
+	writeBlock := func(key string, block object) {
+		fmt.Fprintf(b, "%s%s {\n", indent, key)
+		writeHCL(b, block, indent+"  ")
+		fmt.Fprintf(b, "%s}\n", indent)
+	}
+	for _, field := range o {
+		if block, ok := field.value.(object); ok {
+			writeBlock(field.key, block)
+			continue
+		}
+		if list, ok := field.value.([]interface{}); ok && slices.Contains(hclRepeatedBlocks, field.key) {
+			for _, block := range list {
+				writeBlock(field.key, block.(object))
+			}
+			continue
+		}
+		fmt.Fprintf(b, "%s%s = %s\n", indent, field.key, hclValue(field.value))
+	}
+}
+
+func hclValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strconv.Quote(value)
+	case written:
+		return strconv.Quote(string(value))
+	case fileMode:
+		return fmt.Sprintf("0%o", int(value))
+	case []interface{}:
+		elements := make([]string, len(value))
+		for i, element := range value {
+			elements[i] = hclValue(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case object:
+		return hclObject(value)
+	case mapping:
+		return hclObject(value)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func hclObject(fields []objectField) string {
+	items := make([]string, len(fields))
+	for i, field := range fields {
+		key := field.key
+		if !envVarNameRegex.MatchString(key) {
+			key = strconv.Quote(key)
+		}
+		items[i] = key + " = " + hclValue(field.value)
+	}
+	return "{ " + strings.Join(items, ", ") + " }"
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spiffe/spiffe-helper/pkg/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshal(t *testing.T) {
+	log, _ := test.NewNullLogger()
+	daemonMode := false
+	c := &Config{
+		AgentAddress:       "/tmp/agent.sock",
+		Cmd:                "envoy",
+		CmdArgs:            []interface{}{"-c", "envoy.yaml"},
+		CmdEnv:             map[string]string{"LOG_LEVEL": "debug", "APP-NAME": "${literal}"},
+		CmdStdout:          "file",
+		CmdStdoutFilename:  "envoy.log",
+		DaemonMode:         &daemonMode,
+		CertFileMode:       0640,
+		SVIDFilename:       "svid.pem",
+		SVIDKeyFilename:    "svid_key.pem",
+		SVIDBundleFilename: "svid_bundle.pem",
+		X509SVIDs: []X509SVIDConfig{
+			{SPIFFEID: "spiffe://example.org/db", SVIDFilename: "db.pem", SVIDKeyFilename: "db_key.pem", SVIDBundleFilename: "db_bundle.pem", AddIntermediatesToBundle: true},
+		},
+		JWTSVIDs: []JWTConfig{
+			{JWTAudience: "aud-1", JWTSVIDFilename: "jwt-1.token"},
+			{JWTAudience: "aud-2", JWTExtraAudiences: []string{"extra"}, JWTSVIDFilename: "jwt-2.token"},
+		},
+		HealthCheck: health.Config{ListenerEnabled: true, TLSEnabled: true, TLSAllowedSPIFFEIDs: []string{"spiffe://example.org/probe"}},
+	}
+	require.NoError(t, c.ValidateConfig(log))
+
+	hclConfig, err := c.Marshal(FormatHCL)
+	require.NoError(t, err)
+	assert.Equal(t, `agent_address = "/tmp/agent.sock"
+cmd = "envoy"
+cmd_args = ["-c", "envoy.yaml"]
+cmd_env = { "APP-NAME" = "$${literal}", LOG_LEVEL = "debug" }
+cmd_stdout = "file"
+cmd_stdout_file_name = "envoy.log"
+cmd_output_max_size_mb = 100
+cmd_output_max_backups = 3
+cert_file_mode = 0640
+key_file_mode = 0600
+jwt_bundle_file_mode = 0600
+jwt_svid_file_mode = 0600
+daemon_mode = false
+health_checks {
+  listener_enabled = true
+  bind_port = 8081
+  grpc_bind_port = 8082
+  liveness_path = "/live"
+  readiness_path = "/ready"
+  metrics_path = "/metrics"
+  status_path = "/status"
+  unix_socket_file_mode = 0660
+  tls_enabled = true
+  tls_allowed_spiffe_ids = ["spiffe://example.org/probe"]
+}
+svid_file_name = "svid.pem"
+svid_key_file_name = "svid_key.pem"
+svid_bundle_file_name = "svid_bundle.pem"
+x509_svid {
+  spiffe_id = "spiffe://example.org/db"
+  svid_file_name = "db.pem"
+  svid_key_file_name = "db_key.pem"
+  svid_bundle_file_name = "db_bundle.pem"
+  cert_file_mode = 0640
+  key_file_mode = 0600
+  add_intermediates_to_bundle = true
+}
+jwt_svids = [{ jwt_audience = "aud-1", jwt_svid_file_name = "jwt-1.token" }, { jwt_audience = "aud-2", jwt_extra_audiences = ["extra"], jwt_svid_file_name = "jwt-2.token" }]
+`, string(hclConfig))
+
+	jsonConfig, err := c.Marshal(FormatJSON)
+	require.NoError(t, err)
+	assert.Contains(t, string(jsonConfig), `"cert_file_mode": 416,`)
+	assert.Contains(t, string(jsonConfig), `"APP-NAME": "$${literal}"`)
+
+	// Both give back the same configuration, which is still valid. The
+	// rotation of cmd's output is printed with its defaults.
+	expected := *c
+	expected.CmdOutputMaxSizeMB, expected.CmdOutputMaxBackups = 100, 3
+	dir := t.TempDir()
+	for format, data := range map[string][]byte{FormatHCL: hclConfig, FormatJSON: jsonConfig} {
+		file := path.Join(dir, "helper."+format)
+		require.NoError(t, os.WriteFile(file, data, 0600))
+		parsed, err := ParseConfigFile(file)
+		require.NoError(t, err, format)
+		require.NoError(t, parsed.ValidateConfig(log), format)
+		parsed.positions = nil
+		assert.Equal(t, &expected, parsed, format)
+	}
+
+	_, err = c.Marshal(FormatYAML)
+	require.EqualError(t, err, `unknown output format "yaml", must be one of: hcl,json`)
+}
+
+func TestMarshalReferences(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := path.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("s3cr3t\n"), 0600))
+	t.Setenv("HELPER_AUDIENCE", "envoy")
+	t.Setenv("HELPER_CERT_DIR", "/run/certs")
+	configFile := path.Join(dir, "helper.conf")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+		agent_address = "/tmp/agent.sock"
+		cmd = "envoy"
+		cmd_env = { TOKEN = "${file:`+secretFile+`}" }
+		jwt_bundle_file_name = "bundle.json"
+		jwt_svids = [{ jwt_audience = "${HELPER_AUDIENCE}", jwt_extra_audiences = ["extra", "${file:`+secretFile+`}"], jwt_svid_file_name = "jwt.token" }]
+		health_checks {
+			listener_enabled = true
+			bind_port = "${HELPER_PORT:-8089}"
+		}
+	`), 0600))
+
+	log, _ := test.NewNullLogger()
+	overrides := newTestOverrides(t, nil, map[string]string{"SPIFFE_HELPER_CONFIG_CERT_DIR": "${HELPER_CERT_DIR}"})
+	c, err := ParseConfig(configFile, "", overrides)
+	require.NoError(t, err)
+	require.NoError(t, c.ValidateConfig(log))
+	assert.Equal(t, "s3cr3t", c.CmdEnv["TOKEN"])
+	assert.Equal(t, 8089, c.HealthCheck.BindPort)
+
+	// Values with references are printed as written, so the secrets they
+	// resolve to aren't shown
+	for _, format := range []string{FormatHCL, FormatJSON} {
+		data, err := c.Marshal(format)
+		require.NoError(t, err, format)
+		assert.NotContains(t, string(data), "s3cr3t", format)
+
+		file := path.Join(dir, "printed."+format)
+		require.NoError(t, os.WriteFile(file, data, 0600))
+		parsed, err := ParseConfigFile(file)
+		require.NoError(t, err, format)
+		require.NoError(t, parsed.ValidateConfig(log), format)
+		parsed.positions = c.positions
+		assert.Equal(t, c, parsed, format)
+	}
+
+	hclConfig, err := c.Marshal(FormatHCL)
+	require.NoError(t, err)
+	assert.Contains(t, string(hclConfig), `cmd_env = { TOKEN = "${file:`+secretFile+`}" }`)
+	assert.Contains(t, string(hclConfig), `cert_dir = "${HELPER_CERT_DIR}"`)
+	assert.Contains(t, string(hclConfig), `jwt_audience = "${HELPER_AUDIENCE}"`)
+	assert.Contains(t, string(hclConfig), `jwt_extra_audiences = ["extra", "${file:`+secretFile+`}"]`)
+	assert.Contains(t, string(hclConfig), `bind_port = "${HELPER_PORT:-8089}"`)
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CheckReload returns an error if the new configuration changes settings that
+// only take effect when the helper starts. Both configs must have been
+// validated.
+func (c *Config) CheckReload(newConfig *Config) error {
+	// The readiness and liveness durations are applied by the sidecar, while
+	// the rest of health_checks configures the listeners
+	healthCheck, newHealthCheck := c.HealthCheck, newConfig.HealthCheck
+	healthCheck.ReadinessMinLifetime, healthCheck.LivenessUpdateWindow = "", ""
+	newHealthCheck.ReadinessMinLifetime, newHealthCheck.LivenessUpdateWindow = "", ""
+
+	for _, setting := range []struct {
+		key              string
+		current, changed any
+	}{
+		{"daemon_mode", c.DaemonMode, newConfig.DaemonMode},
+		{"parallel_requests", c.ParallelRequests, newConfig.ParallelRequests},
+		{"cmd", c.Cmd, newConfig.Cmd},
+		{"cmd_args", c.CmdArgs, newConfig.CmdArgs},
+		{"cmd_args_parser", c.CmdArgsParser, newConfig.CmdArgsParser},
+		{"cmd_env", c.CmdEnv, newConfig.CmdEnv},
+		{"cmd_env_include_jwt_svids", c.CmdEnvIncludeJWTSVIDs, newConfig.CmdEnvIncludeJWTSVIDs},
+		{"cmd_stdin", c.CmdStdin, newConfig.CmdStdin},
+		{"cmd_stdout", c.CmdStdout, newConfig.CmdStdout},
+		{"cmd_stderr", c.CmdStderr, newConfig.CmdStderr},
+		{"cmd_stdout_file_name", c.CmdStdoutFilename, newConfig.CmdStdoutFilename},
+		{"cmd_stderr_file_name", c.CmdStderrFilename, newConfig.CmdStderrFilename},
+		{"cmd_output_max_size_mb", c.CmdOutputMaxSizeMB, newConfig.CmdOutputMaxSizeMB},
+		{"cmd_output_max_backups", c.CmdOutputMaxBackups, newConfig.CmdOutputMaxBackups},
+		{"health_checks", healthCheck, newHealthCheck},
+		{"log_file", c.LogFile, newConfig.LogFile},
+		{"log_file_max_size_mb", c.LogFileMaxSizeMB, newConfig.LogFileMaxSizeMB},
+		{"log_file_max_backups", c.LogFileMaxBackups, newConfig.LogFileMaxBackups},
+		{"audit_log_file", c.AuditLogFile, newConfig.AuditLogFile},
+		{"audit_log_file_mode", c.AuditLogFileMode, newConfig.AuditLogFileMode},
+		{"tracing_otlp_endpoint", c.TracingOTLPEndpoint, newConfig.TracingOTLPEndpoint},
+		{"tracing_otlp_insecure", c.TracingOTLPInsecure, newConfig.TracingOTLPInsecure},
+		{"tracing_service_name", c.TracingServiceName, newConfig.TracingServiceName},
+	} {
+		if !reflect.DeepEqual(setting.current, setting.changed) {
+			return fmt.Errorf("%s can't be changed without restarting", setting.key)
+		}
+	}
+
+	return nil
+}
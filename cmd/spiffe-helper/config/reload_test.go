@@ -0,0 +1,110 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spiffe/spiffe-helper/pkg/health"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckReload(t *testing.T) {
+	newConfig := func() *Config {
+		return &Config{
+			AgentAddress:       "path",
+			Cmd:                "nginx",
+			SVIDFilename:       "cert.pem",
+			SVIDKeyFilename:    "key.pem",
+			SVIDBundleFilename: "bundle.pem",
+			LogFile:            "helper.log",
+			HealthCheck: health.Config{
+				ListenerEnabled: true,
+			},
+		}
+	}
+
+	for _, tt := range []struct {
+		name        string
+		change      func(*Config)
+		expectError string
+	}{
+		{
+			name:   "unchanged",
+			change: func(*Config) {},
+		},
+		{
+			name: "outputs, notifications and logging",
+			change: func(c *Config) {
+				c.CertDir = "/run/certs"
+				c.CertFileMode = 0640
+				c.JWTSVIDs = []JWTConfig{{JWTAudience: "aud", JWTSVIDFilename: "jwt.token"}}
+				c.RenewSignal = "SIGHUP"
+				c.NotifyTargets = []NotifyTargetConfig{{ProcessName: "envoy", RenewSignal: "SIGUSR1"}}
+				c.LogLevel = "debug"
+				c.LogFormat = "json"
+				c.CmdStopTimeout = "30s"
+			},
+		},
+		{
+			name: "health check durations",
+			change: func(c *Config) {
+				c.HealthCheck.ReadinessMinLifetime = "5m"
+				c.HealthCheck.LivenessUpdateWindow = "1h"
+			},
+		},
+		{
+			name:        "health check listener",
+			change:      func(c *Config) { c.HealthCheck.BindPort = 9000 },
+			expectError: "health_checks can't be changed without restarting",
+		},
+		{
+			name:        "cmd",
+			change:      func(c *Config) { c.Cmd = "envoy" },
+			expectError: "cmd can't be changed without restarting",
+		},
+		{
+			name:        "cmd_args",
+			change:      func(c *Config) { c.CmdArgs = []interface{}{"-c", "envoy.yaml"} },
+			expectError: "cmd_args can't be changed without restarting",
+		},
+		{
+			name: "daemon_mode",
+			change: func(c *Config) {
+				daemonMode := false
+				c.DaemonMode = &daemonMode
+			},
+			expectError: "daemon_mode can't be changed without restarting",
+		},
+		{
+			name:        "log_file",
+			change:      func(c *Config) { c.LogFile = "other.log" },
+			expectError: "log_file can't be changed without restarting",
+		},
+		{
+			name:        "audit_log_file",
+			change:      func(c *Config) { c.AuditLogFile = "audit.log" },
+			expectError: "audit_log_file can't be changed without restarting",
+		},
+		{
+			name:        "tracing_otlp_endpoint",
+			change:      func(c *Config) { c.TracingOTLPEndpoint = "localhost:4317" },
+			expectError: "tracing_otlp_endpoint can't be changed without restarting",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			log, _ := test.NewNullLogger()
+			current := newConfig()
+			require.NoError(t, current.ValidateConfig(log))
+			changed := newConfig()
+			tt.change(changed)
+			require.NoError(t, changed.ValidateConfig(log))
+
+			err := current.CheckReload(changed)
+			if tt.expectError != "" {
+				require.EqualError(t, err, tt.expectError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
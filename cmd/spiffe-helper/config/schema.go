@@ -0,0 +1,462 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/spiffe-helper/pkg/health"
+	"github.com/spiffe/spiffe-helper/pkg/sidecar"
+	"github.com/spiffe/spiffe-helper/pkg/systemd"
+)
+
+//go:generate go test -run TestSchema -update-schema .
+
+// schema is a JSON Schema, or part of one
+type schema map[string]interface{}
+
+// schemaDescriptions describes every key of the configuration, with the keys
+// of blocks prefixed with the name of the block. Generating the schema fails
+// if a key is missing, so that new keys are described.
+var schemaDescriptions = map[string]string{
+	"add_intermediates_to_bundle": "Add intermediate certificates into the bundle file instead of the SVID file.",
+	"agent_address":               "Socket address of the SPIRE Agent. Defaults to SPIFFE_ENDPOINT_SOCKET if set.",
+	"audit_log_file":              "Hash-chained JSON lines file recording every credential written.",
+	"audit_log_file_mode":         "Permissions of audit_log_file when it is created, e.g. 0600 (384 in JSON).",
+	"cert_dir":                    "Directory to store the fetched certificates in. It must already exist.",
+	"cert_file_mode":              "File mode of the X.509 certificate and bundle files, e.g. 0644 (420 in JSON).",
+	"cmd":                         "The path to the process to launch, monitor and signal when the credentials are renewed. Ignored if daemon_mode is false.",
+	"cmd_args":                    "The arguments of the process to launch, as a list. A string is also accepted but deprecated.",
+	"cmd_args_parser":             "How a string cmd_args is split into arguments.",
+	"cmd_env":                     "Extra environment variables for the process to launch.",
+	"cmd_env_include_jwt_svids":   "Pass the JWT SVIDs to the process to launch in SPIFFE_HELPER_JWT_SVID_<n> environment variables.",
+	"cmd_output_max_backups":      "Number of rotated output files of cmd to keep.",
+	"cmd_output_max_size_mb":      "Size in megabytes at which cmd_stdout_file_name and cmd_stderr_file_name are rotated.",
+	"cmd_stderr":                  "Where the stderr of cmd goes.",
+	"cmd_stderr_file_name":        "File to write the stderr of cmd to when cmd_stderr is file.",
+	"cmd_stdin":                   "How to connect the stdin of cmd.",
+	"cmd_stdout":                  "Where the stdout of cmd goes.",
+	"cmd_stdout_file_name":        "File to write the stdout of cmd to when cmd_stdout is file.",
+	"cmd_stop_signal":             "The signal sent to cmd when spiffe-helper shuts down. Defaults to SIGTERM. Not supported on Windows.",
+	"cmd_stop_timeout":            "How long cmd is given to exit after cmd_stop_signal before it is killed. Defaults to 10s.",
+	"daemon_mode":                 "Keep the credentials up to date, rather than fetching them once and exiting.",
+	"health_checks":               "The HTTP and gRPC health servers.",
+	"hint":                        "Hint to use to pick the SPIFFE ID.",
+	"include_federated_domains":   "Include the bundles of federated trust domains in the bundle file.",
+	"jwt_bundle_file_mode":        "File mode of the JWT bundle file, e.g. 0600 (384 in JSON).",
+	"jwt_bundle_file_name":        "File to store the JWT bundle in, in JSON format.",
+	"jwt_svid_file_mode":          "File mode of the JWT SVID files, e.g. 0600 (384 in JSON).",
+	"jwt_svids":                   "The JWT SVIDs to fetch and the files to store them in.",
+	"key_file_mode":               "File mode of the X.509 private key file, e.g. 0600 (384 in JSON).",
+	"log_file":                    "File to write the helper's logs to, instead of stderr.",
+	"log_file_max_backups":        "Number of rotated log files to keep.",
+	"log_file_max_size_mb":        "Size in megabytes at which log_file is rotated.",
+	"log_format":                  "Format of the helper's logs.",
+	"log_level":                   "Level of the helper's logs.",
+	"notify_debounce":             "How long to wait for further credential updates before running cmd and signalling processes.",
+	"notify_max_delay":            "Longest time notify_debounce holds back notifications after the first pending update. Defaults to ten times notify_debounce.",
+	"notify_min_interval":         "Minimum time between two rounds of cmd runs and signals.",
+	"notify_targets":              "External processes to signal when the credentials are renewed.",
+	"parallel_requests":           "Make this many requests to the Workload API in parallel, to load test it, instead of watching it.",
+	"pid_file_name":               "File containing the ID of a process to signal when the credentials are renewed.",
+	"renew_signal":                "The signal that cmd or the pid_file_name process expects to reload the credentials. Not supported on Windows.",
+	"svid_bundle_file_name":       "File to store the X.509 bundle in, in PEM format.",
+	"svid_file_name":              "File to store the X.509 SVID certificate in, in PEM format.",
+	"svid_key_file_name":          "File to store the X.509 SVID private key in, in PEM format.",
+	"tracing_otlp_endpoint":       "host:port of an OTLP/gRPC collector to export traces to.",
+	"tracing_otlp_insecure":       "Export traces without TLS.",
+	"tracing_service_name":        "Service name the traces are reported under.",
+	"x509_svid":                   "Further X.509 SVIDs to write, each with its own SVID selection, files and format.",
+
+	"health_checks.bind_address":            "The address to run the HTTP health server on. Defaults to all interfaces.",
+	"health_checks.bind_port":               "The port to run the HTTP health server on.",
+	"health_checks.debug_endpoints_enabled": "Serve Go's net/http/pprof profiles at /debug/pprof/ and expvar variables at /debug/vars.",
+	"health_checks.grpc_bind_port":          "The port to run the gRPC health server on.",
+	"health_checks.grpc_listener_enabled":   "Serve the gRPC health checking protocol. Doesn't apply outside of daemon_mode.",
+	"health_checks.listener_enabled":        "Start an HTTP server for the health of the daemon. Doesn't apply outside of daemon_mode.",
+	"health_checks.liveness_path":           "The URL path of the liveness check.",
+	"health_checks.liveness_update_window":  "Fail the liveness check when an SVID file hasn't been written within this duration. Disabled by default.",
+	"health_checks.metrics_path":            "The URL path of the Prometheus metrics.",
+	"health_checks.readiness_min_lifetime":  "Fail the readiness check when an SVID written to disk expires within this duration. Disabled by default.",
+	"health_checks.readiness_path":          "The URL path of the readiness check.",
+	"health_checks.status_path":             "The URL path of the details of the credentials written to each file.",
+	"health_checks.tls_allowed_spiffe_ids":  "SPIFFE IDs allowed to call the health server over TLS. Defaults to any SPIFFE ID in the helper's trust domain.",
+	"health_checks.tls_enabled":             "Serve HTTPS with the helper's own X.509 SVID, requiring callers to present an X.509 SVID.",
+	"health_checks.unix_socket_file_mode":   "File mode of the Unix socket, e.g. 0660 (432 in JSON).",
+	"health_checks.unix_socket_path":        "Listen on a Unix socket at this path instead of bind_address and bind_port.",
+
+	"jwt_svids.jwt_audience":        "Audience of the JWT SVID.",
+	"jwt_svids.jwt_extra_audiences": "Further audiences of the JWT SVID.",
+	"jwt_svids.jwt_svid_file_name":  "File to store the JWT SVID in.",
+
+	"x509_svid.add_intermediates_to_bundle": "Add intermediate certificates into the bundle file instead of the SVID file.",
+	"x509_svid.cert_file_mode":              "File mode of the X.509 certificate and bundle files, e.g. 0644 (420 in JSON). Defaults to cert_file_mode.",
+	"x509_svid.hint":                        "Hint of the SVID to write. The default SVID is written if neither hint nor spiffe_id is set.",
+	"x509_svid.include_federated_domains":   "Include the bundles of federated trust domains in the bundle file.",
+	"x509_svid.key_file_mode":               "File mode of the X.509 private key file, e.g. 0600 (384 in JSON). Defaults to key_file_mode.",
+	"x509_svid.spiffe_id":                   "SPIFFE ID of the SVID to write.",
+	"x509_svid.svid_bundle_file_name":       "File to store the X.509 bundle in, in PEM format.",
+	"x509_svid.svid_file_name":              "File to store the X.509 SVID certificate in, in PEM format.",
+	"x509_svid.svid_key_file_name":          "File to store the X.509 SVID private key in, in PEM format.",
+
+	"notify_targets.cgroup_path":         "Signal every process in this cgroup or in a cgroup nested below it. Linux only.",
+	"notify_targets.cmdline_regex":       "Signal every process whose space-separated command line matches this regular expression. Linux only.",
+	"notify_targets.credential_types":    "Which credential rotations signal the process. All of them if not set.",
+	"notify_targets.pid_file_name":       "File containing the ID of the process to signal.",
+	"notify_targets.process_name":        "Signal every process with this executable name. Linux only.",
+	"notify_targets.renew_signal":        "The signal to send to the process. Required unless using systemd_unit. Not supported on Windows.",
+	"notify_targets.systemd_unit":        "Reload or restart this systemd unit with systemctl instead of signalling a process.",
+	"notify_targets.systemd_unit_action": "What to do with systemd_unit.",
+}
+
+// schemaEnums lists the values of the keys taking one of a few values. For
+// lists, they are the values of the elements.
+var schemaEnums = map[string][]string{
+	"cmd_args_parser":                    sidecar.CmdArgsParsers,
+	"cmd_stdin":                          cmdStdinModes,
+	"cmd_stdout":                         cmdOutputModes,
+	"cmd_stderr":                         cmdOutputModes,
+	"log_format":                         logFormats,
+	"log_level":                          {"panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"},
+	"notify_targets.credential_types":    sidecar.CredentialTypes,
+	"notify_targets.systemd_unit_action": systemd.UnitActions,
+}
+
+// schemaDurations are the keys taking a duration such as "1m30s"
+var schemaDurations = []string{
+	"cmd_stop_timeout",
+	"notify_debounce",
+	"notify_max_delay",
+	"notify_min_interval",
+	"health_checks.readiness_min_lifetime",
+	"health_checks.liveness_update_window",
+}
+
+// schemaConstraints are the constraints between the keys of the
+// configuration and of its blocks, beyond the type of each key
+var schemaConstraints = map[string]schema{
+	"": {
+		"dependentRequired": map[string][]string{
+			"svid_file_name":        {"svid_key_file_name", "svid_bundle_file_name"},
+			"svid_key_file_name":    {"svid_file_name", "svid_bundle_file_name"},
+			"svid_bundle_file_name": {"svid_file_name", "svid_key_file_name"},
+			"pid_file_name":         {"renew_signal"},
+			"log_file_max_size_mb":  {"log_file"},
+			"log_file_max_backups":  {"log_file"},
+			"audit_log_file_mode":   {"audit_log_file"},
+			"tracing_service_name":  {"tracing_otlp_endpoint"},
+		},
+		"anyOf": []schema{
+			{"required": []string{"svid_file_name", "svid_key_file_name", "svid_bundle_file_name"}},
+			{"required": []string{"x509_svid"}},
+			{"required": []string{"jwt_svids"}},
+			{"required": []string{"jwt_bundle_file_name"}},
+		},
+		"allOf": []schema{
+			ifSet("tracing_otlp_insecure", true, schema{"required": []string{"tracing_otlp_endpoint"}}),
+			ifSet("daemon_mode", false, noneOf("pid_file_name", "notify_targets")),
+			{
+				"if":   schema{"properties": schema{"cmd_args": schema{"type": "array"}}, "required": []string{"cmd_args"}},
+				"then": noneOf("cmd_args_parser"),
+			},
+			ifSet("cmd_stdout", cmdOutputFile, schema{"required": []string{"cmd_stdout_file_name"}}),
+			{"if": schema{"required": []string{"cmd_stdout_file_name"}}, "then": isSet("cmd_stdout", cmdOutputFile)},
+			ifSet("cmd_stderr", cmdOutputFile, schema{"required": []string{"cmd_stderr_file_name"}}),
+			{"if": schema{"required": []string{"cmd_stderr_file_name"}}, "then": isSet("cmd_stderr", cmdOutputFile)},
+			{
+				"if": schema{"properties": schema{"health_checks": isSet("tls_enabled", true)}, "required": []string{"health_checks"}},
+				"then": schema{"anyOf": []schema{
+					{"required": []string{"svid_file_name", "svid_key_file_name", "svid_bundle_file_name"}},
+					{"required": []string{"x509_svid"}},
+				}},
+			},
+		},
+	},
+	"health_checks": {
+		"allOf": []schema{
+			{"not": schema{"required": []string{"bind_address", "unix_socket_path"}}},
+			{"if": schema{"required": []string{"unix_socket_path"}}, "then": schema{"not": isSet("grpc_listener_enabled", true)}},
+			{"if": schema{"required": []string{"tls_allowed_spiffe_ids"}}, "then": isSet("tls_enabled", true)},
+		},
+	},
+	"x509_svid": {
+		"required": []string{"svid_file_name", "svid_key_file_name", "svid_bundle_file_name"},
+		"not":      schema{"required": []string{"hint", "spiffe_id"}},
+	},
+	"jwt_svids": {
+		"required": []string{"jwt_audience", "jwt_svid_file_name"},
+	},
+	"notify_targets": {
+		"anyOf": []schema{
+			{"required": []string{"pid_file_name"}},
+			{"required": []string{"process_name"}},
+			{"required": []string{"cmdline_regex"}},
+			{"required": []string{"cgroup_path"}},
+			{"required": []string{"systemd_unit"}},
+		},
+		"dependentRequired": map[string][]string{
+			"systemd_unit_action": {"systemd_unit"},
+		},
+		"allOf": []schema{
+			{
+				"if":   schema{"required": []string{"systemd_unit"}},
+				"then": noneOf("pid_file_name", "process_name", "cmdline_regex", "cgroup_path", "renew_signal"),
+				"else": schema{"required": []string{"renew_signal"}},
+			},
+			{"if": schema{"required": []string{"pid_file_name"}}, "then": noneOf("process_name", "cmdline_regex", "cgroup_path")},
+		},
+	},
+}
+
+// isSet matches objects where key is set to value
+func isSet(key string, value interface{}) schema {
+	return schema{"properties": schema{key: schema{"const": value}}, "required": []string{key}}
+}
+
+// ifSet applies then to objects where key is set to value
+func ifSet(key string, value interface{}, then schema) schema {
+	return schema{"if": isSet(key, value), "then": then}
+}
+
+// noneOf matches objects where none of the keys is set
+func noneOf(keys ...string) schema {
+	anyOf := make([]schema, len(keys))
+	for i, key := range keys {
+		anyOf[i] = schema{"required": []string{key}}
+	}
+	return schema{"not": schema{"anyOf": anyOf}}
+}
+
+// Schema returns the JSON Schema of the configuration files, as shipped in
+// spiffe-helper.schema.json. It fails if the descriptions or constraints
+// refer to keys that don't exist, or if a key isn't described.
+func Schema() ([]byte, error) {
+	g := &schemaGenerator{defs: schema{}, defaults: schemaDefaults()}
+	root, err := g.object("", reflect.TypeOf(Config{}))
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range schemaDescriptions {
+		if !g.described[key] {
+			return nil, fmt.Errorf("description of unknown key %q", key)
+		}
+	}
+	for _, keys := range [][]string{slices.Collect(maps.Keys(schemaEnums)), schemaDurations} {
+		for _, key := range keys {
+			if !g.described[key] {
+				return nil, fmt.Errorf("unknown key %q", key)
+			}
+		}
+	}
+
+	g.defs["reference"] = schema{
+		"description": "A reference to an environment variable or a file, expanded when the configuration is parsed.",
+		"type":        "string",
+		"pattern":     `\$\{[^}]+\}`,
+	}
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "spiffe-helper configuration"
+	root["$defs"] = g.defs
+
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+type schemaGenerator struct {
+	defs      schema
+	defaults  map[string]interface{}
+	described map[string]bool
+}
+
+// object returns the schema of a block, with its constraints
+func (g *schemaGenerator) object(path string, t reflect.Type) (schema, error) {
+	properties := schema{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("hcl"), ",")[0]
+		if key == "" || !field.IsExported() {
+			continue
+		}
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		property, err := g.property(keyPath, field.Type)
+		if err != nil {
+			return nil, err
+		}
+		description, ok := schemaDescriptions[keyPath]
+		if !ok {
+			return nil, fmt.Errorf("no description of %q", keyPath)
+		}
+		if g.described == nil {
+			g.described = make(map[string]bool)
+		}
+		g.described[keyPath] = true
+		property["description"] = description
+		if value, ok := g.defaults[keyPath]; ok {
+			property["default"] = value
+		}
+		properties[key] = property
+	}
+
+	s := schema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	for keyword, constraint := range schemaConstraints[path] {
+		for _, key := range constraintKeys(schema{keyword: constraint}) {
+			if _, ok := properties[key]; !ok {
+				return nil, fmt.Errorf("constraint of %q refers to unknown key %q", path, key)
+			}
+		}
+		s[keyword] = constraint
+	}
+	return s, nil
+}
+
+// property returns the schema of a key. Integers, durations and enums may
+// also be references to environment variables or files. Booleans may not, as
+// the HCL decoder doesn't take a string for them.
+func (g *schemaGenerator) property(path string, t reflect.Type) (schema, error) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.property(path, t.Elem())
+	case reflect.Bool:
+		return schema{"type": "boolean"}, nil
+	case reflect.Int:
+		return orReference(schema{"type": "integer", "minimum": 0}), nil
+	case reflect.String:
+		if values, ok := schemaEnums[path]; ok {
+			return orReference(schema{"enum": values}), nil
+		}
+		if slices.Contains(schemaDurations, path) {
+			return orReference(schema{"type": "string", "pattern": `^([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$`}), nil
+		}
+		return schema{"type": "string"}, nil
+	case reflect.Interface:
+		// cmd_args, a string or a list
+		return schema{"anyOf": []schema{
+			{"type": "string"},
+			{"type": "array", "items": schema{"type": "string"}},
+		}}, nil
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": schema{"type": "string"}}, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Struct {
+			if err := g.def(path, t.Elem()); err != nil {
+				return nil, err
+			}
+			return schema{"type": "array", "items": schema{"$ref": "#/$defs/" + path}}, nil
+		}
+		items := schema{"type": "string"}
+		if values, ok := schemaEnums[path]; ok {
+			items = orReference(schema{"enum": values})
+		}
+		return schema{"type": "array", "items": items}, nil
+	case reflect.Struct:
+		if err := g.def(path, t); err != nil {
+			return nil, err
+		}
+		return schema{"$ref": "#/$defs/" + path}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s of %q", t, path)
+	}
+}
+
+// def defines the schema of a block in $defs
+func (g *schemaGenerator) def(path string, t reflect.Type) error {
+	s, err := g.object(path, t)
+	if err != nil {
+		return err
+	}
+	g.defs[path] = s
+	return nil
+}
+
+func orReference(s schema) schema {
+	return schema{"anyOf": []schema{s, {"$ref": "#/$defs/reference"}}}
+}
+
+// constraintKeys returns the keys a constraint refers to
+func constraintKeys(s schema) []string {
+	var keys []string
+	for keyword, value := range s {
+		switch keyword {
+		case "required":
+			keys = append(keys, value.([]string)...)
+		case "dependentRequired":
+			for key, dependencies := range value.(map[string][]string) {
+				keys = append(keys, key)
+				keys = append(keys, dependencies...)
+			}
+		case "properties":
+			for key := range value.(schema) {
+				keys = append(keys, key)
+			}
+		case "allOf", "anyOf":
+			for _, sub := range value.([]schema) {
+				keys = append(keys, constraintKeys(sub)...)
+			}
+		case "not", "if", "then", "else":
+			keys = append(keys, constraintKeys(value.(schema))...)
+		}
+	}
+	return keys
+}
+
+// schemaDefaults returns the defaults of the keys, as set by validating a
+// configuration with every feature that has defaults enabled
+func schemaDefaults() map[string]interface{} {
+	c := &Config{
+		AgentAddress:        defaultAgentAddress,
+		SVIDFilename:        "svid.pem",
+		SVIDKeyFilename:     "svid_key.pem",
+		SVIDBundleFilename:  "svid_bundle.pem",
+		LogFile:             "spiffe-helper.log",
+		AuditLogFile:        "audit.log",
+		TracingOTLPEndpoint: "localhost:4317",
+		HealthCheck:         health.Config{ListenerEnabled: true},
+	}
+	before := *c
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	_ = c.validate(logger)
+
+	defaults := map[string]interface{}{
+		"agent_address":                      defaultAgentAddress,
+		"daemon_mode":                        true,
+		"cmd_args_parser":                    sidecar.CmdArgsParserLegacy,
+		"cmd_stdin":                          cmdStdinInherit,
+		"cmd_stdout":                         cmdOutputInherit,
+		"cmd_stderr":                         cmdOutputInherit,
+		"cmd_output_max_size_mb":             defaultCmdOutputMaxSize,
+		"cmd_output_max_backups":             defaultCmdOutputBackups,
+		"log_level":                          logrus.InfoLevel.String(),
+		"log_format":                         logFormatText,
+		"notify_targets.systemd_unit_action": systemd.UnitActionReload,
+	}
+	addChanged := func(prefix string, before, after reflect.Value) {
+		for i := 0; i < after.NumField(); i++ {
+			key := strings.Split(after.Type().Field(i).Tag.Get("hcl"), ",")[0]
+			if key != "" && !reflect.DeepEqual(before.Field(i).Interface(), after.Field(i).Interface()) &&
+				after.Field(i).Kind() != reflect.Struct {
+				defaults[prefix+key] = after.Field(i).Interface()
+			}
+		}
+	}
+	addChanged("", reflect.ValueOf(before), reflect.ValueOf(*c))
+	addChanged("health_checks.", reflect.ValueOf(before.HealthCheck), reflect.ValueOf(c.HealthCheck))
+	return defaults
+}
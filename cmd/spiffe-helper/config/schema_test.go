@@ -0,0 +1,70 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const schemaFile = "../../../spiffe-helper.schema.json"
+
+var updateSchema = flag.Bool("update-schema", false, "Update "+schemaFile+" from the configuration structs")
+
+// The shipped schema must match the configuration structs. Run 'go generate
+// ./cmd/spiffe-helper/config' to update it.
+func TestSchema(t *testing.T) {
+	generated, err := Schema()
+	require.NoError(t, err)
+	if *updateSchema {
+		require.NoError(t, os.WriteFile(schemaFile, generated, 0600))
+	}
+
+	shipped, err := os.ReadFile(schemaFile)
+	require.NoError(t, err)
+	assert.Equal(t, string(generated), string(shipped), "%s is out of date, run 'go generate ./cmd/spiffe-helper/config'", schemaFile)
+
+	var s struct {
+		Properties map[string]struct {
+			Default interface{} `json:"default"`
+		} `json:"properties"`
+		Defs map[string]struct {
+			Properties map[string]struct {
+				Default interface{} `json:"default"`
+			} `json:"properties"`
+		} `json:"$defs"`
+	}
+	require.NoError(t, json.Unmarshal(generated, &s))
+	// UnusedKeyPositions, positions and references aren't keys
+	assert.Len(t, s.Properties, reflect.TypeOf(Config{}).NumField()-3)
+	assert.Equal(t, float64(defaultCertFileMode), s.Properties["cert_file_mode"].Default)
+	assert.Equal(t, true, s.Properties["daemon_mode"].Default)
+	assert.Equal(t, defaultTracingService, s.Properties["tracing_service_name"].Default)
+	assert.Equal(t, float64(defaultBindPort), s.Defs["health_checks"].Properties["bind_port"].Default)
+	assert.Equal(t, defaultStatusPath, s.Defs["health_checks"].Properties["status_path"].Default)
+	assert.Contains(t, s.Defs, "x509_svid")
+	assert.Contains(t, s.Defs, "jwt_svids")
+	assert.Contains(t, s.Defs, "notify_targets")
+}
+
+func TestSchemaDrift(t *testing.T) {
+	type undescribed struct {
+		AgentAddress string `hcl:"agent_address"`
+		NewKey       string `hcl:"new_key"`
+	}
+	g := &schemaGenerator{defs: schema{}}
+	_, err := g.object("", reflect.TypeOf(undescribed{}))
+	require.EqualError(t, err, `no description of "new_key"`)
+
+	type constrained struct {
+		SVIDFilename string `hcl:"svid_file_name"`
+	}
+	g = &schemaGenerator{defs: schema{}}
+	_, err = g.object("", reflect.TypeOf(constrained{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `constraint of "" refers to unknown key`)
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+	"github.com/sirupsen/logrus"
+)
+
+// Problem is an invalid or unknown key in the configuration
+type Problem struct {
+	// Key is the path of the key the problem is about, e.g.
+	// health_checks.bind_port, x509_svid[1].spiffe_id or
+	// jwt_svids[0].jwt_audience. It is empty for problems that aren't about
+	// a single key.
+	Key string
+	// Pos is where Key is set in the configuration file. It is invalid if
+	// Key isn't set in the file, e.g. when it is set by a flag.
+	Pos token.Pos
+	Err error
+}
+
+func (p *Problem) Error() string {
+	if p.Pos.IsValid() {
+		return fmt.Sprintf("line %d, column %d: %v", p.Pos.Line, p.Pos.Column, p.Err)
+	}
+	return p.Err.Error()
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Err
+}
+
+// problems collects the problems found while validating a configuration
+type problems struct {
+	config *Config
+	list   []*Problem
+}
+
+func (p *problems) add(key string, err error) {
+	p.list = append(p.list, &Problem{Key: key, Pos: p.config.position(key), Err: err})
+}
+
+// Validate checks the configuration and sets the defaults of the keys that
+// aren't set, like ValidateConfig. It returns every problem found rather
+// than the first one, including each unknown key, ordered by their position
+// in the configuration file.
+func (c *Config) Validate(log logrus.FieldLogger) []*Problem {
+	var unknownKeys []*Problem
+	addUnknownKeys := func(prefix string, positions map[string][]token.Pos) {
+		for key, keyPositions := range positions {
+			for _, pos := range keyPositions {
+				unknownKeys = append(unknownKeys, &Problem{Key: prefix + key, Pos: pos, Err: fmt.Errorf("unknown key %q", prefix+key)})
+			}
+		}
+	}
+	addUnknownKeys("", c.UnusedKeyPositions)
+	for i, x509SVID := range c.X509SVIDs {
+		addUnknownKeys(fmt.Sprintf("x509_svid[%d].", i), x509SVID.UnusedKeyPositions)
+	}
+	for i, jwtSVID := range c.JWTSVIDs {
+		addUnknownKeys(fmt.Sprintf("jwt_svids[%d].", i), jwtSVID.UnusedKeyPositions)
+	}
+	for i, notifyTarget := range c.NotifyTargets {
+		addUnknownKeys(fmt.Sprintf("notify_targets[%d].", i), notifyTarget.UnusedKeyPositions)
+	}
+
+	all := append(unknownKeys, c.validate(log)...)
+	// Problems without a position, e.g. set by flags, come last
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := all[i].Pos, all[j].Pos
+		switch {
+		case !a.IsValid() || !b.IsValid():
+			return a.IsValid() && !b.IsValid()
+		case a.Line != b.Line:
+			return a.Line < b.Line
+		default:
+			return a.Column < b.Column
+		}
+	})
+	return all
+}
+
+// keyPositions records where each key of the configuration file is set,
+// down to the keys of the blocks and lists of blocks, e.g. health_checks,
+// health_checks.bind_port, jwt_svids[1] and jwt_svids[1].jwt_audience
+func keyPositions(root *ast.ObjectList) map[string]token.Pos {
+	if len(root.Items) == 0 {
+		return nil
+	}
+
+	positions := make(map[string]token.Pos)
+	record := func(key string, pos token.Pos) {
+		if _, ok := positions[key]; !ok {
+			positions[key] = pos
+		}
+	}
+	recordBlock := func(key string, block *ast.ObjectType) {
+		record(key, block.Lbrace)
+		for _, item := range block.List.Items {
+			if len(item.Keys) > 0 {
+				record(key+"."+objectKey(item), item.Keys[0].Pos())
+			}
+		}
+	}
+
+	blocks := make(map[string]int)
+	for _, item := range root.Items {
+		if len(item.Keys) == 0 {
+			continue
+		}
+		key := objectKey(item)
+		record(key, item.Keys[0].Pos())
+
+		switch val := item.Val.(type) {
+		case *ast.ObjectType:
+			// A block, or one of several blocks making up a list
+			recordBlock(key, val)
+			recordBlock(fmt.Sprintf("%s[%d]", key, blocks[key]), val)
+			blocks[key]++
+		case *ast.ListType:
+			for _, element := range val.List {
+				if block, ok := element.(*ast.ObjectType); ok {
+					recordBlock(fmt.Sprintf("%s[%d]", key, blocks[key]), block)
+					blocks[key]++
+				}
+			}
+		}
+	}
+
+	return positions
+}
+
+// objectKey returns the name of the first key of an item, lower-cased as
+// keys are matched regardless of case
+func objectKey(item *ast.ObjectItem) string {
+	key, _ := item.Keys[0].Token.Value().(string)
+	return strings.ToLower(key)
+}
+
+// position returns where a key is set in the configuration file. Keys that
+// aren't set, such as required keys that are missing, get the position of
+// the closest enclosing key that is.
+func (c *Config) position(key string) token.Pos {
+	for key != "" {
+		if pos, ok := c.positions[key]; ok {
+			return pos
+		}
+		key = key[:strings.LastIndexAny(key, ".[")+1]
+		key = strings.TrimRight(key, ".[")
+	}
+	return token.Pos{}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		format   string
+		config   string
+		expected []string
+	}{
+		{
+			format: FormatHCL,
+			config: `
+agent_address = "/tmp/agent.sock"
+log_level = "loud"
+foo = "bar"
+svid_file_name = "svid.pem"
+jwt_svids = [
+  { jwt_audience = "aud", bar = "foo" },
+]
+x509_svid {
+  svid_file_name = "db.pem"
+  svid_key_file_name = "db_key.pem"
+  svid_bundle_file_name = "db_bundle.pem"
+  spiffe_id = "db"
+}
+health_checks {
+  listener_enabled = true
+  bind_port = -1
+  status_path = "/live"
+}
+notify_debounce = "soon"
+`,
+			expected: []string{
+				`line 3, column 1: invalid log_level: not a valid logrus Level: "loud"`,
+				`line 4, column 1: unknown key "foo"`,
+				`line 5, column 1: all or none of 'svid_file_name', 'svid_key_file_name', 'svid_bundle_file_name' must be specified`,
+				`line 7, column 3: 'jwt_file_name' is required in 'jwt_svids'`,
+				`line 7, column 27: unknown key "jwt_svids[0].bar"`,
+				`line 13, column 3: invalid x509_svid[0].spiffe_id: scheme is missing or invalid`,
+				`line 17, column 3: bind port must be positive`,
+				`line 18, column 3: health_checks.status_path "/live" is also used by health_checks.liveness_path`,
+				`line 20, column 1: invalid notify_debounce: time: invalid duration "soon"`,
+			},
+		},
+		{
+			format: FormatYAML,
+			config: `
+agent_address: /tmp/agent.sock
+log_level: loud
+foo: bar
+svid_file_name: svid.pem
+jwt_svids:
+  - jwt_audience: aud
+    bar: foo
+x509_svid:
+  - svid_file_name: db.pem
+    svid_key_file_name: db_key.pem
+    svid_bundle_file_name: db_bundle.pem
+    spiffe_id: db
+health_checks:
+  listener_enabled: true
+  bind_port: -1
+notify_debounce: soon
+`,
+			expected: []string{
+				`line 3, column 1: invalid log_level: not a valid logrus Level: "loud"`,
+				`line 4, column 1: unknown key "foo"`,
+				`line 5, column 1: all or none of 'svid_file_name', 'svid_key_file_name', 'svid_bundle_file_name' must be specified`,
+				`line 7, column 5: 'jwt_file_name' is required in 'jwt_svids'`,
+				`line 8, column 5: unknown key "jwt_svids[0].bar"`,
+				`line 13, column 5: invalid x509_svid[0].spiffe_id: scheme is missing or invalid`,
+				`line 16, column 3: bind port must be positive`,
+				`line 17, column 1: invalid notify_debounce: time: invalid duration "soon"`,
+			},
+		},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			file := path.Join(dir, "helper."+tt.format)
+			require.NoError(t, os.WriteFile(file, []byte(tt.config), 0600))
+			c, err := ParseConfigFile(file)
+			require.NoError(t, err)
+
+			log, _ := test.NewNullLogger()
+			var problems []string
+			for _, problem := range c.Validate(log) {
+				problems = append(problems, problem.Error())
+			}
+			assert.Equal(t, tt.expected, problems)
+		})
+	}
+}
+
+func TestValidateOverrides(t *testing.T) {
+	configFile := path.Join(t.TempDir(), "helper.conf")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+log_level = "loud"
+jwt_svids = [{ jwt_audience = "aud", jwt_svid_file_name = "jwt.token" }]
+`), 0600))
+
+	// Problems with overrides have no position, and come last
+	overrides := newTestOverrides(t, []string{"-notify-debounce", "soon"}, nil)
+	c, err := ParseConfig(configFile, "", overrides)
+	require.NoError(t, err)
+
+	log, _ := test.NewNullLogger()
+	problems := c.Validate(log)
+	require.Len(t, problems, 2)
+	assert.EqualError(t, problems[0], `line 2, column 1: invalid log_level: not a valid logrus Level: "loud"`)
+	assert.Equal(t, "log_level", problems[0].Key)
+	assert.EqualError(t, problems[1], `invalid notify_debounce: time: invalid duration "soon"`)
+	assert.Equal(t, "notify_debounce", problems[1].Key)
+	assert.False(t, problems[1].Pos.IsValid())
+
+	// ValidateConfig returns the first problem, without its position
+	c, err = ParseConfig(configFile, "", overrides)
+	require.NoError(t, err)
+	assert.EqualError(t, c.ValidateConfig(log), `invalid log_level: not a valid logrus Level: "loud"`)
+}
@@ -4,54 +4,208 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spiffe/spiffe-helper/cmd/spiffe-helper/config"
+	"github.com/spiffe/spiffe-helper/pkg/audit"
 	"github.com/spiffe/spiffe-helper/pkg/health"
 	"github.com/spiffe/spiffe-helper/pkg/sidecar"
+	"github.com/spiffe/spiffe-helper/pkg/systemd"
 	"github.com/spiffe/spiffe-helper/pkg/util"
 )
 
 const (
-	daemonModeFlagName = "daemon-mode"
+	defaultConfigFile = "helper.conf"
+
+	// How long buffered spans are given to be exported on exit
+	tracerShutdownTimeout = 5 * time.Second
+)
+
+// Subcommands of spiffe-helper
+const (
+	runCommand = "run"
 )
 
+const usage = `Usage: spiffe-helper [command] [flags]
+
+Commands:
+  run               Run the helper, the default without a command
+  validate          Validate the configuration and report all its problems
+  print-config      Print the effective configuration, with its defaults
+  verify-audit-log  Verify the hash chain of audit logs
+
+Run 'spiffe-helper <command> -help' for the flags of a command.
+`
+
 func main() {
-	configFile := flag.String("config", "helper.conf", "<configFile> Configuration file path")
-	daemonModeFlag := flag.Bool(daemonModeFlagName, true, "Toggle running as a daemon to rotate X.509/JWT or just fetch and exit")
-	flag.Parse()
-	log := logrus.WithField("system", "spiffe-helper")
+	command, args := runCommand, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case runCommand:
+		os.Exit(run(args, os.Stderr))
+	case validateCommand:
+		os.Exit(validate(args, os.Stdout, os.Stderr))
+	case printConfigCommand:
+		os.Exit(printConfig(args, os.Stdout, os.Stderr))
+	case verifyAuditLogCommand:
+		os.Exit(verifyAuditLog(args, os.Stdout, os.Stderr))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// configFlags selects the configuration file and registers the flags
+// overriding it, for the commands loading the configuration
+type configFlags struct {
+	flags     *flag.FlagSet
+	file      *string
+	format    *string
+	overrides *config.Overrides
+}
+
+func newConfigFlags(flags *flag.FlagSet) *configFlags {
+	return &configFlags{
+		flags:     flags,
+		file:      flags.String("config", defaultConfigFile, "<configFile> Configuration file path"),
+		format:    flags.String("config-format", "", "<format> Format of the configuration file, one of hcl, json or yaml. Detected from the file extension if not set"),
+		overrides: config.RegisterOverrideFlags(flags),
+	}
+}
+
+// configFile returns the configuration file to load. Without -config, a
+// missing default file runs the helper with the flags and environment
+// variables only, and the returned file is empty.
+func (f *configFlags) configFile() string {
+	if !isFlagPassed(f.flags, "config") {
+		if _, err := os.Stat(*f.file); errors.Is(err, fs.ErrNotExist) {
+			return ""
+		}
+	}
+	return *f.file
+}
+
+func (f *configFlags) parse(configFile string) (*config.Config, error) {
+	return config.ParseConfig(configFile, *f.format, f.overrides)
+}
+
+// run implements 'spiffe-helper [run]', which runs the helper until it is
+// interrupted, or until the credentials are fetched outside of daemon_mode
+func run(args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("spiffe-helper", flag.ExitOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "%s\nFlags of run:\n", usage)
+		flags.PrintDefaults()
+	}
+	configFlags := newConfigFlags(flags)
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		fmt.Fprintf(stderr, "Unexpected argument %q\n\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+	logger := logrus.New()
+	log := logger.WithField("system", "spiffe-helper")
 
-	log.Infof("Using configuration file: %q", *configFile)
-	hclConfig, err := config.ParseConfig(*configFile, *daemonModeFlag, daemonModeFlagName)
+	configFile := configFlags.configFile()
+	if configFile != "" {
+		log.Infof("Using configuration file: %q", configFile)
+	} else {
+		log.Info("No configuration file, using flags and environment variables")
+	}
+	hclConfig, err := configFlags.parse(configFile)
 	if err != nil {
 		log.WithError(err).Errorf("failed to parse configuration")
-		os.Exit(1)
+		return 1
 	}
 
 	if err := hclConfig.ValidateConfig(log); err != nil {
 		log.WithError(err).Errorf("invalid configuration")
-		os.Exit(1)
+		return 1
 	}
+	hclConfig.ConfigureLogger(logger)
 
-	if err = startSidecar(hclConfig, log); err != nil {
+	reloader := &configReloader{
+		configFile: configFile,
+		load: func() (*config.Config, error) {
+			hclConfig, err := configFlags.parse(configFile)
+			if err != nil {
+				return nil, err
+			}
+			if err := hclConfig.ValidateConfig(log); err != nil {
+				return nil, err
+			}
+			return hclConfig, nil
+		},
+		current: hclConfig,
+		logger:  logger,
+		log:     log,
+	}
+	if err = startSidecar(hclConfig, log, reloader); err != nil {
 		log.WithError(err).Errorf("Error starting spiffe-helper")
-		os.Exit(1)
+		return 1
 	}
 
 	log.Infof("Exiting")
-	os.Exit(0)
+	return 0
 }
 
-func startSidecar(hclConfig *config.Config, log logrus.FieldLogger) error {
-	sidecarConfig := config.NewSidecarConfig(hclConfig, log)
-	spiffeSidecar := sidecar.New(sidecarConfig)
+func startSidecar(hclConfig *config.Config, log logrus.FieldLogger, reloader *configReloader) error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	tracerProvider, err := hclConfig.NewTracerProvider(ctx)
+	if err != nil {
+		return err
+	}
+	var auditLog *audit.Log
+	if hclConfig.AuditLogFile != "" {
+		auditLog, err = audit.Open(hclConfig.AuditLogFile, fs.FileMode(hclConfig.AuditLogFileMode))
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+	}
+
+	// Tracing and the audit log can't be reloaded, so reloaded configurations
+	// keep using them
+	reloader.newSidecarConfig = func(hclConfig *config.Config) *sidecar.Config {
+		sidecarConfig := config.NewSidecarConfig(hclConfig, log)
+		if tracerProvider != nil {
+			sidecarConfig.TracerProvider = tracerProvider
+		}
+		sidecarConfig.AuditLog = auditLog
+		return sidecarConfig
+	}
+	sidecarConfig := reloader.newSidecarConfig(hclConfig)
+	// The sidecar has stopped 'cmd' by the time this runs
+	defer closeCmdOutput(sidecarConfig, log)
+
+	if tracerProvider != nil {
+		log.Infof("Exporting traces to %s", hclConfig.TracingOTLPEndpoint)
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+			defer cancel()
+			if err := tracerProvider.Shutdown(shutdownCtx); err != nil {
+				log.WithError(err).Warn("Unable to flush traces")
+			}
+		}()
+	}
+	spiffeSidecar := sidecar.New(sidecarConfig)
+	reloader.sidecar = spiffeSidecar
+
 	if !*hclConfig.DaemonMode {
 		log.Info("Daemon mode disabled")
 		return spiffeSidecar.Run(ctx)
@@ -60,17 +214,52 @@ func startSidecar(hclConfig *config.Config, log logrus.FieldLogger) error {
 	log.Info("Launching daemon")
 	tasks := []func(context.Context) error{
 		spiffeSidecar.RunDaemon,
+		reloader.Run,
 	}
 
-	if hclConfig.HealthCheck.ListenerEnabled {
+	if hclConfig.HealthCheck.ListenerEnabled || hclConfig.HealthCheck.GRPCListenerEnabled {
 		healthServer := health.New(&hclConfig.HealthCheck, log, spiffeSidecar)
+		listeners, err := systemd.Listeners()
+		if err != nil {
+			return err
+		}
+		if len(listeners) > 0 {
+			healthServer.UseListeners(listeners)
+		}
 		tasks = append(tasks, healthServer.Start)
 	}
 
-	err := util.RunTasks(ctx, tasks...)
+	if systemd.NotifySocketSet() {
+		log.Info("Reporting status to systemd")
+		tasks = append(tasks, systemd.NewNotifier(log, spiffeSidecar).Run)
+	}
+
+	err = util.RunTasks(ctx, tasks...)
 	if errors.Is(err, context.Canceled) {
 		return nil
 	}
 
 	return err
 }
+
+// closeCmdOutput closes the files the output of 'cmd' was written to
+func closeCmdOutput(sidecarConfig *sidecar.Config, log logrus.FieldLogger) {
+	for _, w := range []io.Writer{sidecarConfig.CmdStdout, sidecarConfig.CmdStderr} {
+		if closer, ok := w.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.WithError(err).Warn("Unable to close the output file of cmd")
+			}
+		}
+	}
+}
+
+func isFlagPassed(flags *flag.FlagSet, name string) bool {
+	var found bool
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+
+	return found
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/spiffe-helper/cmd/spiffe-helper/config"
+	"github.com/spiffe/spiffe-helper/pkg/sidecar"
+)
+
+// How often the configuration file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// configReloader applies the configuration file to the running sidecar when
+// the helper receives SIGHUP or the file changes. A configuration that is
+// invalid or can't be applied is logged and the helper carries on with the
+// one it is running with. Without a configuration file, the flags and
+// environment variables are only reloaded on SIGHUP.
+type configReloader struct {
+	configFile string
+	// load parses and validates the configuration file
+	load func() (*config.Config, error)
+	// newSidecarConfig converts a validated configuration
+	newSidecarConfig func(*config.Config) *sidecar.Config
+
+	current *config.Config
+	sidecar *sidecar.Sidecar
+	logger  *logrus.Logger
+	log     logrus.FieldLogger
+}
+
+func (r *configReloader) Run(ctx context.Context) error {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	// There is no file to poll without one
+	var polls <-chan time.Time
+	var content []byte
+	if r.configFile != "" {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		polls = ticker.C
+		content, _ = os.ReadFile(r.configFile)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hangups:
+			if r.configFile != "" {
+				content, _ = os.ReadFile(r.configFile)
+			}
+			r.reload(ctx, "SIGHUP received")
+		case <-polls:
+			// The file may briefly be missing while it is replaced
+			newContent, err := os.ReadFile(r.configFile)
+			if err != nil || bytes.Equal(content, newContent) {
+				continue
+			}
+			content = newContent
+			r.reload(ctx, "Configuration file changed")
+		}
+	}
+}
+
+func (r *configReloader) reload(ctx context.Context, reason string) {
+	log := r.log
+	if r.configFile != "" {
+		log = log.WithField(sidecar.LogFieldFile, r.configFile)
+	}
+	log.Infof("%s, reloading the configuration", reason)
+
+	newConfig, err := r.load()
+	if err != nil {
+		log.WithError(err).Error("Invalid configuration, keeping the current one")
+		return
+	}
+	if err := r.current.CheckReload(newConfig); err != nil {
+		log.WithError(err).Error("Unable to reload the configuration, keeping the current one")
+		return
+	}
+	if err := r.sidecar.Reload(ctx, r.newSidecarConfig(newConfig)); err != nil {
+		log.WithError(err).Error("Unable to apply the configuration, keeping the current one")
+		return
+	}
+
+	newConfig.ReconfigureLogger(r.logger)
+	r.current = newConfig
+}
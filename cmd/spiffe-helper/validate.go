@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/spiffe-helper/cmd/spiffe-helper/config"
+)
+
+const (
+	validateCommand    = "validate"
+	printConfigCommand = "print-config"
+)
+
+// validate implements 'spiffe-helper validate [flags]', which reports every
+// problem of the configuration, with its position in the configuration
+// file, and returns the exit status
+func validate(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags(validateCommand, stderr)
+	configFlags := newConfigFlags(flags)
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	source, hclConfig := loadValidConfig(configFlags, stderr)
+	if hclConfig == nil {
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s: configuration is valid\n", source)
+	return 0
+}
+
+// printConfig implements 'spiffe-helper print-config [flags]', which prints
+// the configuration the helper would run with, defaults included
+func printConfig(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags(printConfigCommand, stderr)
+	format := flags.String("format", config.FormatHCL, "<format> Output format, one of hcl or json")
+	configFlags := newConfigFlags(flags)
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+	if *format != config.FormatHCL && *format != config.FormatJSON {
+		fmt.Fprintf(stderr, "unknown output format %q, must be one of: %s,%s\n", *format, config.FormatHCL, config.FormatJSON)
+		return 2
+	}
+
+	_, hclConfig := loadValidConfig(configFlags, stderr)
+	if hclConfig == nil {
+		return 1
+	}
+	data, err := hclConfig.Marshal(*format)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	_, _ = stdout.Write(data)
+	return 0
+}
+
+func newCommandFlags(command string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("spiffe-helper "+command, flag.ExitOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: spiffe-helper %s [flags]\n\nFlags:\n", command)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// loadValidConfig parses and validates the configuration, reporting its
+// problems on stderr prefixed with their source. The configuration is nil
+// if it has any.
+func loadValidConfig(configFlags *configFlags, stderr io.Writer) (string, *config.Config) {
+	configFile := configFlags.configFile()
+	source := configFile
+	if source == "" {
+		source = "flags and environment variables"
+	}
+
+	hclConfig, err := configFlags.parse(configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", source, err)
+		return source, nil
+	}
+
+	// Warnings are reported along with the problems
+	logger := logrus.New()
+	logger.SetOutput(stderr)
+	problems := hclConfig.Validate(logger)
+	for _, problem := range problems {
+		fmt.Fprintf(stderr, "%s: %v\n", source, problem)
+	}
+	if len(problems) != 0 {
+		fmt.Fprintf(stderr, "%s: %d problem(s) found\n", source, len(problems))
+		return source, nil
+	}
+
+	return source, hclConfig
+}
@@ -0,0 +1,308 @@
+// Package audit provides an append-only, hash-chained log of the credentials
+// received and written by the helper and of the notifications they caused.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Types of the events recorded in the audit log
+const (
+	EventX509SVIDWritten   = "x509_svid_written"
+	EventJWTSVIDWritten    = "jwt_svid_written"
+	EventJWTBundleWritten  = "jwt_bundle_written"
+	EventWriteFailed       = "write_failed"
+	EventNotificationsSent = "notifications_sent"
+)
+
+// Entry is a line of the audit log. Hash is the SHA-256 of the line as
+// written without its hash, so each entry covers the hash of the previous
+// one and no entry can be changed, removed or reordered without breaking the
+// chain. Lines must be in the encoding Append writes, so nothing can be added
+// to an entry without breaking the chain either.
+type Entry struct {
+	Time              time.Time            `json:"time"`
+	Event             string               `json:"event"`
+	CredentialTypes   []string             `json:"credential_types,omitempty"`
+	SPIFFEID          string               `json:"spiffe_id,omitempty"`
+	SerialNumber      string               `json:"serial_number,omitempty"`
+	SHA256Fingerprint string               `json:"sha256_fingerprint,omitempty"`
+	Expiry            *time.Time           `json:"expiry,omitempty"`
+	TrustDomains      []string             `json:"trust_domains,omitempty"`
+	Files             []string             `json:"files,omitempty"`
+	Notifications     []NotificationResult `json:"notifications,omitempty"`
+	Error             string               `json:"error,omitempty"`
+	PrevHash          string               `json:"prev_hash,omitempty"`
+	Hash              string               `json:"hash,omitempty"`
+}
+
+// NotificationResult is the outcome of notifying a process of rotated
+// credentials
+type NotificationResult struct {
+	Target string `json:"target"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Head records the number of entries of an audit log and the hash of the
+// last one. It is kept in a file next to the log, see HeadFilename, so that
+// removing entries from the end of the log is detected too.
+type Head struct {
+	Entries int    `json:"entries"`
+	Hash    string `json:"hash,omitempty"`
+}
+
+// Log appends entries to an audit log file. Log is safe for concurrent use.
+type Log struct {
+	mu       sync.Mutex
+	file     *os.File
+	headFile string
+	mode     fs.FileMode
+	head     Head
+}
+
+// HeadFilename returns the name of the file holding the head of the audit
+// log at filename
+func HeadFilename(filename string) string {
+	return filename + ".head"
+}
+
+// Open opens the audit log at filename for appending, creating it with the
+// given mode if it doesn't exist. New entries are chained to the last entry
+// already in the file, which must be the one recorded in its head file.
+func Open(filename string, mode fs.FileMode) (*Log, error) {
+	head, err := readLastEntry(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHead(filename, head); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %q: %w", filename, err)
+	}
+
+	return &Log{
+		file:     file,
+		headFile: HeadFilename(filename),
+		mode:     mode,
+		head:     head,
+	}, nil
+}
+
+// Append chains the entry to the previous one and writes it to the log. The
+// time of the entry defaults to now.
+func (l *Log) Append(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+	if entry.Expiry != nil {
+		expiry := entry.Expiry.UTC()
+		entry.Expiry = &expiry
+	}
+	entry.PrevHash = l.head.Hash
+	line, err := entry.line()
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+
+	l.head.Entries++
+	l.head.Hash = entry.Hash
+	return writeHead(l.headFile, l.head, l.mode)
+}
+
+// Close closes the audit log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// Verify reads an audit log and checks that every entry is in the encoding
+// Append writes, matches its hash and is chained to the previous one. It
+// returns the number of entries verified.
+func Verify(r io.Reader) (int, error) {
+	head, err := verify(r)
+	return head.Entries, err
+}
+
+// VerifyFile verifies the audit log at filename, see Verify, and that it
+// ends with the entry recorded in its head file
+func VerifyFile(filename string) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	head, err := verify(file)
+	if err != nil {
+		return head.Entries, err
+	}
+	return head.Entries, checkHead(filename, head)
+}
+
+func verify(r io.Reader) (Head, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	var head Head
+	for line := 1; scanner.Scan(); line++ {
+		entry, err := parseLine(scanner.Bytes())
+		if err != nil {
+			return head, fmt.Errorf("line %d: %w", line, err)
+		}
+		if entry.PrevHash != head.Hash {
+			return head, fmt.Errorf("line %d: entry is not chained to the previous one", line)
+		}
+
+		head.Hash = entry.Hash
+		head.Entries++
+	}
+	return head, scanner.Err()
+}
+
+// line sets the hash of the entry and returns it encoded as a line of the
+// log, without the newline. The hash covers the line without the hash,
+// which is the last field.
+func (e *Entry) line() ([]byte, error) {
+	e.Hash = ""
+	unhashed, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(unhashed)
+	e.Hash = hex.EncodeToString(sum[:])
+	return json.Marshal(e)
+}
+
+// parseLine parses a line of the log, checking that it is in the encoding
+// Append writes and matches its hash
+func parseLine(line []byte) (*Entry, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+	var entry Entry
+	if err := decoder.Decode(&entry); err != nil {
+		return nil, fmt.Errorf("invalid entry: %w", err)
+	}
+
+	hash := entry.Hash
+	encoded, err := entry.line()
+	if err != nil {
+		return nil, err
+	}
+	if entry.Hash != hash {
+		return nil, errors.New("entry does not match its hash")
+	}
+	if !bytes.Equal(encoded, line) {
+		return nil, errors.New("entry is not in the encoding of the audit log")
+	}
+	return &entry, nil
+}
+
+// readLastEntry returns the number of entries of the audit log at filename
+// and the hash of the last one. The log is empty if it doesn't exist.
+func readLastEntry(filename string) (Head, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Head{}, nil
+	}
+	if err != nil {
+		return Head{}, fmt.Errorf("failed to read audit log %q: %w", filename, err)
+	}
+
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return Head{}, nil
+	}
+	last := data[bytes.LastIndexByte(data, '\n')+1:]
+
+	var entry Entry
+	if err := json.Unmarshal(last, &entry); err != nil || entry.Hash == "" {
+		return Head{}, fmt.Errorf("failed to read the last entry of audit log %q, it may be truncated", filename)
+	}
+	return Head{Entries: bytes.Count(data, []byte{'\n'}) + 1, Hash: entry.Hash}, nil
+}
+
+// checkHead checks that the audit log at filename, whose last entry is
+// described by head, ends with the entry recorded in its head file
+func checkHead(filename string, head Head) error {
+	headFile := HeadFilename(filename)
+	data, err := os.ReadFile(headFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		if head.Entries == 0 {
+			return nil
+		}
+		return fmt.Errorf("head file %q of audit log %q is missing", headFile, filename)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read head file of audit log %q: %w", filename, err)
+	}
+
+	var recorded Head
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return fmt.Errorf("invalid head file %q: %w", headFile, err)
+	}
+	if recorded != head {
+		return fmt.Errorf("audit log %q has %d entries but %d are recorded in %q, it may be truncated",
+			filename, head.Entries, recorded.Entries, headFile)
+	}
+	return nil
+}
+
+// writeHead replaces the head file with the given head, such that it is
+// never seen partially written
+func writeHead(headFile string, head Head, mode fs.FileMode) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(headFile), filepath.Base(headFile)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write audit log head: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), headFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write audit log head: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package audit
+
+import (
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	filename := path.Join(t.TempDir(), "audit.log")
+
+	log, err := Open(filename, 0600)
+	require.NoError(t, err)
+
+	expiry := time.Now().Add(time.Hour)
+	require.NoError(t, log.Append(Entry{
+		Event:             EventX509SVIDWritten,
+		SPIFFEID:          "spiffe://example.test/workload",
+		SerialNumber:      "1",
+		SHA256Fingerprint: "abcd",
+		Expiry:            &expiry,
+		Files:             []string{"svid.pem", "svid_key.pem", "svid_bundle.pem"},
+	}))
+	require.NoError(t, log.Append(Entry{
+		Event:           EventNotificationsSent,
+		CredentialTypes: []string{"x509"},
+		Notifications:   []NotificationResult{{Target: "cmd", Result: "signalled"}},
+	}))
+	require.NoError(t, log.Close())
+
+	for _, file := range []string{filename, HeadFilename(filename)} {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		if !onWindows() {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+	}
+
+	// Reopening the log continues the chain
+	log, err = Open(filename, 0600)
+	require.NoError(t, err)
+	require.NoError(t, log.Append(Entry{Event: EventWriteFailed, Files: []string{"jwt.token"}, Error: "disk full"}))
+	require.NoError(t, log.Close())
+
+	count, err := VerifyFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	lines := readLines(t, filename)
+	assert.NotContains(t, lines[0], "prev_hash")
+	assert.Contains(t, lines[0], `"event":"x509_svid_written"`)
+	assert.Contains(t, lines[1], `"notifications":[{"target":"cmd","result":"signalled"}]`)
+}
+
+func TestVerify(t *testing.T) {
+	filename := path.Join(t.TempDir(), "audit.log")
+	log, err := Open(filename, 0600)
+	require.NoError(t, err)
+	for _, spiffeID := range []string{"spiffe://example.test/a", "spiffe://example.test/b", "spiffe://example.test/c"} {
+		require.NoError(t, log.Append(Entry{Event: EventX509SVIDWritten, SPIFFEID: spiffeID}))
+	}
+	require.NoError(t, log.Close())
+	lines := readLines(t, filename)
+
+	for _, tt := range []struct {
+		name        string
+		lines       []string
+		expectCount int
+		expectError string
+	}{
+		{
+			name:        "intact",
+			lines:       lines,
+			expectCount: 3,
+		},
+		{
+			name:        "empty",
+			expectCount: 0,
+		},
+		{
+			name:        "modified entry",
+			lines:       []string{lines[0], strings.Replace(lines[1], "example.test/b", "example.test/x", 1), lines[2]},
+			expectCount: 1,
+			expectError: "line 2: entry does not match its hash",
+		},
+		{
+			name:        "injected field",
+			lines:       []string{lines[0], strings.Replace(lines[1], `"event":`, `"note":"x","event":`, 1), lines[2]},
+			expectCount: 1,
+			expectError: `line 2: invalid entry: json: unknown field "note"`,
+		},
+		{
+			name:        "duplicate field",
+			lines:       []string{lines[0], strings.Replace(lines[1], `"event":`, `"event":"write_failed","event":`, 1), lines[2]},
+			expectCount: 1,
+			expectError: "line 2: entry is not in the encoding of the audit log",
+		},
+		{
+			name:        "removed entry",
+			lines:       []string{lines[0], lines[2]},
+			expectCount: 1,
+			expectError: "line 2: entry is not chained to the previous one",
+		},
+		{
+			name:        "reordered entries",
+			lines:       []string{lines[0], lines[2], lines[1]},
+			expectCount: 1,
+			expectError: "line 2: entry is not chained to the previous one",
+		},
+		{
+			name:        "removed first entry",
+			lines:       lines[1:],
+			expectCount: 0,
+			expectError: "line 1: entry is not chained to the previous one",
+		},
+		{
+			name:        "garbage",
+			lines:       []string{lines[0], "not json"},
+			expectCount: 1,
+			expectError: "line 2: invalid entry: invalid character 'o' in literal null (expecting 'u')",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			count, err := Verify(strings.NewReader(strings.Join(tt.lines, "\n")))
+			assert.Equal(t, tt.expectCount, count)
+			if tt.expectError != "" {
+				require.EqualError(t, err, tt.expectError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestVerifyFile_TruncatedTail(t *testing.T) {
+	filename := path.Join(t.TempDir(), "audit.log")
+	log, err := Open(filename, 0600)
+	require.NoError(t, err)
+	for _, spiffeID := range []string{"spiffe://example.test/a", "spiffe://example.test/b", "spiffe://example.test/c"} {
+		require.NoError(t, log.Append(Entry{Event: EventX509SVIDWritten, SPIFFEID: spiffeID}))
+	}
+	require.NoError(t, log.Close())
+	lines := readLines(t, filename)
+
+	// The remaining entries are still chained, but the head file records
+	// the one removed
+	require.NoError(t, os.WriteFile(filename, []byte(strings.Join(lines[:2], "\n")+"\n"), 0600))
+	expectError := `audit log "` + filename + `" has 2 entries but 3 are recorded in "` + HeadFilename(filename) + `", it may be truncated`
+	count, err := VerifyFile(filename)
+	assert.Equal(t, 2, count)
+	require.EqualError(t, err, expectError)
+	_, err = Open(filename, 0600)
+	require.EqualError(t, err, expectError)
+
+	// As is removing the head file along with the entries
+	require.NoError(t, os.Remove(HeadFilename(filename)))
+	_, err = VerifyFile(filename)
+	require.EqualError(t, err, `head file "`+HeadFilename(filename)+`" of audit log "`+filename+`" is missing`)
+}
+
+func TestOpen_Truncated(t *testing.T) {
+	filename := path.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"time":"2025-03-01T10:15:02Z","event":"x509_svid_wr`), 0600))
+
+	_, err := Open(filename, 0600)
+	require.EqualError(t, err, `failed to read the last entry of audit log "`+filename+`", it may be truncated`)
+}
+
+func TestLog_Concurrent(t *testing.T) {
+	filename := path.Join(t.TempDir(), "audit.log")
+	log, err := Open(filename, 0600)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, log.Append(Entry{Event: EventJWTSVIDWritten}))
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, log.Close())
+
+	count, err := VerifyFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 10, count)
+}
+
+func readLines(t *testing.T, filename string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func onWindows() bool {
+	return runtime.GOOS == "windows"
+}
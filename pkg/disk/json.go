@@ -37,7 +37,7 @@ func WriteJWTBundleSet(jwkSet *jwtbundle.Set, dir string, jwtBundleFilename stri
 func WriteJWTSVID(jwtSVIDs []*jwtsvid.SVID, dir, jwtSVIDFilename string, jwtSVIDFileMode fs.FileMode, hint string) error {
 	filePath := path.Join(dir, jwtSVIDFilename)
 
-	jwtSVID, err := getJWTSVID(jwtSVIDs, hint)
+	jwtSVID, err := GetJWTSVID(jwtSVIDs, hint)
 	if err != nil {
 		return err
 	}
@@ -56,9 +56,9 @@ func writeJSON(certs map[string]any, dir, filename string, fileMode fs.FileMode)
 	return os.WriteFile(filePath, file, fileMode)
 }
 
-// getJWTSVID extracts the JWT SVID that matches the hint or returns the default
+// GetJWTSVID extracts the JWT SVID that matches the hint or returns the default
 // if hint is empty
-func getJWTSVID(jwtSVIDs []*jwtsvid.SVID, hint string) (*jwtsvid.SVID, error) {
+func GetJWTSVID(jwtSVIDs []*jwtsvid.SVID, hint string) (*jwtsvid.SVID, error) {
 	if hint == "" {
 		return jwtSVIDs[0], nil
 	}
@@ -16,12 +16,13 @@ import (
 // the Workload API, and calls writeCerts and writeKey to write to disk
 // the svid, key and bundle of certificates.
 // It is possible to change output setting `addIntermediatesToBundle` as true.
-func WriteX509Context(x509Context *workloadapi.X509Context, addIntermediatesToBundle, includeFederatedDomains bool, certDir, svidFilename, svidKeyFilename, svidBundleFilename string, certFileMode, keyFileMode fs.FileMode, hint string) error {
+// The SVID written is selected with GetX509SVID.
+func WriteX509Context(x509Context *workloadapi.X509Context, addIntermediatesToBundle, includeFederatedDomains bool, certDir, svidFilename, svidKeyFilename, svidBundleFilename string, certFileMode, keyFileMode fs.FileMode, hint, spiffeID string) error {
 	svidFile := path.Join(certDir, svidFilename)
 	svidKeyFile := path.Join(certDir, svidKeyFilename)
 	svidBundleFile := path.Join(certDir, svidBundleFilename)
 
-	svid, err := getX509SVID(x509Context, hint)
+	svid, err := GetX509SVID(x509Context, hint, spiffeID)
 	if err != nil {
 		return err
 	}
@@ -94,9 +95,18 @@ func writeKey(file string, data []byte, keyFileMode fs.FileMode) error {
 	return os.WriteFile(file, pem.EncodeToMemory(b), keyFileMode)
 }
 
-// getX509SVID extracts the x509 SVID that matches the hint or returns the default
-// if hint is empty
-func getX509SVID(x509Context *workloadapi.X509Context, hint string) (*x509svid.SVID, error) {
+// GetX509SVID extracts the x509 SVID that has the SPIFFE ID, if not empty, or
+// that matches the hint, or returns the default if both are empty
+func GetX509SVID(x509Context *workloadapi.X509Context, hint, spiffeID string) (*x509svid.SVID, error) {
+	if spiffeID != "" {
+		for _, svid := range x509Context.SVIDs {
+			if svid.ID.String() == spiffeID {
+				return svid, nil
+			}
+		}
+		return nil, fmt.Errorf("failed to find the x509 SVID of %q", spiffeID)
+	}
+
 	if hint == "" {
 		return x509Context.DefaultSVID(), nil
 	}
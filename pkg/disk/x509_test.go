@@ -150,7 +150,7 @@ func TestWriteX509Context(t *testing.T) {
 					}
 				}
 
-				err = WriteX509Context(x509Context, test.intermediateInBundle, test.includeFederatedDomains, tempDir, svidFilename, svidKeyFilename, svidBundleFilename, certFileMode, keyFileMode, hint)
+				err = WriteX509Context(x509Context, test.intermediateInBundle, test.includeFederatedDomains, tempDir, svidFilename, svidKeyFilename, svidBundleFilename, certFileMode, keyFileMode, hint, "")
 				require.NoError(t, err)
 
 				// Load certificates from disk and validate it is expected
@@ -171,3 +171,38 @@ func TestWriteX509Context(t *testing.T) {
 		}
 	}
 }
+
+func TestGetX509SVID(t *testing.T) {
+	ca := spiffetest.NewCA(t)
+	newSVID := func(id, hint string) *x509svid.SVID {
+		certs, key := ca.CreateX509SVID(id)
+		return &x509svid.SVID{ID: spiffeid.RequireFromString(id), Certificates: certs, PrivateKey: key, Hint: hint}
+	}
+	defaultSVID := newSVID("spiffe://example.test/default", "internal")
+	dbSVID := newSVID("spiffe://example.test/db", "db")
+	x509Context := &workloadapi.X509Context{SVIDs: []*x509svid.SVID{defaultSVID, dbSVID}}
+
+	for _, tt := range []struct {
+		name     string
+		hint     string
+		spiffeID string
+		expected *x509svid.SVID
+		err      string
+	}{
+		{name: "default", expected: defaultSVID},
+		{name: "hint", hint: "db", expected: dbSVID},
+		{name: "SPIFFE ID", spiffeID: "spiffe://example.test/db", expected: dbSVID},
+		{name: "unknown hint", hint: "web", err: "failed to find the hinted x509 SVID"},
+		{name: "unknown SPIFFE ID", spiffeID: "spiffe://example.test/web", err: `failed to find the x509 SVID of "spiffe://example.test/web"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			svid, err := GetX509SVID(x509Context, tt.hint, tt.spiffeID)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Same(t, tt.expected, svid)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/spiffe/spiffe-helper/pkg/sidecar"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Names of the services reported by the gRPC health service. The empty
+// service name reports the overall health of the helper.
+const (
+	GRPCServiceX509           = "x509"
+	GRPCServiceX509SVIDPrefix = "x509-svid:"
+	GRPCServiceJWTBundle      = "jwt-bundle"
+	GRPCServiceJWTSVIDPrefix  = "jwt-svid:"
+)
+
+// How often the gRPC health service checks the sidecar for status changes
+// that happen without a health update, e.g. as time passes
+const grpcPollInterval = time.Second
+
+// serveGRPC serves the grpc.health.v1.Health service, with a service for
+// each kind of credential and JWT SVID file
+func (h *Health) serveGRPC(ctx context.Context) error {
+	h.log.Info("Starting gRPC health server")
+
+	var opts []grpc.ServerOption
+	if h.c.TLSEnabled {
+		tlsConfig, err := h.tlsConfig()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	// Subscribe before setting the initial statuses so no update is missed
+	changes, unsubscribe := h.sidecar.SubscribeHealth()
+	defer unsubscribe()
+	services := h.updateGRPCStatuses(healthServer, nil)
+
+	listener := h.grpcListener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", net.JoinHostPort(h.c.BindAddress, strconv.Itoa(h.c.GRPCBindPort)))
+		if err != nil {
+			return fmt.Errorf("unable to listen for gRPC health checks: %w", err)
+		}
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			h.log.WithError(err).Warn("Error serving gRPC health checks")
+		}
+	}()
+
+	ticker := time.NewTicker(grpcPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Let watchers know the services are going away. Watch streams
+			// never end by themselves, so the server isn't stopped gracefully.
+			healthServer.Shutdown()
+			server.Stop()
+			return ctx.Err()
+		case <-ticker.C:
+			services = h.updateGRPCStatuses(healthServer, services)
+		case <-changes:
+			services = h.updateGRPCStatuses(healthServer, services)
+		}
+	}
+}
+
+// updateGRPCStatuses sets the status of every service, and returns them.
+// The previous services that no longer exist, such as the outputs removed by
+// a reload, become unknown. Watchers are only notified of changes.
+func (h *Health) updateGRPCStatuses(healthServer *grpchealth.Server, previous []string) []string {
+	statuses := grpcServingStatuses(h.sidecar)
+	for _, service := range previous {
+		if _, ok := statuses[service]; !ok {
+			healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVICE_UNKNOWN)
+		}
+	}
+
+	services := make([]string, 0, len(statuses))
+	for service, status := range statuses {
+		healthServer.SetServingStatus(service, status)
+		services = append(services, service)
+	}
+	return services
+}
+
+// grpcServingStatuses maps the write status of each output to the status of
+// its service. The overall status is serving while the helper is both live
+// and ready.
+func grpcServingStatuses(s *sidecar.Sidecar) map[string]healthpb.HealthCheckResponse_ServingStatus {
+	statuses := make(map[string]healthpb.HealthCheckResponse_ServingStatus)
+	status := s.Status()
+	if status.X509SVID != nil {
+		statuses[GRPCServiceX509] = servingStatus(status.X509SVID.OutputStatus)
+	}
+	for _, x509SVID := range status.X509SVIDs {
+		statuses[GRPCServiceX509SVIDPrefix+x509SVID.File] = servingStatus(x509SVID.OutputStatus)
+	}
+	if status.JWTBundle != nil {
+		statuses[GRPCServiceJWTBundle] = servingStatus(status.JWTBundle.OutputStatus)
+	}
+	for _, jwtSVID := range status.JWTSVIDs {
+		statuses[GRPCServiceJWTSVIDPrefix+jwtSVID.File] = servingStatus(jwtSVID.OutputStatus)
+	}
+
+	statuses[""] = healthpb.HealthCheckResponse_NOT_SERVING
+	if s.CheckLiveness() && s.CheckReadiness() {
+		statuses[""] = healthpb.HealthCheckResponse_SERVING
+	}
+	return statuses
+}
+
+// servingStatus is serving once an output has been written, until writing it
+// fails
+func servingStatus(output sidecar.OutputStatus) healthpb.HealthCheckResponse_ServingStatus {
+	if output.LastWrite == nil || output.LastError != "" {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
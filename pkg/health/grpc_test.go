@@ -0,0 +1,186 @@
+package health
+
+import (
+	"context"
+	"net"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spiffe/spiffe-helper/pkg/sidecar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestServingStatus(t *testing.T) {
+	written := time.Now()
+	for _, tc := range []struct {
+		name     string
+		output   sidecar.OutputStatus
+		expected healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{
+			name:     "unwritten",
+			expected: healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			name:     "written",
+			output:   sidecar.OutputStatus{LastWrite: &written},
+			expected: healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			name:     "failed",
+			output:   sidecar.OutputStatus{LastWrite: &written, LastError: "disk full"},
+			expected: healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, servingStatus(tc.output))
+		})
+	}
+}
+
+func TestHealth_GRPC(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	log, _ := test.NewNullLogger()
+	certDir := t.TempDir()
+	s := sidecar.New(&sidecar.Config{
+		Log:               log,
+		CertDir:           certDir,
+		JWTBundleFilename: "jwt_bundle.json",
+		JWTSVIDs: []sidecar.JWTConfig{
+			{JWTAudience: "aud", JWTSVIDFilename: "jwt.token"},
+		},
+		X509SVIDs: []sidecar.X509SVIDConfig{
+			{SVIDFilename: "db.pem", SVIDKeyFilename: "db_key.pem", SVIDBundleFilename: "db_bundle.pem"},
+		},
+	})
+
+	h := New(&Config{
+		GRPCListenerEnabled: true,
+		BindAddress:         "127.0.0.1",
+		GRPCBindPort:        freePort(t),
+	}, log, s)
+
+	errCh := make(chan error, 1)
+	serverCtx, stopServer := context.WithCancel(ctx)
+	go func() {
+		errCh <- h.Start(serverCtx)
+	}()
+
+	conn, err := grpc.NewClient(net.JoinHostPort("127.0.0.1", strconv.Itoa(h.c.GRPCBindPort)), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	var resp *healthpb.HealthCheckResponse
+	require.Eventually(t, func() bool {
+		resp, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	// Every output has its own service
+	for _, service := range []string{
+		GRPCServiceJWTBundle,
+		GRPCServiceJWTSVIDPrefix + path.Join(certDir, "jwt.token"),
+		GRPCServiceX509SVIDPrefix + path.Join(certDir, "db.pem"),
+	} {
+		resp, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err, service)
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status, service)
+	}
+
+	// The top level X.509 SVID isn't configured
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: GRPCServiceX509})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Watch streams the current status straight away
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: GRPCServiceJWTBundle})
+	require.NoError(t, err)
+	resp, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	stopServer()
+	require.ErrorIs(t, <-errCh, context.Canceled)
+}
+
+// Services of the outputs removed by a reload become unknown rather than
+// keeping their last status
+func TestUpdateGRPCStatuses_Reload(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	log, _ := test.NewNullLogger()
+	certDir := t.TempDir()
+	s := sidecar.New(&sidecar.Config{
+		Log:      log,
+		CertDir:  certDir,
+		JWTSVIDs: []sidecar.JWTConfig{{JWTAudience: "aud", JWTSVIDFilename: "jwt.token"}},
+		X509SVIDs: []sidecar.X509SVIDConfig{
+			{SVIDFilename: "db.pem", SVIDKeyFilename: "db_key.pem", SVIDBundleFilename: "db_bundle.pem"},
+		},
+	})
+	h := New(&Config{GRPCListenerEnabled: true}, log, s)
+	healthServer := grpchealth.NewServer()
+	services := h.updateGRPCStatuses(healthServer, nil)
+
+	jwtService := GRPCServiceJWTSVIDPrefix + path.Join(certDir, "jwt.token")
+	x509Service := GRPCServiceX509SVIDPrefix + path.Join(certDir, "db.pem")
+	for _, service := range []string{jwtService, x509Service} {
+		resp, err := healthServer.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err, service)
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status, service)
+	}
+
+	// The reloaded configuration drops both outputs
+	h.sidecar = sidecar.New(&sidecar.Config{Log: log, CertDir: certDir, JWTBundleFilename: "jwt_bundle.json"})
+	services = h.updateGRPCStatuses(healthServer, services)
+	assert.NotContains(t, services, jwtService)
+	for _, service := range []string{jwtService, x509Service} {
+		resp, err := healthServer.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err, service)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVICE_UNKNOWN, resp.Status, service)
+	}
+	resp, err := healthServer.Check(ctx, &healthpb.HealthCheckRequest{Service: GRPCServiceJWTBundle})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+}
+
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestHealth_GRPCListenError(t *testing.T) {
+	log, _ := test.NewNullLogger()
+	s := sidecar.New(&sidecar.Config{Log: log, CertDir: t.TempDir(), JWTBundleFilename: "jwt_bundle.json"})
+
+	// The port is already taken
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	h := New(&Config{
+		GRPCListenerEnabled: true,
+		BindAddress:         "127.0.0.1",
+		GRPCBindPort:        listener.Addr().(*net.TCPAddr).Port,
+	}, log, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.ErrorContains(t, h.Start(ctx), "unable to listen for gRPC health checks")
+}
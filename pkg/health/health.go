@@ -2,22 +2,59 @@ package health
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"expvar"
+	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"os"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
 	"github.com/spiffe/spiffe-helper/pkg/sidecar"
+	"github.com/spiffe/spiffe-helper/pkg/systemd"
+	"github.com/spiffe/spiffe-helper/pkg/util"
 )
 
 type Config struct {
-	ListenerEnabled bool   `hcl:"listener_enabled"`
-	BindPort        int    `hcl:"bind_port"`
-	LivenessPath    string `hcl:"liveness_path"`
-	ReadinessPath   string `hcl:"readiness_path"`
+	ListenerEnabled     bool   `hcl:"listener_enabled"`
+	GRPCListenerEnabled bool   `hcl:"grpc_listener_enabled"`
+	BindAddress         string `hcl:"bind_address"`
+	BindPort            int    `hcl:"bind_port"`
+	GRPCBindPort        int    `hcl:"grpc_bind_port"`
+	LivenessPath        string `hcl:"liveness_path"`
+	ReadinessPath       string `hcl:"readiness_path"`
+	MetricsPath         string `hcl:"metrics_path"`
+	StatusPath          string `hcl:"status_path"`
+
+	// Serve net/http/pprof at /debug/pprof/ and expvar at /debug/vars
+	DebugEndpointsEnabled bool `hcl:"debug_endpoints_enabled"`
+
+	// Durations after which the liveness and readiness checks fail, see
+	// sidecar.Config
+	ReadinessMinLifetime string `hcl:"readiness_min_lifetime"`
+	LivenessUpdateWindow string `hcl:"liveness_update_window"`
+
+	// Listen on a Unix socket with the given file mode instead of TCP
+	UnixSocketPath     string `hcl:"unix_socket_path"`
+	UnixSocketFileMode int    `hcl:"unix_socket_file_mode"`
+
+	// Serve TLS with the helper's own X.509 SVID, only accepting callers
+	// presenting one of the allowed SPIFFE IDs, or any SPIFFE ID in the
+	// helper's trust domain if none are listed
+	TLSEnabled          bool     `hcl:"tls_enabled"`
+	TLSAllowedSPIFFEIDs []string `hcl:"tls_allowed_spiffe_ids"`
 }
 
 const (
@@ -27,39 +64,157 @@ const (
 	statusServiceUnavailable = http.StatusServiceUnavailable
 )
 
+// Names of the sockets passed with systemd socket activation that the HTTP
+// endpoints and the gRPC health service are served on
+const (
+	ActivatedSocketHTTP = "health"
+	ActivatedSocketGRPC = "grpc"
+)
+
 type Health struct {
 	c       *Config
 	log     logrus.FieldLogger
 	sidecar *sidecar.Sidecar
+	mux     *http.ServeMux
+
+	// Sockets passed with systemd socket activation, served instead of
+	// listening on the configured address
+	httpListener net.Listener
+	grpcListener net.Listener
 }
 
 func New(config *Config, log logrus.FieldLogger, sidecar *sidecar.Sidecar) *Health {
-	return &Health{
+	h := &Health{
 		c:       config,
 		log:     log,
 		sidecar: sidecar,
+		mux:     http.NewServeMux(),
+	}
+	h.registerHandlers()
+	return h
+}
+
+// Mux returns the mux served by the HTTP health server. Embedders can mount
+// extra handlers on it before calling Start.
+func (h *Health) Mux() *http.ServeMux {
+	return h.mux
+}
+
+func (h *Health) registerHandlers() {
+	handlers := map[string]http.Handler{
+		h.c.LivenessPath: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			writeResponse(w, h.sidecar.CheckLiveness(), h.log, h.sidecar)
+		}),
+		h.c.ReadinessPath: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			writeResponse(w, h.sidecar.CheckReadiness(), h.log, h.sidecar)
+		}),
+		h.c.MetricsPath: promhttp.HandlerFor(h.sidecar.Metrics(), promhttp.HandlerOpts{
+			ErrorLog: h.log,
+		}),
+		h.c.StatusPath: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			writeStatus(w, h.log, h.sidecar)
+		}),
+	}
+	// Embedders may leave the paths of the endpoints they don't serve empty.
+	// The configuration file defaults them, and rejects paths used twice.
+	delete(handlers, "")
+	for path, handler := range handlers {
+		h.mux.Handle(path, handler)
+	}
+
+	if h.c.DebugEndpointsEnabled {
+		h.mux.HandleFunc("/debug/pprof/", pprof.Index)
+		h.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		h.mux.HandleFunc("/debug/pprof/profile", withoutWriteTimeout(pprof.Profile))
+		h.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		h.mux.HandleFunc("/debug/pprof/trace", withoutWriteTimeout(pprof.Trace))
+		h.mux.Handle("/debug/vars", expvar.Handler())
+	}
+}
+
+// withoutWriteTimeout lifts the server's write timeout for handlers that
+// stream for as long as the caller asks, such as CPU profiles
+func withoutWriteTimeout(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		handler(w, r)
+	}
+}
+
+// UseListeners serves the health server on sockets passed with systemd
+// socket activation instead of the configured address. The HTTP endpoints
+// are served on the socket named ActivatedSocketHTTP, or else the first one,
+// and the gRPC health service on the socket named ActivatedSocketGRPC, or
+// else the next one. Sockets that aren't used are closed.
+func (h *Health) UseListeners(listeners []systemd.Listener) {
+	remaining := slices.Clone(listeners)
+	take := func(enabled bool, name, served string) net.Listener {
+		i := slices.IndexFunc(remaining, func(listener systemd.Listener) bool { return listener.Name == name })
+		if i < 0 {
+			i = slices.IndexFunc(remaining, func(listener systemd.Listener) bool {
+				return listener.Name != ActivatedSocketHTTP && listener.Name != ActivatedSocketGRPC
+			})
+		}
+		if !enabled || i < 0 {
+			return nil
+		}
+		listener := remaining[i]
+		remaining = slices.Delete(remaining, i, i+1)
+		h.log.Infof("Serving %s on the socket %q passed by systemd", served, listener.Name)
+		return listener
+	}
+	h.httpListener = take(h.c.ListenerEnabled, ActivatedSocketHTTP, "health checks")
+	h.grpcListener = take(h.c.GRPCListenerEnabled, ActivatedSocketGRPC, "gRPC health checks")
+
+	for _, listener := range remaining {
+		h.log.Warnf("Closing the unused socket %q passed by systemd", listener.Name)
+		listener.Close()
 	}
 }
 
+// Start serves the HTTP health endpoints if ListenerEnabled, and the gRPC
+// health service if GRPCListenerEnabled, until the context is cancelled
 func (h *Health) Start(ctx context.Context) error {
+	var tasks []func(context.Context) error
+	if h.c.ListenerEnabled {
+		tasks = append(tasks, h.serveHTTP)
+	}
+	if h.c.GRPCListenerEnabled {
+		tasks = append(tasks, h.serveGRPC)
+	}
+	return util.RunTasks(ctx, tasks...)
+}
+
+func (h *Health) serveHTTP(ctx context.Context) error {
 	h.log.Info("Starting health server")
-	http.HandleFunc(h.c.LivenessPath, func(w http.ResponseWriter, _ *http.Request) {
-		writeResponse(w, h.sidecar.CheckLiveness(), h.log, h.sidecar)
-	})
-	http.HandleFunc(h.c.ReadinessPath, func(w http.ResponseWriter, _ *http.Request) {
-		writeResponse(w, h.sidecar.CheckReadiness(), h.log, h.sidecar)
-	})
 	server := &http.Server{
-		Addr:              ":" + strconv.Itoa(h.c.BindPort),
+		Handler:           h.mux,
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      5 * time.Second,
 	}
+	if h.c.TLSEnabled {
+		tlsConfig, err := h.tlsConfig()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = tlsConfig
+	}
+
+	listener, err := h.listen()
+	if err != nil {
+		return fmt.Errorf("unable to listen for health checks: %w", err)
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		err := server.ListenAndServe()
+		var err error
+		if h.c.TLSEnabled {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			h.log.WithError(err).Warn("Error serving health checks")
 		}
@@ -77,6 +232,74 @@ func (h *Health) Start(ctx context.Context) error {
 	return ctx.Err()
 }
 
+// listen returns the socket passed by systemd, or listens on the configured
+// Unix socket, or TCP address and port
+func (h *Health) listen() (net.Listener, error) {
+	if h.httpListener != nil {
+		return h.httpListener, nil
+	}
+	if h.c.UnixSocketPath == "" {
+		return net.Listen("tcp", net.JoinHostPort(h.c.BindAddress, strconv.Itoa(h.c.BindPort)))
+	}
+
+	// Remove the socket left behind if the helper wasn't shut down cleanly,
+	// but never another file found at the path
+	info, err := os.Lstat(h.c.UnixSocketPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case info.Mode().Type() != fs.ModeSocket:
+		return nil, fmt.Errorf("%q exists and is not a socket", h.c.UnixSocketPath)
+	default:
+		if err := os.Remove(h.c.UnixSocketPath); err != nil {
+			return nil, fmt.Errorf("unable to remove stale socket: %w", err)
+		}
+	}
+	listener, err := net.Listen("unix", h.c.UnixSocketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(h.c.UnixSocketPath, fs.FileMode(h.c.UnixSocketFileMode)); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("unable to set socket file mode: %w", err)
+	}
+	return listener, nil
+}
+
+// tlsConfig is the mTLS configuration serving the helper's own X.509 SVID
+func (h *Health) tlsConfig() (*tls.Config, error) {
+	authorizer, err := h.authorizer()
+	if err != nil {
+		return nil, err
+	}
+	return tlsconfig.MTLSServerConfig(h.sidecar, h.sidecar, authorizer), nil
+}
+
+// authorizer authorizes callers presenting one of TLSAllowedSPIFFEIDs, or
+// any SPIFFE ID in the trust domain of the helper's SVID if none are listed
+func (h *Health) authorizer() (tlsconfig.Authorizer, error) {
+	if len(h.c.TLSAllowedSPIFFEIDs) > 0 {
+		ids := make([]spiffeid.ID, 0, len(h.c.TLSAllowedSPIFFEIDs))
+		for _, allowedID := range h.c.TLSAllowedSPIFFEIDs {
+			id, err := spiffeid.FromString(allowedID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid health_checks.tls_allowed_spiffe_ids: %w", err)
+			}
+			ids = append(ids, id)
+		}
+		return tlsconfig.AuthorizeOneOf(ids...), nil
+	}
+
+	return func(id spiffeid.ID, verifiedChains [][]*x509.Certificate) error {
+		svid, err := h.sidecar.GetX509SVID()
+		if err != nil {
+			return err
+		}
+		return tlsconfig.AuthorizeMemberOf(svid.ID.TrustDomain())(id, verifiedChains)
+	}, nil
+}
+
 type Response struct {
 	Status string         `json:"status"`
 	Health sidecar.Health `json:"health"`
@@ -115,3 +338,21 @@ func writeResponse(w http.ResponseWriter, goodStatus bool, log logrus.FieldLogge
 		log.WithError(err).Errorf("failed writing response JSON")
 	}
 }
+
+// writeStatus writes the details of the credentials last written to each
+// output of the sidecar
+func writeStatus(w http.ResponseWriter, log logrus.FieldLogger, sidecar *sidecar.Sidecar) {
+	jsonBytes, err := json.Marshal(sidecar.Status())
+	if err != nil {
+		log.WithError(err).Errorf("failed marshalling status")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(statusOK)
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		log.WithError(err).Errorf("failed writing status JSON")
+	}
+}
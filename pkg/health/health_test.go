@@ -0,0 +1,215 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spiffe/spiffe-helper/pkg/sidecar"
+	"github.com/spiffe/spiffe-helper/pkg/systemd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth_UnixSocket(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	log, _ := test.NewNullLogger()
+	certDir := t.TempDir()
+	s := sidecar.New(&sidecar.Config{
+		Log:                log,
+		CertDir:            certDir,
+		SVIDFilename:       "svid.pem",
+		SVIDKeyFilename:    "svid_key.pem",
+		SVIDBundleFilename: "svid_bundle.pem",
+	})
+
+	socketPath := path.Join(t.TempDir(), "health.sock")
+	// A stale socket from a previous run is replaced
+	stale, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	h := New(&Config{
+		ListenerEnabled:    true,
+		LivenessPath:       "/live",
+		ReadinessPath:      "/ready",
+		MetricsPath:        "/metrics",
+		StatusPath:         "/status",
+		UnixSocketPath:     socketPath,
+		UnixSocketFileMode: 0660,
+	}, log, s)
+
+	errCh := make(chan error, 1)
+	serverCtx, stopServer := context.WithCancel(ctx)
+	go func() {
+		errCh <- h.Start(serverCtx)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = client.Get("http://health/status")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var status sidecar.Status
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	require.NotNil(t, status.X509SVID)
+	assert.Equal(t, path.Join(certDir, "svid.pem"), status.X509SVID.File)
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(socketPath)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+	}
+
+	stopServer()
+	require.ErrorIs(t, <-errCh, context.Canceled)
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err), "socket should be removed on shutdown")
+}
+
+func TestHealth_UnixSocketNotASocket(t *testing.T) {
+	log, _ := test.NewNullLogger()
+	s := sidecar.New(&sidecar.Config{Log: log, CertDir: t.TempDir()})
+
+	// A file that isn't a socket is never removed
+	socketPath := path.Join(t.TempDir(), "health.sock")
+	require.NoError(t, os.WriteFile(socketPath, []byte("data"), 0600))
+
+	h := New(&Config{
+		ListenerEnabled:    true,
+		LivenessPath:       "/live",
+		UnixSocketPath:     socketPath,
+		UnixSocketFileMode: 0660,
+	}, log, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := h.Start(ctx)
+	require.ErrorContains(t, err, "is not a socket")
+
+	data, err := os.ReadFile(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+func TestHealth_UseListeners(t *testing.T) {
+	log, _ := test.NewNullLogger()
+	s := sidecar.New(&sidecar.Config{Log: log, CertDir: t.TempDir(), JWTBundleFilename: "jwt_bundle.json"})
+
+	var listeners []systemd.Listener
+	for _, name := range []string{ActivatedSocketGRPC, "spiffe-helper.socket", "unused"} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+		listeners = append(listeners, systemd.Listener{Listener: listener, Name: name})
+	}
+
+	h := New(&Config{
+		ListenerEnabled:     true,
+		GRPCListenerEnabled: true,
+		LivenessPath:        "/live",
+	}, log, s)
+	h.UseListeners(listeners)
+
+	// The socket named for gRPC is used for it, the HTTP endpoints take the
+	// first of the others, and the rest are closed
+	assert.Equal(t, listeners[0], h.grpcListener)
+	assert.Equal(t, listeners[1], h.httpListener)
+	_, err := listeners[2].Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- h.Start(ctx)
+	}()
+
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		resp, err = http.Get("http://" + listeners[1].Addr().String() + "/live")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cancel()
+	require.ErrorIs(t, <-errCh, context.Canceled)
+}
+
+func TestHealth_Mux(t *testing.T) {
+	log, _ := test.NewNullLogger()
+	s := sidecar.New(&sidecar.Config{
+		Log:               log,
+		CertDir:           t.TempDir(),
+		JWTBundleFilename: "jwt_bundle.json",
+	})
+	config := &Config{
+		ListenerEnabled: true,
+		LivenessPath:    "/live",
+		ReadinessPath:   "/ready",
+		MetricsPath:     "/metrics",
+		StatusPath:      "/status",
+	}
+
+	// Each server has its own mux, so several can be created in a process
+	h := New(config, log, s)
+	debugConfig := *config
+	debugConfig.DebugEndpointsEnabled = true
+	debug := New(&debugConfig, log, s)
+
+	// Embedders can mount their own handlers
+	h.Mux().HandleFunc("/extra", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, tc := range []struct {
+		name       string
+		health     *Health
+		path       string
+		statusCode int
+	}{
+		{name: "liveness", health: h, path: "/live", statusCode: http.StatusOK},
+		{name: "readiness", health: h, path: "/ready", statusCode: http.StatusServiceUnavailable},
+		{name: "status", health: h, path: "/status", statusCode: http.StatusOK},
+		{name: "metrics", health: h, path: "/metrics", statusCode: http.StatusOK},
+		{name: "extra handler", health: h, path: "/extra", statusCode: http.StatusTeapot},
+		{name: "extra handler on another server", health: debug, path: "/extra", statusCode: http.StatusNotFound},
+		{name: "pprof disabled", health: h, path: "/debug/pprof/", statusCode: http.StatusNotFound},
+		{name: "expvar disabled", health: h, path: "/debug/vars", statusCode: http.StatusNotFound},
+		{name: "pprof", health: debug, path: "/debug/pprof/", statusCode: http.StatusOK},
+		{name: "pprof heap", health: debug, path: "/debug/pprof/heap", statusCode: http.StatusOK},
+		{name: "expvar", health: debug, path: "/debug/vars", statusCode: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tc.health.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.statusCode, rec.Code)
+		})
+	}
+}
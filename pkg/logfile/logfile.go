@@ -0,0 +1,127 @@
+// Package logfile provides an io.Writer that appends to a file and rotates it
+// once it grows beyond a maximum size.
+package logfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const fileMode fs.FileMode = 0600
+
+// Writer appends to a log file. When a write would make the file larger than
+// MaxSize, the file is renamed to <name>.1, existing backups are shifted
+// to <name>.2 and so on, and a new file is started. At most MaxBackups
+// rotated files are kept.
+//
+// The file is opened on the first write, so creating a Writer never fails.
+// Writer is safe for concurrent use.
+type Writer struct {
+	filename   string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// New returns a Writer for filename. A maxSize of zero disables rotation.
+func New(filename string, maxSize int64, maxBackups int) *Writer {
+	return &Writer{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+}
+
+// Filename returns the path of the file being written to
+func (w *Writer) Filename() string {
+	return w.filename
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	// A single write larger than the maximum size goes to a file of its own
+	// rather than being split
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the file. A later write reopens it.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	w.file = nil
+
+	if w.maxBackups > 0 {
+		if err := os.Remove(w.backupName(w.maxBackups)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove old log file: %w", err)
+		}
+		for i := w.maxBackups - 1; i > 0; i-- {
+			if err := os.Rename(w.backupName(i), w.backupName(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to rotate log file: %w", err)
+			}
+		}
+		if err := os.Rename(w.filename, w.backupName(1)); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else if err := os.Remove(w.filename); err != nil {
+		return fmt.Errorf("failed to truncate log file: %w", err)
+	}
+
+	return w.open()
+}
+
+func (w *Writer) backupName(i int) string {
+	return w.filename + "." + strconv.Itoa(i)
+}
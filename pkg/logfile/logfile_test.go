@@ -0,0 +1,94 @@
+package logfile
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, filename string) string {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestWriter(t *testing.T) {
+	filename := path.Join(t.TempDir(), "cmd.log")
+	require.NoError(t, os.WriteFile(filename, []byte("existing\n"), 0600))
+
+	w := New(filename, 20, 2)
+	defer w.Close()
+
+	// Appends to the existing file until it is full
+	_, err := w.Write([]byte("line 1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "existing\nline 1\n", readFile(t, filename))
+
+	_, err = w.Write([]byte("line 2\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "line 2\n", readFile(t, filename))
+	assert.Equal(t, "existing\nline 1\n", readFile(t, filename+".1"))
+
+	// Backups are shifted, and only maxBackups are kept
+	for _, line := range []string{"line 3\n", "line 4\n", "line 5\n", "line 6\n", "line 7\n"} {
+		_, err = w.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "line 6\nline 7\n", readFile(t, filename))
+	assert.Equal(t, "line 4\nline 5\n", readFile(t, filename+".1"))
+	assert.Equal(t, "line 2\nline 3\n", readFile(t, filename+".2"))
+	assert.NoFileExists(t, filename+".3")
+
+	// Writes larger than the maximum size are not split
+	long := strings.Repeat("x", 30) + "\n"
+	_, err = w.Write([]byte(long))
+	require.NoError(t, err)
+	assert.Equal(t, long, readFile(t, filename))
+	assert.Equal(t, "line 6\nline 7\n", readFile(t, filename+".1"))
+}
+
+func TestWriterWithoutBackups(t *testing.T) {
+	filename := path.Join(t.TempDir(), "cmd.log")
+	w := New(filename, 10, 0)
+	defer w.Close()
+
+	for _, line := range []string{"line 1\n", "line 2\n"} {
+		_, err := w.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "line 2\n", readFile(t, filename))
+	assert.NoFileExists(t, filename+".1")
+}
+
+func TestWriterConcurrent(t *testing.T) {
+	filename := path.Join(t.TempDir(), "cmd.log")
+	w := New(filename, 0, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := w.Write([]byte("0123456789\n"))
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, strings.Repeat("0123456789\n", 1000), readFile(t, filename))
+}
+
+func TestWriterOpenError(t *testing.T) {
+	w := New(path.Join(t.TempDir(), "missing", "cmd.log"), 0, 0)
+	_, err := w.Write([]byte("line\n"))
+	require.ErrorContains(t, err, "failed to open log file")
+}
@@ -0,0 +1,105 @@
+package sidecar
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"github.com/spiffe/spiffe-helper/pkg/audit"
+	"github.com/spiffe/spiffe-helper/pkg/disk"
+)
+
+// audit appends the entry to the audit log, if one is configured. Failures
+// are logged and don't stop credentials from being rotated.
+func (s *Sidecar) audit(entry audit.Entry) {
+	config := s.cfg()
+	if config.AuditLog == nil {
+		return
+	}
+	if err := config.AuditLog.Append(entry); err != nil {
+		config.Log.WithError(err).Error("Unable to write audit log")
+	}
+}
+
+func (s *Sidecar) auditX509Write(x509SVID X509SVIDConfig, x509Context *workloadapi.X509Context, err error) {
+	entry := audit.Entry{
+		Event:           audit.EventX509SVIDWritten,
+		CredentialTypes: []string{CredentialTypeX509},
+		Files:           s.cfg().x509SVIDFiles(x509SVID),
+	}
+	if err != nil {
+		entry.Event = audit.EventWriteFailed
+		entry.Error = err.Error()
+		s.audit(entry)
+		return
+	}
+
+	if svid, err := disk.GetX509SVID(x509Context, x509SVID.Hint, x509SVID.SPIFFEID); err == nil {
+		leaf := svid.Certificates[0]
+		fingerprint := sha256.Sum256(leaf.Raw)
+		entry.SPIFFEID = svid.ID.String()
+		entry.SerialNumber = leaf.SerialNumber.Text(16)
+		entry.SHA256Fingerprint = hex.EncodeToString(fingerprint[:])
+		entry.Expiry = &leaf.NotAfter
+	}
+	entry.TrustDomains = x509TrustDomains(x509Context.Bundles)
+	s.audit(entry)
+}
+
+func (s *Sidecar) auditJWTSVIDWrite(jwtSVIDFilename string, jwtSVIDs []*jwtsvid.SVID, err error) {
+	config := s.cfg()
+	entry := audit.Entry{
+		Event:           audit.EventJWTSVIDWritten,
+		CredentialTypes: []string{CredentialTypeJWTSVID},
+		Files:           []string{path.Join(config.CertDir, jwtSVIDFilename)},
+	}
+	if err != nil {
+		entry.Event = audit.EventWriteFailed
+		entry.Error = err.Error()
+		s.audit(entry)
+		return
+	}
+
+	if jwtSVID, err := disk.GetJWTSVID(jwtSVIDs, config.Hint); err == nil {
+		// The token itself is a bearer credential, so only its digest is
+		// recorded
+		fingerprint := sha256.Sum256([]byte(jwtSVID.Marshal()))
+		entry.SPIFFEID = jwtSVID.ID.String()
+		entry.SHA256Fingerprint = hex.EncodeToString(fingerprint[:])
+		entry.Expiry = &jwtSVID.Expiry
+	}
+	s.audit(entry)
+}
+
+func (s *Sidecar) auditJWTBundleWrite(jwtBundleSet *jwtbundle.Set, err error) {
+	config := s.cfg()
+	entry := audit.Entry{
+		Event:           audit.EventJWTBundleWritten,
+		CredentialTypes: []string{CredentialTypeJWTBundle},
+		Files:           []string{path.Join(config.CertDir, config.JWTBundleFilename)},
+	}
+	if err != nil {
+		entry.Event = audit.EventWriteFailed
+		entry.Error = err.Error()
+		s.audit(entry)
+		return
+	}
+
+	entry.TrustDomains = jwtTrustDomains(jwtBundleSet)
+	s.audit(entry)
+}
+
+func (s *Sidecar) auditNotifications(credentialTypes []string, results []audit.NotificationResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	s.audit(audit.Entry{
+		Event:           audit.EventNotificationsSent,
+		CredentialTypes: credentialTypes,
+		Notifications:   results,
+	})
+}
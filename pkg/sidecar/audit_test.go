@@ -0,0 +1,91 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiffe/spiffe-helper/pkg/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSidecar_AuditLog(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	config := s.sidecar.config
+	auditFile := path.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(auditFile, 0600)
+	require.NoError(t, err)
+	defer auditLog.Close()
+	config.AuditLog = auditLog
+	config.Cmd = ""
+	missingPIDFile := path.Join(config.CertDir, "missing.pid")
+	config.NotifyTargets = []NotifyTarget{{PIDFilename: missingPIDFile, RenewSignal: "SIGHUP"}}
+
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+	<-s.notifyTargetSignalledChan
+
+	// The notifications are recorded once every target has been signalled
+	require.Eventually(t, func() bool {
+		count, err := audit.VerifyFile(auditFile)
+		return err == nil && count == 2
+	}, time.Second, 10*time.Millisecond)
+
+	s.sidecar.recordJWTBundleWrite(nil, errors.New("disk full"))
+
+	count, err := audit.VerifyFile(auditFile)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	entries := readAuditLog(t, auditFile)
+
+	leaf := svid.svidChain[0]
+	fingerprint := sha256.Sum256(leaf.Raw)
+	written := entries[0]
+	assert.Equal(t, audit.EventX509SVIDWritten, written.Event)
+	assert.Equal(t, exampleSpiffeID, written.SPIFFEID)
+	assert.Equal(t, leaf.SerialNumber.Text(16), written.SerialNumber)
+	assert.Equal(t, hex.EncodeToString(fingerprint[:]), written.SHA256Fingerprint)
+	assert.True(t, leaf.NotAfter.Equal(*written.Expiry))
+	assert.Equal(t, []string{"example.test"}, written.TrustDomains)
+	assert.Equal(t, s.sidecar.x509Files(), written.Files)
+
+	notified := entries[1]
+	assert.Equal(t, audit.EventNotificationsSent, notified.Event)
+	assert.Equal(t, []string{CredentialTypeX509}, notified.CredentialTypes)
+	require.Len(t, notified.Notifications, 1)
+	assert.Equal(t, "pid_file_name="+missingPIDFile+",renew_signal=SIGHUP", notified.Notifications[0].Target)
+	assert.Equal(t, notifyStatusFailed, notified.Notifications[0].Result)
+	assert.Contains(t, notified.Notifications[0].Error, "failed to read pid file")
+
+	failed := entries[2]
+	assert.Equal(t, audit.EventWriteFailed, failed.Event)
+	assert.Equal(t, []string{CredentialTypeJWTBundle}, failed.CredentialTypes)
+	assert.Equal(t, "disk full", failed.Error)
+}
+
+func readAuditLog(t *testing.T, filename string) []audit.Entry {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+
+	var entries []audit.Entry
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var entry audit.Entry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,112 @@
+package sidecar
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Parsers that can split a CmdArgs string into arguments
+const (
+	// CmdArgsParserLegacy splits on spaces, with double-quoted arguments
+	// and doubled double-quotes inside them. This is the default.
+	CmdArgsParserLegacy = "legacy"
+
+	// CmdArgsParserShell splits according to POSIX shell quoting rules,
+	// without any expansion.
+	CmdArgsParserShell = "shell"
+)
+
+// CmdArgsParsers lists every supported CmdArgs parser
+var CmdArgsParsers = []string{CmdArgsParserLegacy, CmdArgsParserShell}
+
+// ParseCmdArgs splits args into an argument vector with the given parser.
+// An empty parser selects CmdArgsParserLegacy.
+func ParseCmdArgs(args string, parser string) ([]string, error) {
+	switch parser {
+	case "", CmdArgsParserLegacy:
+		return getCmdArgs(args)
+	case CmdArgsParserShell:
+		return splitShellWords(args)
+	default:
+		return nil, fmt.Errorf("unknown cmd args parser %q", parser)
+	}
+}
+
+// cmdArgs returns the arguments of the process to launch. CmdArgv is used
+// as-is when set, otherwise CmdArgs is split with CmdArgsParser.
+func (s *Sidecar) cmdArgs() ([]string, error) {
+	config := s.cfg()
+	if config.CmdArgv != nil {
+		return config.CmdArgv, nil
+	}
+	return ParseCmdArgs(config.CmdArgs, config.CmdArgsParser)
+}
+
+// splitShellWords splits s into words the way a POSIX shell does, honouring
+// single quotes, double quotes and backslash escapes. No parameter, command
+// or glob expansion is performed.
+func splitShellWords(s string) ([]string, error) {
+	args := []string{}
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			i++
+			if i == len(s) {
+				return nil, errors.New("unterminated backslash escape")
+			}
+			// A backslash-newline pair is a line continuation
+			if s[i] != '\n' {
+				word.WriteByte(s[i])
+				inWord = true
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single-quoted string")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			closed := false
+			for i++; i < len(s); i++ {
+				if s[i] == '"' {
+					closed = true
+					break
+				}
+				// Inside double quotes a backslash only escapes characters
+				// that are otherwise special there
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, errors.New("unterminated double-quoted string")
+			}
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
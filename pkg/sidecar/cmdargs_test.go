@@ -0,0 +1,125 @@
+package sidecar
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCmdArgs(t *testing.T) {
+	cases := []struct {
+		name         string
+		in           string
+		parser       string
+		expectedArgs []string
+		expectedErr  string
+	}{
+		{
+			name:         "Legacy parser by default",
+			in:           `-c "echo ""hi"""`,
+			expectedArgs: []string{"-c", `echo "hi"`},
+		},
+		{
+			name:         "Legacy parser does not honour single quotes",
+			in:           `-c 'echo hello'`,
+			parser:       CmdArgsParserLegacy,
+			expectedArgs: []string{"-c", "'echo", "hello'"},
+		},
+		{
+			name:         "Empty input",
+			parser:       CmdArgsParserShell,
+			expectedArgs: []string{},
+		},
+		{
+			name:         "Whitespace is collapsed",
+			in:           "  first \t second\nthird  ",
+			parser:       CmdArgsParserShell,
+			expectedArgs: []string{"first", "second", "third"},
+		},
+		{
+			name:         "Single quotes",
+			in:           `-c 'echo "hello" \ world'`,
+			parser:       CmdArgsParserShell,
+			expectedArgs: []string{"-c", `echo "hello" \ world`},
+		},
+		{
+			name:         "Double quotes",
+			in:           `-c "echo \"hello\" \$HOME \n 'world'"`,
+			parser:       CmdArgsParserShell,
+			expectedArgs: []string{"-c", `echo "hello" $HOME \n 'world'`},
+		},
+		{
+			name:         "Backslash escapes",
+			in:           `one\ argument \'quoted\' line\` + "\n" + `continued`,
+			parser:       CmdArgsParserShell,
+			expectedArgs: []string{"one argument", "'quoted'", "linecontinued"},
+		},
+		{
+			name:         "Adjacent quoted parts form one word",
+			in:           `--name='a b'"c d"e ''`,
+			parser:       CmdArgsParserShell,
+			expectedArgs: []string{"--name=a bc de", ""},
+		},
+		{
+			name:         "No expansion",
+			in:           `$HOME * ~ $(id)`,
+			parser:       CmdArgsParserShell,
+			expectedArgs: []string{"$HOME", "*", "~", "$(id)"},
+		},
+		{
+			name:        "Unterminated single quote",
+			in:          `-c 'echo`,
+			parser:      CmdArgsParserShell,
+			expectedErr: "unterminated single-quoted string",
+		},
+		{
+			name:        "Unterminated double quote",
+			in:          `-c "echo \"`,
+			parser:      CmdArgsParserShell,
+			expectedErr: "unterminated double-quoted string",
+		},
+		{
+			name:        "Trailing backslash",
+			in:          `echo \`,
+			parser:      CmdArgsParserShell,
+			expectedErr: "unterminated backslash escape",
+		},
+		{
+			name:        "Unknown parser",
+			in:          "echo",
+			parser:      "csv",
+			expectedErr: `unknown cmd args parser "csv"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args, err := ParseCmdArgs(c.in, c.parser)
+			if c.expectedErr != "" {
+				require.EqualError(t, err, c.expectedErr)
+				require.Nil(t, args)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.expectedArgs, args)
+		})
+	}
+}
+
+func TestSidecar_CmdArgv(t *testing.T) {
+	s := &Sidecar{config: &Config{
+		CmdArgs: "ignored",
+		CmdArgv: []string{"-c", "echo 'hello world'"},
+	}}
+	args, err := s.cmdArgs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-c", "echo 'hello world'"}, args)
+
+	s.config.CmdArgv = nil
+	s.config.CmdArgs = "-c 'echo hello'"
+	s.config.CmdArgsParser = CmdArgsParserShell
+	args, err = s.cmdArgs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-c", "echo hello"}, args)
+}
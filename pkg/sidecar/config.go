@@ -1,9 +1,14 @@
 package sidecar
 
 import (
+	"io"
 	"io/fs"
+	"path"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/spiffe/spiffe-helper/pkg/audit"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Config struct {
@@ -17,12 +22,73 @@ type Config struct {
 	// The path to the process to launch.
 	Cmd string
 
-	// The arguments of the process to launch.
+	// The arguments of the process to launch, split with CmdArgsParser.
+	// Ignored if CmdArgv is set.
 	CmdArgs string
 
+	// How CmdArgs is split into arguments, one of CmdArgsParsers. Defaults
+	// to CmdArgsParserLegacy.
+	CmdArgsParser string
+
+	// The argument vector of the process to launch, passed as-is.
+	CmdArgv []string
+
+	// Extra environment variables for the process to launch. They take
+	// precedence over the helper's environment and the SPIFFE_HELPER_*
+	// variables describing the credentials.
+	CmdEnv map[string]string
+
+	// If true, the contents of the JWT SVIDs are passed to the process to
+	// launch in SPIFFE_HELPER_JWT_SVID_<n> environment variables.
+	CmdEnvIncludeJWTSVIDs bool
+
+	// stdin of the process to launch. Defaults to the helper's own stdin.
+	// Set to StdinNull or StdinClosed to pass no input.
+	CmdStdin io.Reader
+
+	// stdout and stderr of the process to launch. Default to the helper's
+	// own. Use a LogWriter to re-emit the output through the helper's
+	// logger, or a logfile.Writer to write it to rotating files.
+	CmdStdout io.Writer
+	CmdStderr io.Writer
+
+	// The signal sent to the process to launch when the helper shuts down.
+	// Defaults to SIGTERM. Not supported on Windows, where the process is
+	// killed straight away.
+	CmdStopSignal string
+
+	// How long the process to launch is given to exit after CmdStopSignal
+	// before it is killed. Defaults to 10 seconds.
+	CmdStopTimeout time.Duration
+
+	// Readiness fails when an SVID written to disk expires within this
+	// duration. Zero disables the check.
+	ReadinessMinLifetime time.Duration
+
+	// Liveness fails when an SVID file hasn't been written successfully
+	// within this duration. Zero disables the check.
+	LivenessUpdateWindow time.Duration
+
 	// Signal external process via PID file
 	PIDFilename string
 
+	// External processes to signal when credentials are rotated. Each target
+	// carries its own PID file, signal and triggering credential types.
+	NotifyTargets []NotifyTarget
+
+	// How long to wait for further credential updates before running 'cmd'
+	// and signalling processes, so bursts of updates cause one notification.
+	// Files are still written as soon as updates are received.
+	NotifyDebounce time.Duration
+
+	// Longest time NotifyDebounce holds back notifications after the first
+	// update still pending, so a steady stream of updates can't postpone
+	// them forever. Defaults to ten times NotifyDebounce.
+	NotifyMaxDelay time.Duration
+
+	// Minimum time between two rounds of notifications
+	NotifyMinInterval time.Duration
+
 	// The directory name to store the x509s and/or JWTs.
 	CertDir string
 
@@ -50,6 +116,15 @@ type Config struct {
 	// The logger to use
 	Log logrus.FieldLogger
 
+	// Records every credential written and the notifications they caused.
+	// No audit log is written if nil.
+	AuditLog *audit.Log
+
+	// Provides the tracer used to trace credential updates and
+	// notifications. Defaults to the global TracerProvider, which doesn't
+	// record anything unless one has been registered.
+	TracerProvider trace.TracerProvider
+
 	// The signal that the process to be launched expects to reload the certificates. Not supported on Windows.
 	RenewSignal string
 
@@ -62,6 +137,11 @@ type Config struct {
 	// File name to be used to store the X.509 SVID Bundle in PEM format.
 	SVIDBundleFilename string
 
+	// Further X.509 SVID outputs, each with its own SVID, files and format.
+	// They are written after the one configured with SVIDFilename,
+	// SVIDKeyFilename and SVIDBundleFilename, if any.
+	X509SVIDs []X509SVIDConfig
+
 	// Number of parallel requests to the Agent Workload API. This simulates a number of spiffe-helper replicas within the same instance.
 	ParallelRequests int
 
@@ -82,3 +162,106 @@ type JWTConfig struct {
 	// The filename to save the JWT SVID to
 	JWTSVIDFilename string
 }
+
+type X509SVIDConfig struct {
+	// The hint of the SVID to write. The default SVID is written if neither
+	// Hint nor SPIFFEID is set.
+	Hint string
+
+	// The SPIFFE ID of the SVID to write. The configuration file rejects
+	// setting both Hint and SPIFFEID; if both are set here, Hint is ignored.
+	SPIFFEID string
+
+	// File names to store the X.509 SVID public certificate, private key
+	// and bundle in, in PEM format.
+	SVIDFilename       string
+	SVIDKeyFilename    string
+	SVIDBundleFilename string
+
+	// Permissions to use when writing the certificate and bundle files, and
+	// the key file. Default to Config.CertFileMode and Config.KeyFileMode.
+	CertFileMode fs.FileMode
+	KeyFileMode  fs.FileMode
+
+	// If true, merge intermediate certificates into the bundle file instead
+	// of the SVID file.
+	AddIntermediatesToBundle bool
+
+	// If true, includes trust domains from federated servers in the bundle
+	// file.
+	IncludeFederatedDomains bool
+}
+
+// x509SVIDs returns every X.509 SVID output: the one configured at the top
+// level, if any, then X509SVIDs, with their file modes defaulted
+func (c *Config) x509SVIDs() []X509SVIDConfig {
+	var x509SVIDs []X509SVIDConfig
+	if c.topLevelX509Enabled() {
+		x509SVIDs = append(x509SVIDs, X509SVIDConfig{
+			Hint:                     c.Hint,
+			SVIDFilename:             c.SVIDFilename,
+			SVIDKeyFilename:          c.SVIDKeyFilename,
+			SVIDBundleFilename:       c.SVIDBundleFilename,
+			CertFileMode:             c.CertFileMode,
+			KeyFileMode:              c.KeyFileMode,
+			AddIntermediatesToBundle: c.AddIntermediatesToBundle,
+			IncludeFederatedDomains:  c.IncludeFederatedDomains,
+		})
+	}
+	for _, x509SVID := range c.X509SVIDs {
+		if x509SVID.CertFileMode == 0 {
+			x509SVID.CertFileMode = c.CertFileMode
+		}
+		if x509SVID.KeyFileMode == 0 {
+			x509SVID.KeyFileMode = c.KeyFileMode
+		}
+		x509SVIDs = append(x509SVIDs, x509SVID)
+	}
+	return x509SVIDs
+}
+
+// topLevelX509Enabled reports whether the X.509 SVID output configured with
+// SVIDFilename, SVIDKeyFilename and SVIDBundleFilename is enabled
+func (c *Config) topLevelX509Enabled() bool {
+	return c.SVIDFilename != "" && c.SVIDKeyFilename != "" && c.SVIDBundleFilename != ""
+}
+
+func (c *Config) x509Enabled() bool {
+	return len(c.x509SVIDs()) > 0
+}
+
+func (c *Config) jwtBundleEnabled() bool {
+	return c.JWTBundleFilename != ""
+}
+
+// x509Files returns the paths of the X.509 SVID, key and bundle files of
+// every X.509 SVID output
+func (c *Config) x509Files() []string {
+	var x509Files []string
+	for _, x509SVID := range c.x509SVIDs() {
+		x509Files = append(x509Files, c.x509SVIDFiles(x509SVID)...)
+	}
+	return x509Files
+}
+
+// x509SVIDFiles returns the paths of the X.509 SVID, key and bundle files of
+// an X.509 SVID output
+func (c *Config) x509SVIDFiles(x509SVID X509SVIDConfig) []string {
+	return []string{
+		path.Join(c.CertDir, x509SVID.SVIDFilename),
+		path.Join(c.CertDir, x509SVID.SVIDKeyFilename),
+		path.Join(c.CertDir, x509SVID.SVIDBundleFilename),
+	}
+}
+
+// svidFiles returns the paths of the X.509 and JWT SVID files
+func (c *Config) svidFiles() []string {
+	var svidFiles []string
+	for _, x509SVID := range c.x509SVIDs() {
+		svidFiles = append(svidFiles, path.Join(c.CertDir, x509SVID.SVIDFilename))
+	}
+	for _, jwtConfig := range c.JWTSVIDs {
+		svidFiles = append(svidFiles, path.Join(c.CertDir, jwtConfig.JWTSVIDFilename))
+	}
+	return svidFiles
+}
@@ -0,0 +1,74 @@
+package sidecar
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Environment variables describing the credentials, passed to 'cmd'
+const (
+	EnvCertDir           = "SPIFFE_HELPER_CERT_DIR"
+	EnvSVIDFile          = "SPIFFE_HELPER_SVID_FILE"
+	EnvSVIDKeyFile       = "SPIFFE_HELPER_SVID_KEY_FILE"
+	EnvSVIDBundleFile    = "SPIFFE_HELPER_SVID_BUNDLE_FILE"
+	EnvJWTBundleFile     = "SPIFFE_HELPER_JWT_BUNDLE_FILE"
+	EnvJWTSVIDFilePrefix = "SPIFFE_HELPER_JWT_SVID_FILE_"
+	EnvJWTSVIDPrefix     = "SPIFFE_HELPER_JWT_SVID_"
+	EnvSPIFFEID          = "SPIFFE_HELPER_SPIFFE_ID"
+	EnvTrustDomain       = "SPIFFE_HELPER_TRUST_DOMAIN"
+	EnvSVIDExpiry        = "SPIFFE_HELPER_SVID_EXPIRY"
+)
+
+// cmdEnv builds the environment for 'cmd': the helper's own environment,
+// variables describing the credentials written so far, and CmdEnv. It is
+// only built at launch, so the credential values go stale on renewal.
+func (s *Sidecar) cmdEnv() []string {
+	config := s.cfg()
+	env := os.Environ()
+	setenv := func(key, value string) {
+		env = append(env, key+"="+value)
+	}
+
+	if config.CertDir != "" {
+		setenv(EnvCertDir, config.CertDir)
+	}
+	// The first X.509 SVID output describes the helper's identity
+	if x509SVIDs := config.x509SVIDs(); len(x509SVIDs) > 0 {
+		setenv(EnvSVIDFile, path.Join(config.CertDir, x509SVIDs[0].SVIDFilename))
+		setenv(EnvSVIDKeyFile, path.Join(config.CertDir, x509SVIDs[0].SVIDKeyFilename))
+		setenv(EnvSVIDBundleFile, path.Join(config.CertDir, x509SVIDs[0].SVIDBundleFilename))
+	}
+	if s.jwtBundleEnabled() {
+		setenv(EnvJWTBundleFile, path.Join(config.CertDir, config.JWTBundleFilename))
+	}
+
+	s.credentialsMu.RLock()
+	if x509SVID := s.x509SVID(); x509SVID != nil {
+		setenv(EnvSPIFFEID, x509SVID.ID.String())
+		setenv(EnvTrustDomain, x509SVID.ID.TrustDomain().Name())
+		setenv(EnvSVIDExpiry, x509SVID.Certificates[0].NotAfter.UTC().Format(time.RFC3339))
+	}
+	for i, jwtConfig := range config.JWTSVIDs {
+		setenv(EnvJWTSVIDFilePrefix+strconv.Itoa(i), path.Join(config.CertDir, jwtConfig.JWTSVIDFilename))
+		if jwtSVID, ok := s.jwtSVIDs[path.Join(config.CertDir, jwtConfig.JWTSVIDFilename)]; ok && config.CmdEnvIncludeJWTSVIDs {
+			setenv(EnvJWTSVIDPrefix+strconv.Itoa(i), jwtSVID.Marshal())
+		}
+	}
+	s.credentialsMu.RUnlock()
+
+	// Sorted so the environment is deterministic. Later entries take
+	// precedence over earlier ones with the same key.
+	keys := make([]string, 0, len(config.CmdEnv))
+	for key := range config.CmdEnv {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		setenv(key, config.CmdEnv[key])
+	}
+
+	return env
+}
@@ -0,0 +1,110 @@
+package sidecar
+
+import (
+	"maps"
+	"sync"
+)
+
+// healthState guards the Health of the sidecar, which is updated from the
+// X.509 watcher, the JWT bundle watcher, one goroutine per JWT SVID and
+// notifications, and tells subscribers when it changes
+type healthState struct {
+	mu          sync.RWMutex
+	health      Health
+	subscribers map[chan struct{}]struct{}
+}
+
+func newHealthState() healthState {
+	return healthState{
+		health: Health{
+			FileWriteStatuses: FileWriteStatuses{
+				JWTWriteStatus: make(map[string]string),
+			},
+			NotifyTargetStatuses: make(map[string]string),
+		},
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// snapshot returns a copy of the health that is safe to use while it is
+// being updated
+func (h *healthState) snapshot() Health {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	snapshot := Health{
+		FileWriteStatuses: FileWriteStatuses{
+			JWTWriteStatus: maps.Clone(h.health.FileWriteStatuses.JWTWriteStatus),
+		},
+		NotifyTargetStatuses: maps.Clone(h.health.NotifyTargetStatuses),
+	}
+	if h.health.FileWriteStatuses.X509WriteStatus != nil {
+		x509WriteStatus := *h.health.FileWriteStatuses.X509WriteStatus
+		snapshot.FileWriteStatuses.X509WriteStatus = &x509WriteStatus
+	}
+	return snapshot
+}
+
+func (h *healthState) setX509WriteStatus(writeStatus string) {
+	h.update(func(health *Health) {
+		health.FileWriteStatuses.X509WriteStatus = &writeStatus
+	})
+}
+
+func (h *healthState) setJWTWriteStatus(path, writeStatus string) {
+	h.update(func(health *Health) {
+		health.FileWriteStatuses.JWTWriteStatus[path] = writeStatus
+	})
+}
+
+func (h *healthState) setNotifyTargetStatus(target, notifyStatus string) {
+	h.update(func(health *Health) {
+		health.NotifyTargetStatuses[target] = notifyStatus
+	})
+}
+
+// update applies the change to the health and signals every subscriber
+func (h *healthState) update(change func(*Health)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	change(&h.health)
+	for ch := range h.subscribers {
+		// Subscribers that haven't caught up with the last change will
+		// see this one too, so there's no need to wait for them
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (h *healthState) subscribe() (<-chan struct{}, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	h.subscribers[ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers, ch)
+	}
+}
+
+// GetHealth returns a snapshot of the health of the sidecar
+func (s *Sidecar) GetHealth() Health {
+	return s.health.snapshot()
+}
+
+// SubscribeHealth returns a channel that receives a value whenever the
+// health of the sidecar is updated, i.e. a file is written or fails to be
+// written, or a notify target is signalled. Updates that arrive before the
+// subscriber has received the last one are coalesced, so subscribers should
+// read the current state with GetHealth, CheckLiveness or CheckReadiness
+// rather than count updates. Checks that depend on time passing, such as
+// ReadinessMinLifetime, can change without an update. The returned function
+// unsubscribes.
+func (s *Sidecar) SubscribeHealth() (<-chan struct{}, func()) {
+	return s.health.subscribe()
+}
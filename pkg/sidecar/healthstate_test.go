@@ -0,0 +1,94 @@
+package sidecar
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthState(t *testing.T) {
+	h := newHealthState()
+	h.setX509WriteStatus(writeStatusUnwritten)
+
+	changes, unsubscribe := h.subscribe()
+
+	// Snapshots are not affected by later updates
+	snapshot := h.snapshot()
+	h.setX509WriteStatus(writeStatusWritten)
+	h.setJWTWriteStatus("jwt.token", writeStatusFailed)
+	h.setNotifyTargetStatus("pid_file:/run/app.pid", notifyStatusSignalled)
+	assert.Equal(t, writeStatusUnwritten, *snapshot.FileWriteStatuses.X509WriteStatus)
+	assert.Empty(t, snapshot.FileWriteStatuses.JWTWriteStatus)
+	assert.Empty(t, snapshot.NotifyTargetStatuses)
+
+	// Updates the subscriber hasn't received yet are coalesced
+	select {
+	case <-changes:
+	default:
+		require.Fail(t, "subscriber not notified of update")
+	}
+	select {
+	case <-changes:
+		require.Fail(t, "updates not coalesced")
+	default:
+	}
+
+	snapshot = h.snapshot()
+	assert.Equal(t, writeStatusWritten, *snapshot.FileWriteStatuses.X509WriteStatus)
+	assert.Equal(t, map[string]string{"jwt.token": writeStatusFailed}, snapshot.FileWriteStatuses.JWTWriteStatus)
+	assert.Equal(t, map[string]string{"pid_file:/run/app.pid": notifyStatusSignalled}, snapshot.NotifyTargetStatuses)
+
+	// Unsubscribed channels are no longer notified
+	unsubscribe()
+	h.setX509WriteStatus(writeStatusFailed)
+	select {
+	case <-changes:
+		require.Fail(t, "unsubscribed channel notified")
+	default:
+	}
+}
+
+func TestHealthState_Concurrent(t *testing.T) {
+	s := newSidecarTest(t)
+	defer s.Close(t)
+	s.sidecar.config.Cmd = ""
+
+	changes, unsubscribe := s.sidecar.SubscribeHealth()
+	defer unsubscribe()
+
+	// Writers and readers race, as the watchers, JWT SVID goroutines and
+	// health server do; run with -race to check they are synchronized
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.sidecar.health.setJWTWriteStatus(fmt.Sprintf("jwt-%d.token", i), writeStatusWritten)
+				s.sidecar.health.setX509WriteStatus(writeStatusWritten)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for path, writeStatus := range s.sidecar.GetHealth().FileWriteStatuses.JWTWriteStatus {
+					assert.Equal(t, writeStatusWritten, writeStatus, path)
+				}
+				assert.True(t, s.sidecar.CheckLiveness())
+				s.sidecar.CheckReadiness()
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		require.Fail(t, "subscriber not notified of updates")
+	}
+	assert.Len(t, s.sidecar.GetHealth().FileWriteStatuses.JWTWriteStatus, 4)
+}
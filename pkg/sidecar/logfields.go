@@ -0,0 +1,50 @@
+package sidecar
+
+import (
+	"slices"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"google.golang.org/grpc/status"
+)
+
+// Fields of the log entries about credentials, named consistently so log
+// pipelines can parse rotations and failures
+const (
+	LogFieldSPIFFEID    = "spiffe_id"
+	LogFieldFile        = "file"
+	LogFieldAudience    = "audience"
+	LogFieldTrustDomain = "trust_domain"
+	LogFieldErrorCode   = "error_code"
+)
+
+// errorFields returns the error, and its gRPC status code if it came from the
+// Workload API
+func errorFields(err error) logrus.Fields {
+	fields := logrus.Fields{logrus.ErrorKey: err}
+	if st, ok := status.FromError(err); ok {
+		fields[LogFieldErrorCode] = st.Code().String()
+	}
+	return fields
+}
+
+// x509TrustDomains returns the sorted names of the trust domains in the set
+func x509TrustDomains(bundles *x509bundle.Set) []string {
+	var trustDomains []string
+	for _, bundle := range bundles.Bundles() {
+		trustDomains = append(trustDomains, bundle.TrustDomain().Name())
+	}
+	slices.Sort(trustDomains)
+	return trustDomains
+}
+
+// jwtTrustDomains returns the sorted names of the trust domains in the set
+func jwtTrustDomains(bundles *jwtbundle.Set) []string {
+	var trustDomains []string
+	for _, bundle := range bundles.Bundles() {
+		trustDomains = append(trustDomains, bundle.TrustDomain().Name())
+	}
+	slices.Sort(trustDomains)
+	return trustDomains
+}
@@ -0,0 +1,69 @@
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorFields(t *testing.T) {
+	err := status.Error(codes.PermissionDenied, "no identity issued")
+	assert.Equal(t, logrus.Fields{logrus.ErrorKey: err, LogFieldErrorCode: "PermissionDenied"}, errorFields(err))
+
+	err = errors.New("disk full")
+	assert.Equal(t, logrus.Fields{logrus.ErrorKey: err}, errorFields(err))
+}
+
+func TestSidecar_LogFields(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	log, hook := test.NewNullLogger()
+	config := s.sidecar.config
+	config.Cmd = ""
+	config.Log = log
+	config.JWTBundleFilename = "jwt_bundle.json"
+
+	findEntry := func(message string) *logrus.Entry {
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == message {
+				return entry
+			}
+		}
+		require.Failf(t, "log entry not found", "%q", message)
+		return nil
+	}
+
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+	entry := findEntry("X.509 certificates updated")
+	assert.Equal(t, exampleSpiffeID, entry.Data[LogFieldSPIFFEID])
+	assert.Equal(t, path.Join(config.CertDir, config.SVIDFilename), entry.Data[LogFieldFile])
+	assert.Equal(t, []string{"example.test"}, entry.Data[LogFieldTrustDomain])
+
+	JWTBundlesWatcher{sidecar: s.sidecar}.OnJWTBundlesUpdate(jwtbundle.NewSet(
+		jwtbundle.New(spiffeid.RequireTrustDomainFromString("example.test")),
+		jwtbundle.New(spiffeid.RequireTrustDomainFromString("federated.test")),
+	))
+	entry = findEntry("JWT bundle updated")
+	assert.Equal(t, path.Join(config.CertDir, config.JWTBundleFilename), entry.Data[LogFieldFile])
+	assert.Equal(t, []string{"example.test", "federated.test"}, entry.Data[LogFieldTrustDomain])
+
+	x509Watcher{sidecar: s.sidecar}.OnX509ContextWatchError(status.Error(codes.Unavailable, "agent restarted"))
+	entry = findEntry("Error while watching x509 context")
+	assert.Equal(t, "Unavailable", entry.Data[LogFieldErrorCode])
+}
@@ -0,0 +1,187 @@
+package sidecar
+
+import (
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"github.com/spiffe/spiffe-helper/pkg/disk"
+	"google.golang.org/grpc/status"
+)
+
+const metricsNamespace = "spiffe_helper"
+
+// metrics are the Prometheus metrics describing the credentials handled by
+// the sidecar. Each sidecar has its own registry, served by the health
+// server.
+type metrics struct {
+	registry *prometheus.Registry
+
+	x509SVIDExpiry    *prometheus.GaugeVec
+	jwtSVIDExpiry     *prometheus.GaugeVec
+	bundleAuthorities *prometheus.GaugeVec
+	rotations         *prometheus.CounterVec
+	writeFailures     *prometheus.CounterVec
+	fetchDuration     *prometheus.HistogramVec
+	fetchErrors       *prometheus.CounterVec
+	watchReconnects   *prometheus.CounterVec
+	cmdRestarts       prometheus.Counter
+	signalFailures    *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		x509SVIDExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "x509_svid_expiry_timestamp_seconds",
+			Help:      "Expiry of the X.509 SVID last written, as a Unix timestamp.",
+		}, []string{"file", "spiffe_id"}),
+		jwtSVIDExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "jwt_svid_expiry_timestamp_seconds",
+			Help:      "Expiry of the JWT SVID last written, as a Unix timestamp.",
+		}, []string{"file", "spiffe_id"}),
+		bundleAuthorities: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "bundle_authorities",
+			Help:      "Number of authorities in the bundles last written, per trust domain.",
+		}, []string{"credential_type", "trust_domain"}),
+		rotations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rotations_total",
+			Help:      "Number of times credentials were written to disk.",
+		}, []string{"credential_type"}),
+		writeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "write_failures_total",
+			Help:      "Number of times credentials could not be written to disk.",
+		}, []string{"file"}),
+		fetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "fetch_duration_seconds",
+			Help:      "Latency of Workload API fetches.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"credential_type"}),
+		fetchErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "fetch_errors_total",
+			Help:      "Number of failed Workload API fetches, by gRPC status code.",
+		}, []string{"credential_type", "code"}),
+		watchReconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "watch_reconnects_total",
+			Help:      "Number of times a Workload API watch failed and was re-established.",
+		}, []string{"credential_type"}),
+		cmdRestarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cmd_restarts_total",
+			Help:      "Number of times 'cmd' was launched again after exiting.",
+		}),
+		signalFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "signal_failures_total",
+			Help:      "Number of times a process could not be signalled.",
+		}, []string{"target"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.x509SVIDExpiry,
+		m.jwtSVIDExpiry,
+		m.bundleAuthorities,
+		m.rotations,
+		m.writeFailures,
+		m.fetchDuration,
+		m.fetchErrors,
+		m.watchReconnects,
+		m.cmdRestarts,
+		m.signalFailures,
+	)
+
+	return m
+}
+
+// Metrics returns the sidecar's Prometheus metrics
+func (s *Sidecar) Metrics() prometheus.Gatherer {
+	return s.metrics.registry
+}
+
+// observeX509Write records the outcome of writing an X.509 context to the
+// files of an X.509 SVID output. The rotation itself is recorded once per
+// update by observeX509Rotation.
+func (s *Sidecar) observeX509Write(x509SVID X509SVIDConfig, x509Context *workloadapi.X509Context, err error) {
+	config := s.cfg()
+	svidFile := path.Join(config.CertDir, x509SVID.SVIDFilename)
+	if err != nil {
+		s.metrics.writeFailures.WithLabelValues(svidFile).Inc()
+		return
+	}
+
+	if svid, err := disk.GetX509SVID(x509Context, x509SVID.Hint, x509SVID.SPIFFEID); err == nil {
+		s.metrics.x509SVIDExpiry.DeletePartialMatch(prometheus.Labels{"file": svidFile})
+		s.metrics.x509SVIDExpiry.WithLabelValues(svidFile, svid.ID.String()).Set(float64(svid.Certificates[0].NotAfter.Unix()))
+	}
+}
+
+// observeX509Rotation records an X.509 context written to at least one X.509
+// SVID output, however many there are
+func (s *Sidecar) observeX509Rotation(x509Context *workloadapi.X509Context) {
+	s.metrics.rotations.WithLabelValues(CredentialTypeX509).Inc()
+
+	s.metrics.bundleAuthorities.DeletePartialMatch(prometheus.Labels{"credential_type": CredentialTypeX509})
+	for _, bundle := range x509Context.Bundles.Bundles() {
+		s.metrics.bundleAuthorities.WithLabelValues(CredentialTypeX509, bundle.TrustDomain().Name()).Set(float64(len(bundle.X509Authorities())))
+	}
+}
+
+// observeJWTSVIDWrite records the outcome of writing a JWT SVID to disk
+func (s *Sidecar) observeJWTSVIDWrite(jwtSVIDFilename string, jwtSVIDs []*jwtsvid.SVID, err error) {
+	config := s.cfg()
+	jwtSVIDFile := path.Join(config.CertDir, jwtSVIDFilename)
+	if err != nil {
+		s.metrics.writeFailures.WithLabelValues(jwtSVIDFile).Inc()
+		return
+	}
+	s.metrics.rotations.WithLabelValues(CredentialTypeJWTSVID).Inc()
+
+	if jwtSVID, err := disk.GetJWTSVID(jwtSVIDs, config.Hint); err == nil {
+		s.metrics.jwtSVIDExpiry.DeletePartialMatch(prometheus.Labels{"file": jwtSVIDFile})
+		s.metrics.jwtSVIDExpiry.WithLabelValues(jwtSVIDFile, jwtSVID.ID.String()).Set(float64(jwtSVID.Expiry.Unix()))
+	}
+}
+
+// observeJWTBundleWrite records the outcome of writing the JWT bundles to disk
+func (s *Sidecar) observeJWTBundleWrite(jwtBundleSet *jwtbundle.Set, err error) {
+	config := s.cfg()
+	if err != nil {
+		s.metrics.writeFailures.WithLabelValues(path.Join(config.CertDir, config.JWTBundleFilename)).Inc()
+		return
+	}
+	s.metrics.rotations.WithLabelValues(CredentialTypeJWTBundle).Inc()
+
+	s.metrics.bundleAuthorities.DeletePartialMatch(prometheus.Labels{"credential_type": CredentialTypeJWTBundle})
+	for _, bundle := range jwtBundleSet.Bundles() {
+		s.metrics.bundleAuthorities.WithLabelValues(CredentialTypeJWTBundle, bundle.TrustDomain().Name()).Set(float64(len(bundle.JWTAuthorities())))
+	}
+}
+
+// forgetFile removes the series of a file that is no longer written
+func (m *metrics) forgetFile(file string) {
+	m.x509SVIDExpiry.DeletePartialMatch(prometheus.Labels{"file": file})
+	m.jwtSVIDExpiry.DeletePartialMatch(prometheus.Labels{"file": file})
+}
+
+// observeFetch records the latency and outcome of a Workload API fetch that
+// started at start
+func (s *Sidecar) observeFetch(credentialType string, start time.Time, err error) {
+	s.metrics.fetchDuration.WithLabelValues(credentialType).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.metrics.fetchErrors.WithLabelValues(credentialType, status.Code(err).String()).Inc()
+	}
+}
@@ -0,0 +1,107 @@
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/spiffe-helper/test/spiffetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSidecar_Metrics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	config := s.sidecar.config
+	config.Cmd = ""
+	config.JWTBundleFilename = "jwt_bundle.json"
+	metrics := s.sidecar.metrics
+
+	// X.509 rotations record the SVID expiry and bundle authorities
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+
+	svidFile := path.Join(config.CertDir, config.SVIDFilename)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.rotations.WithLabelValues(CredentialTypeX509)))
+	assert.Equal(t, float64(svid.svidChain[0].NotAfter.Unix()), testutil.ToFloat64(metrics.x509SVIDExpiry.WithLabelValues(svidFile, exampleSpiffeID)))
+	assert.Equal(t, float64(len(svid.bundle())), testutil.ToFloat64(metrics.bundleAuthorities.WithLabelValues(CredentialTypeX509, "example.test")))
+
+	// An update written to several X.509 SVID outputs is a single rotation,
+	// with an expiry per file
+	config.X509SVIDs = []X509SVIDConfig{
+		{SVIDFilename: "db.pem", SVIDKeyFilename: "db_key.pem", SVIDBundleFilename: "db_bundle.pem"},
+	}
+	s.MockUpdateX509Certificate(ctx, t, svid)
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.rotations.WithLabelValues(CredentialTypeX509)))
+	assert.Equal(t, float64(svid.svidChain[0].NotAfter.Unix()), testutil.ToFloat64(metrics.x509SVIDExpiry.WithLabelValues(path.Join(config.CertDir, "db.pem"), exampleSpiffeID)))
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.x509SVIDExpiry))
+
+	// JWT bundles record their authorities per trust domain
+	td := spiffeid.RequireTrustDomainFromString("example.test")
+	bundle := jwtbundle.New(td)
+	bundle.AddJWTAuthority("key-1", spiffetest.NewEC256Key(t).Public())
+	bundle.AddJWTAuthority("key-2", spiffetest.NewEC256Key(t).Public())
+	JWTBundlesWatcher{sidecar: s.sidecar}.OnJWTBundlesUpdate(jwtbundle.NewSet(bundle))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.rotations.WithLabelValues(CredentialTypeJWTBundle)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.bundleAuthorities.WithLabelValues(CredentialTypeJWTBundle, "example.test")))
+
+	// JWT SVIDs record their expiry, replacing any previous SPIFFE ID for the file
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	jwtSVID := newTestJWTSVID(t, "aud", expiry)
+	s.sidecar.observeJWTSVIDWrite("jwt.token", []*jwtsvid.SVID{jwtSVID}, nil)
+	jwtSVIDFile := path.Join(config.CertDir, "jwt.token")
+	assert.Equal(t, float64(expiry.Unix()), testutil.ToFloat64(metrics.jwtSVIDExpiry.WithLabelValues(jwtSVIDFile, exampleSpiffeID)))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.jwtSVIDExpiry))
+
+	// Write failures are counted per file
+	s.sidecar.observeJWTSVIDWrite("jwt.token", nil, errors.New("disk full"))
+	s.sidecar.observeJWTSVIDWrite("jwt.token", nil, errors.New("disk full"))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.writeFailures.WithLabelValues(jwtSVIDFile)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.rotations.WithLabelValues(CredentialTypeJWTSVID)))
+
+	// Fetches record their latency, and failures their status code
+	s.sidecar.observeFetch(CredentialTypeX509, time.Now(), nil)
+	s.sidecar.observeFetch(CredentialTypeX509, time.Now(), status.Error(codes.PermissionDenied, "no identity issued"))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.fetchDuration))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.fetchErrors.WithLabelValues(CredentialTypeX509, "PermissionDenied")))
+
+	// Watch errors are counted as reconnects, unless the watch was cancelled
+	watcher := x509Watcher{sidecar: s.sidecar}
+	watcher.OnX509ContextWatchError(status.Error(codes.Unavailable, "agent restarted"))
+	watcher.OnX509ContextWatchError(status.Error(codes.Canceled, "shutting down"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.watchReconnects.WithLabelValues(CredentialTypeX509)))
+
+	// Everything is served from the sidecar's registry
+	families, err := s.sidecar.Metrics().Gather()
+	require.NoError(t, err)
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	for _, name := range []string{
+		"spiffe_helper_x509_svid_expiry_timestamp_seconds",
+		"spiffe_helper_jwt_svid_expiry_timestamp_seconds",
+		"spiffe_helper_bundle_authorities",
+		"spiffe_helper_rotations_total",
+		"spiffe_helper_write_failures_total",
+		"spiffe_helper_fetch_duration_seconds",
+		"spiffe_helper_fetch_errors_total",
+		"spiffe_helper_watch_reconnects_total",
+		"go_goroutines",
+	} {
+		assert.True(t, names[name], "metric %s not gathered", name)
+	}
+}
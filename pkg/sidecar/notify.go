@@ -0,0 +1,359 @@
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spiffe/spiffe-helper/pkg/audit"
+	"github.com/spiffe/spiffe-helper/pkg/systemd"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Credential types that can trigger a notification target
+const (
+	CredentialTypeX509      = "x509"
+	CredentialTypeJWTSVID   = "jwt_svid"
+	CredentialTypeJWTBundle = "jwt_bundle"
+)
+
+// CredentialTypes lists every credential type that can trigger a notification target
+var CredentialTypes = []string{CredentialTypeX509, CredentialTypeJWTSVID, CredentialTypeJWTBundle}
+
+const (
+	notifyStatusUnsignalled = "unsignalled"
+	notifyStatusSignalled   = "signalled"
+	notifyStatusNoMatch     = "no_match"
+	notifyStatusFailed      = "failed"
+)
+
+var errNoMatchingProcess = errors.New("no matching process found")
+
+// NotifyTarget is an external process to notify when credentials are
+// rotated. The process is selected either by PIDFilename, or by any
+// combination of ProcessName, CmdlineRegex and CgroupPath, in which case
+// every matching process is signalled. Alternatively, a systemd unit can be
+// reloaded or restarted instead of sending a signal.
+type NotifyTarget struct {
+	// Path to a file containing the process ID to signal
+	PIDFilename string
+
+	// Executable name of the processes to signal
+	ProcessName string
+
+	// Regular expression matched against the space-separated command line
+	// of the processes to signal
+	CmdlineRegex string
+
+	// Cgroup path the processes to signal belong to, including nested cgroups
+	CgroupPath string
+
+	// systemd unit to apply SystemdUnitAction to
+	SystemdUnit string
+
+	// How to notify SystemdUnit, one of systemd.UnitActions. Defaults to
+	// reload.
+	SystemdUnitAction string
+
+	// The signal to send to the process. Not supported on Windows.
+	RenewSignal string
+
+	// The credential types whose rotation signals the process. All types
+	// trigger the signal if empty.
+	CredentialTypes []string
+}
+
+// String returns a description of how the target selects processes, how
+// they are notified and for which credential types. It is used to identify
+// the target in logs and health, so targets selecting the same processes
+// for different signals or credential types are told apart.
+func (t NotifyTarget) String() string {
+	var fields []string
+	switch {
+	case t.PIDFilename != "":
+		fields = append(fields, "pid_file_name="+t.PIDFilename)
+	case t.SystemdUnit != "":
+		fields = append(fields, "systemd_unit="+t.SystemdUnit)
+		if t.SystemdUnitAction != "" {
+			fields = append(fields, "systemd_unit_action="+t.SystemdUnitAction)
+		}
+	default:
+		if t.ProcessName != "" {
+			fields = append(fields, "process_name="+t.ProcessName)
+		}
+		if t.CmdlineRegex != "" {
+			fields = append(fields, "cmdline_regex="+t.CmdlineRegex)
+		}
+		if t.CgroupPath != "" {
+			fields = append(fields, "cgroup_path="+t.CgroupPath)
+		}
+	}
+	if t.SystemdUnit == "" && t.RenewSignal != "" {
+		fields = append(fields, "renew_signal="+t.RenewSignal)
+	}
+	if len(t.CredentialTypes) > 0 {
+		fields = append(fields, "credential_types="+strings.Join(t.CredentialTypes, "+"))
+	}
+	return strings.Join(fields, ",")
+}
+
+func (t NotifyTarget) triggeredBy(credentialType string) bool {
+	return len(t.CredentialTypes) == 0 || slices.Contains(t.CredentialTypes, credentialType)
+}
+
+// notifyCredentialUpdate is called whenever credentials of the given type
+// have been written. Notifications are sent right away unless debouncing is
+// configured, in which case updates arriving within the debounce window, or
+// before the minimum interval since the last notifications has elapsed, are
+// coalesced into a single round of notifications, whose span links to the
+// spans of the updates that caused it. The debounce window never extends
+// beyond NotifyMaxDelay after the first pending update. Pending
+// notifications are sent right away when X.509 credentials are written
+// before 'cmd' has ever been launched, so its first launch isn't delayed. A
+// short-lived 'cmd' launched again on later updates is rate limited as usual.
+func (s *Sidecar) notifyCredentialUpdate(ctx context.Context, credentialType string) {
+	config := s.cfg()
+	if config.NotifyDebounce == 0 && config.NotifyMinInterval == 0 {
+		s.sendNotifications(ctx, []string{credentialType})
+		return
+	}
+
+	s.notifyMu.Lock()
+	now := time.Now()
+	if len(s.pendingNotifications) == 0 {
+		s.pendingNotificationsFrom = now
+	}
+	if !slices.Contains(s.pendingNotifications, credentialType) {
+		s.pendingNotifications = append(s.pendingNotifications, credentialType)
+	}
+	if link := trace.LinkFromContext(ctx); link.SpanContext.IsValid() {
+		s.pendingNotificationLinks = append(s.pendingNotificationLinks, link)
+	}
+
+	if credentialType == CredentialTypeX509 && config.Cmd != "" && !s.cmdLaunched() {
+		if s.notifyTimer != nil {
+			s.notifyTimer.Stop()
+		}
+		credentialTypes, links := s.takePendingNotifications()
+		s.notifyMu.Unlock()
+		config.Log.Debug("Sending pending notifications to launch cmd")
+		s.sendNotifications(ctx, credentialTypes, links...)
+		return
+	}
+	defer s.notifyMu.Unlock()
+
+	maxDelay := config.NotifyMaxDelay
+	if maxDelay == 0 {
+		maxDelay = 10 * config.NotifyDebounce
+	}
+	sendAt := now.Add(config.NotifyDebounce)
+	if latest := s.pendingNotificationsFrom.Add(maxDelay); sendAt.After(latest) {
+		sendAt = latest
+	}
+	if earliest := s.lastNotification.Add(config.NotifyMinInterval); sendAt.Before(earliest) {
+		sendAt = earliest
+	}
+
+	if s.notifyTimer == nil {
+		s.notifyTimer = time.AfterFunc(time.Until(sendAt), s.sendPendingNotifications)
+	} else {
+		s.notifyTimer.Reset(time.Until(sendAt))
+	}
+	config.Log.Debugf("Notifications for %s credentials deferred until %s", credentialType, sendAt.Format(time.RFC3339Nano))
+}
+
+func (s *Sidecar) sendPendingNotifications() {
+	s.notifyMu.Lock()
+	credentialTypes, links := s.takePendingNotifications()
+	s.notifyMu.Unlock()
+
+	if len(credentialTypes) > 0 {
+		// The notifications start a trace of their own, as they may have
+		// been caused by several updates
+		s.sendNotifications(context.Background(), credentialTypes, links...)
+	}
+}
+
+// takePendingNotifications returns the pending notifications, which are no
+// longer pending once they are sent. notifyMu must be held.
+func (s *Sidecar) takePendingNotifications() ([]string, []trace.Link) {
+	credentialTypes := s.pendingNotifications
+	links := s.pendingNotificationLinks
+	s.pendingNotifications = nil
+	s.pendingNotificationLinks = nil
+	if len(credentialTypes) > 0 {
+		s.lastNotification = time.Now()
+	}
+	return credentialTypes, links
+}
+
+// stopNotifications drops any debounced notifications that are still pending
+func (s *Sidecar) stopNotifications() {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+
+	if s.notifyTimer != nil {
+		s.notifyTimer.Stop()
+	}
+	s.pendingNotifications = nil
+	s.pendingNotificationLinks = nil
+}
+
+// sendNotifications runs or signals 'cmd', signals pid_file_name and the
+// notification targets interested in any of the rotated credential types.
+func (s *Sidecar) sendNotifications(ctx context.Context, credentialTypes []string, links ...trace.Link) {
+	config := s.cfg()
+	ctx, span := s.tracer.Start(ctx, SpanSendNotifications,
+		trace.WithAttributes(attrCredentialTypes.StringSlice(credentialTypes)),
+		trace.WithLinks(links...),
+	)
+	defer span.End()
+
+	var results []audit.NotificationResult
+	if slices.Contains(credentialTypes, CredentialTypeX509) {
+		if config.Cmd != "" {
+			err := s.signalProcess(ctx)
+			if err != nil {
+				config.Log.WithError(err).Error("Unable to signal process")
+			}
+			results = append(results, notificationResult("cmd", err))
+		}
+
+		if config.PIDFilename != "" {
+			err := s.signalPID(ctx)
+			if err != nil {
+				config.Log.WithError(err).Error("Unable to signal PID file")
+			}
+			results = append(results, notificationResult("pid_file_name="+config.PIDFilename, err))
+		}
+
+		if config.ReloadExternalProcess != nil {
+			_, reloadSpan := s.startSpan(ctx, SpanReloadExternalProcess)
+			err := config.ReloadExternalProcess()
+			endSpan(reloadSpan, err)
+			if err != nil {
+				config.Log.WithError(err).Error("Unable to reload external process")
+			}
+			results = append(results, notificationResult("reload_external_process", err))
+		}
+	}
+
+	results = append(results, s.notifyTargets(ctx, credentialTypes)...)
+	s.auditNotifications(credentialTypes, results)
+}
+
+// notificationResult describes the outcome of notifying a target for the
+// audit log
+func notificationResult(target string, err error) audit.NotificationResult {
+	switch {
+	case errors.Is(err, errNoMatchingProcess):
+		return audit.NotificationResult{Target: target, Result: notifyStatusNoMatch}
+	case err != nil:
+		return audit.NotificationResult{Target: target, Result: notifyStatusFailed, Error: err.Error()}
+	default:
+		return audit.NotificationResult{Target: target, Result: notifyStatusSignalled}
+	}
+}
+
+// notifyTargets signals every notification target interested in any of the
+// given credential types, once per target. Failures are logged and do not
+// stop other targets from being signalled. The outcome of signalling each
+// target is returned.
+func (s *Sidecar) notifyTargets(ctx context.Context, credentialTypes []string) []audit.NotificationResult {
+	config := s.cfg()
+	var results []audit.NotificationResult
+	for _, target := range config.NotifyTargets {
+		if !slices.ContainsFunc(credentialTypes, target.triggeredBy) {
+			continue
+		}
+
+		targetCtx, span := s.startSpan(ctx, SpanNotifyTarget, attrNotifyTarget.String(target.String()))
+		pids, err := signalNotifyTarget(targetCtx, target)
+		endSpan(span, err)
+		switch {
+		case errors.Is(err, errNoMatchingProcess):
+			config.Log.WithField("notify_target", target.String()).Warn("No process matches notification target")
+			s.health.setNotifyTargetStatus(target.String(), notifyStatusNoMatch)
+		case err != nil:
+			config.Log.WithError(err).WithField("notify_target", target.String()).Error("Unable to signal notification target")
+			s.health.setNotifyTargetStatus(target.String(), notifyStatusFailed)
+			s.metrics.signalFailures.WithLabelValues(target.String()).Inc()
+		default:
+			s.health.setNotifyTargetStatus(target.String(), notifyStatusSignalled)
+		}
+		results = append(results, notificationResult(target.String(), err))
+		s.hooks.notifyTargetSignalled(target, pids, err)
+	}
+
+	return results
+}
+
+// signalNotifyTarget sends the target's signal to every process it selects
+// and returns their pids. systemd units are notified through the service
+// manager, so no pids are returned for them.
+func signalNotifyTarget(ctx context.Context, target NotifyTarget) ([]int, error) {
+	if target.SystemdUnit != "" {
+		action := target.SystemdUnitAction
+		if action == "" {
+			action = systemd.UnitActionReload
+		}
+		return nil, systemd.ApplyUnitAction(ctx, target.SystemdUnit, action)
+	}
+
+	if target.PIDFilename != "" {
+		pid, err := signalPIDFile(target.PIDFilename, target.RenewSignal)
+		if pid == 0 {
+			return nil, err
+		}
+		return []int{pid}, err
+	}
+
+	pids, err := findProcesses(target)
+	if err != nil {
+		return nil, err
+	}
+	if len(pids) == 0 {
+		return nil, errNoMatchingProcess
+	}
+
+	var errs []error
+	for _, pid := range pids {
+		pidProcess, err := os.FindProcess(pid)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to find process id %d: %w", pid, err))
+			continue
+		}
+		if err := SignalProcess(pidProcess, target.RenewSignal); err != nil {
+			errs = append(errs, fmt.Errorf("process id %d: %w", pid, err))
+		}
+	}
+
+	return pids, errors.Join(errs...)
+}
+
+// signalPIDFile reads a process ID from pidFilename and sends it renewSignal.
+// The pid is returned if it could be read, even if signalling fails.
+func signalPIDFile(pidFilename string, renewSignal string) (int, error) {
+	fileBytes, err := os.ReadFile(pidFilename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pid file \"%s\": %w", pidFilename, err)
+	}
+
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(fileBytes)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse pid file \"%s\": %w", pidFilename, err)
+	}
+
+	pidProcess, err := os.FindProcess(pid)
+	if err != nil {
+		return pid, fmt.Errorf("failed to find process id %d: %w", pid, err)
+	}
+
+	return pid, SignalProcess(pidProcess, renewSignal)
+}
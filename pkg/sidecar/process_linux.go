@@ -0,0 +1,118 @@
+//go:build linux
+// +build linux
+
+package sidecar
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// procRoot is where the proc filesystem is mounted. Tests point it at a
+// fake process tree.
+var procRoot = "/proc"
+
+// findProcesses scans the proc filesystem for processes matching all the
+// selectors set in the target. The helper's own process is never selected.
+func findProcesses(target NotifyTarget) ([]int, error) {
+	var cmdlineRegex *regexp.Regexp
+	if target.CmdlineRegex != "" {
+		var err error
+		cmdlineRegex, err = regexp.Compile(target.CmdlineRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cmdline regex %q: %w", target.CmdlineRegex, err)
+		}
+	}
+
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	self := os.Getpid()
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		// Processes may exit while being inspected, so errors reading
+		// their details just mean they don't match.
+		if matched, err := processMatches(pid, target, cmdlineRegex); err == nil && matched {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids, nil
+}
+
+func processMatches(pid int, target NotifyTarget, cmdlineRegex *regexp.Regexp) (bool, error) {
+	procDir := path.Join(procRoot, strconv.Itoa(pid))
+
+	var cmdline []string
+	if target.ProcessName != "" || cmdlineRegex != nil {
+		cmdlineBytes, err := os.ReadFile(path.Join(procDir, "cmdline"))
+		if err != nil {
+			return false, err
+		}
+		cmdline = strings.Split(string(bytes.TrimRight(cmdlineBytes, "\x00")), "\x00")
+	}
+
+	if target.ProcessName != "" {
+		comm, err := os.ReadFile(path.Join(procDir, "comm"))
+		if err != nil {
+			return false, err
+		}
+		// comm is truncated by the kernel, so the name of the executable
+		// in the command line is checked as well
+		if strings.TrimSpace(string(comm)) != target.ProcessName && filepath.Base(cmdline[0]) != target.ProcessName {
+			return false, nil
+		}
+	}
+
+	if cmdlineRegex != nil && !cmdlineRegex.MatchString(strings.Join(cmdline, " ")) {
+		return false, nil
+	}
+
+	if target.CgroupPath != "" {
+		inCgroup, err := processInCgroup(path.Join(procDir, "cgroup"), target.CgroupPath)
+		if err != nil || !inCgroup {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// processInCgroup checks whether any of the hierarchies listed in a
+// /proc/<pid>/cgroup file places the process in cgroupPath or below it.
+func processInCgroup(cgroupFile string, cgroupPath string) (bool, error) {
+	f, err := os.Open(cgroupFile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	cgroupPath = strings.TrimSuffix(cgroupPath, "/")
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Each line is hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[2] == cgroupPath || strings.HasPrefix(fields[2], cgroupPath+"/") {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,191 @@
+//go:build linux
+// +build linux
+
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindProcesses(t *testing.T) {
+	fakeProcRoot := t.TempDir()
+	writeProc := func(pid int, comm string, cmdline []string, cgroup string) {
+		procDir := path.Join(fakeProcRoot, strconv.Itoa(pid))
+		require.NoError(t, os.Mkdir(procDir, 0755))
+		var cmdlineBytes []byte
+		for _, arg := range cmdline {
+			cmdlineBytes = append(cmdlineBytes, arg...)
+			cmdlineBytes = append(cmdlineBytes, 0)
+		}
+		require.NoError(t, os.WriteFile(path.Join(procDir, "comm"), []byte(comm+"\n"), 0600))
+		require.NoError(t, os.WriteFile(path.Join(procDir, "cmdline"), cmdlineBytes, 0600))
+		require.NoError(t, os.WriteFile(path.Join(procDir, "cgroup"), []byte(cgroup), 0600))
+	}
+
+	writeProc(100, "nginx", []string{"/usr/sbin/nginx", "-g", "daemon off;"}, "0::/kubepods/pod1/nginx\n")
+	writeProc(101, "nginx", []string{"nginx: worker process"}, "0::/kubepods/pod1/nginx\n")
+	writeProc(200, "envoy-with-a-lo", []string{"/usr/local/bin/envoy-with-a-long-name", "-c", "/etc/envoy.yaml"}, "12:cpu:/kubepods/pod2\n0::/kubepods/pod2/envoy\n")
+	writeProc(300, "sleep", []string{"sleep", "1000"}, "0::/system.slice/sleep.service\n")
+	// Directories that are not processes are ignored
+	require.NoError(t, os.Mkdir(path.Join(fakeProcRoot, "sys"), 0755))
+	// The helper never signals itself
+	writeProc(os.Getpid(), "spiffe-helper", []string{"spiffe-helper"}, "0::/kubepods/pod1/nginx\n")
+
+	oldProcRoot := procRoot
+	procRoot = fakeProcRoot
+	defer func() { procRoot = oldProcRoot }()
+
+	for _, tt := range []struct {
+		name         string
+		target       NotifyTarget
+		expectedPids []int
+		expectError  string
+	}{
+		{
+			name:         "process name",
+			target:       NotifyTarget{ProcessName: "nginx"},
+			expectedPids: []int{100, 101},
+		},
+		{
+			name:         "process name truncated in comm",
+			target:       NotifyTarget{ProcessName: "envoy-with-a-long-name"},
+			expectedPids: []int{200},
+		},
+		{
+			name:         "cmdline regex",
+			target:       NotifyTarget{CmdlineRegex: `^/usr/sbin/nginx -g`},
+			expectedPids: []int{100},
+		},
+		{
+			name:         "cgroup path includes nested cgroups",
+			target:       NotifyTarget{CgroupPath: "/kubepods/pod1"},
+			expectedPids: []int{100, 101},
+		},
+		{
+			name:         "cgroup path on any hierarchy",
+			target:       NotifyTarget{CgroupPath: "/kubepods/pod2/"},
+			expectedPids: []int{200},
+		},
+		{
+			name:   "cgroup path is not a prefix match on names",
+			target: NotifyTarget{CgroupPath: "/kubepods/pod"},
+		},
+		{
+			name:         "all selectors must match",
+			target:       NotifyTarget{ProcessName: "nginx", CmdlineRegex: "worker", CgroupPath: "/kubepods"},
+			expectedPids: []int{101},
+		},
+		{
+			name:   "no match",
+			target: NotifyTarget{ProcessName: "haproxy"},
+		},
+		{
+			name:        "invalid regex",
+			target:      NotifyTarget{CmdlineRegex: "("},
+			expectError: "invalid cmdline regex \"(\"",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pids, err := findProcesses(tt.target)
+			if tt.expectError != "" {
+				require.ErrorContains(t, err, tt.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.ElementsMatch(t, tt.expectedPids, pids)
+		})
+	}
+}
+
+// A notification target selected by command line signals the matching
+// process, and a target matching nothing is reported in health.
+func TestSidecar_TestNotifyTargetsByCmdline(t *testing.T) {
+	const testsig = syscall.SIGUSR1
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// Listen for the signal forwarded by the helper process
+	sigListener := make(chan os.Signal, 1)
+	signal.Notify(sigListener, testsig)
+	defer signal.Stop(sigListener)
+
+	// Start a helper that forwards the signal to this test process. It runs
+	// as an alternate mode of this test executable; see TestMain
+	helper := exec.Command(os.Args[0], SignalHelperArg, SignalName(testsig), strconv.Itoa(os.Getpid())) // #nosec
+	helperStderr := &markerWriter{marker: "listening"}
+	helper.Stderr = helperStderr
+	require.NoError(t, helper.Start())
+	defer func() {
+		_ = helper.Process.Signal(syscall.SIGTERM)
+		_ = helper.Wait()
+	}()
+	// Wait for the helper to install its signal handler
+	require.Eventually(t, helperStderr.seen.Load, defaultTimeout, 10*time.Millisecond)
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	matchingTarget := NotifyTarget{
+		CmdlineRegex: fmt.Sprintf(" %s %s %d$", SignalHelperArg, SignalName(testsig), os.Getpid()),
+		RenewSignal:  SignalName(testsig),
+	}
+	missingTarget := NotifyTarget{
+		ProcessName: "no-such-process-for-spiffe-helper",
+		RenewSignal: SignalName(testsig),
+	}
+
+	config := s.sidecar.config
+	config.Cmd = ""
+	config.NotifyTargets = []NotifyTarget{matchingTarget, missingTarget}
+	s.sidecar.setupHealth()
+	require.Equal(t, notifyStatusUnsignalled, s.sidecar.GetHealth().NotifyTargetStatuses[missingTarget.String()])
+
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+
+	result := <-s.notifyTargetSignalledChan
+	require.NoError(t, result.err)
+	require.Equal(t, []int{helper.Process.Pid}, result.pids)
+
+	result = <-s.notifyTargetSignalledChan
+	require.ErrorIs(t, result.err, errNoMatchingProcess)
+
+	select {
+	case sig := <-sigListener:
+		require.Equal(t, testsig, sig)
+	case <-ctx.Done():
+		require.NoError(t, ctx.Err())
+	}
+
+	health := s.sidecar.GetHealth()
+	require.Equal(t, notifyStatusSignalled, health.NotifyTargetStatuses[matchingTarget.String()])
+	require.Equal(t, notifyStatusNoMatch, health.NotifyTargetStatuses[missingTarget.String()])
+}
+
+// markerWriter passes what is written to stderr and records whether marker
+// has been seen
+type markerWriter struct {
+	marker string
+	seen   atomic.Bool
+}
+
+func (w *markerWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte(w.marker)) {
+		w.seen.Store(true)
+	}
+	return os.Stderr.Write(p)
+}
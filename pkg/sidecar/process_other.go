@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package sidecar
+
+import "errors"
+
+func findProcesses(NotifyTarget) ([]int, error) {
+	return nil, errors.New("selecting processes by name, command line or cgroup is only supported on linux")
+}
@@ -0,0 +1,114 @@
+package sidecar
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// reloadRequest asks RunDaemon to apply a new configuration, and carries the
+// outcome back to Reload
+type reloadRequest struct {
+	config *Config
+	result chan error
+}
+
+// cfg returns the current configuration
+func (s *Sidecar) cfg() *Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.config
+}
+
+func (s *Sidecar) setConfig(config *Config) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.config = config
+}
+
+// Reload applies a new configuration to the running daemon. The watchers are
+// restarted with it, so added outputs are written, outputs whose paths or
+// modes changed are rewritten, and JWT SVIDs that were removed are no longer
+// refreshed. 'cmd' keeps running. Notifications still pending are dropped, as
+// the credentials are written again once the watchers restart.
+//
+// TracerProvider, CmdStdin, CmdStdout and CmdStderr are only read when the
+// sidecar is created, and are ignored. Reload must only be called while
+// RunDaemon is running. If the configuration can't be applied, an error is
+// returned and the sidecar carries on with the previous one.
+func (s *Sidecar) Reload(ctx context.Context, config *Config) error {
+	result := make(chan error, 1)
+	select {
+	case s.reloads <- reloadRequest{config: config, result: result}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// applyConfig replaces the configuration while the watchers are stopped
+func (s *Sidecar) applyConfig(ctx context.Context, config *Config) error {
+	previous := s.cfg()
+	s.stopNotifications()
+
+	s.setConfig(config)
+	client, jwtSource, err := s.newClients(ctx)
+	if err != nil {
+		s.setConfig(previous)
+		return fmt.Errorf("unable to connect to the Workload API: %w", err)
+	}
+	s.closeClients()
+	s.client = client
+	s.jwtSource = jwtSource
+
+	s.forgetRemovedOutputs(previous)
+	if config.x509Enabled() && !slices.Equal(previous.x509Files(), config.x509Files()) {
+		s.health.setX509WriteStatus(writeStatusUnwritten)
+	}
+	s.setupHealth()
+	s.setupStatus()
+
+	config.Log.Info("Configuration applied")
+	return nil
+}
+
+// forgetRemovedOutputs drops the credentials and metrics of the SVID files
+// that the previous configuration wrote and the current one doesn't, so they
+// no longer count towards health checks
+func (s *Sidecar) forgetRemovedOutputs(previous *Config) {
+	config := s.cfg()
+	svidFiles := config.svidFiles()
+
+	s.credentialsMu.Lock()
+	if !config.x509Enabled() {
+		s.x509Bundles = nil
+	}
+	for svidFile := range s.x509SVIDs {
+		if !slices.Contains(svidFiles, svidFile) {
+			delete(s.x509SVIDs, svidFile)
+		}
+	}
+	for svidFile := range s.jwtSVIDs {
+		if !slices.Contains(svidFiles, svidFile) {
+			delete(s.jwtSVIDs, svidFile)
+		}
+	}
+	for svidFile := range s.lastUpdates {
+		if !slices.Contains(svidFiles, svidFile) {
+			delete(s.lastUpdates, svidFile)
+		}
+	}
+	s.credentialsMu.Unlock()
+
+	for _, svidFile := range previous.svidFiles() {
+		if !slices.Contains(svidFiles, svidFile) {
+			s.metrics.forgetFile(svidFile)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package sidecar
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSidecar_ApplyConfig(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	config := s.sidecar.config
+	config.Cmd = ""
+	config.AgentAddress = path.Join(t.TempDir(), "agent.sock")
+	config.JWTBundleFilename = "jwt_bundle.json"
+	config.JWTSVIDs = []JWTConfig{{JWTAudience: "aud", JWTSVIDFilename: "jwt.token"}}
+	config.NotifyTargets = []NotifyTarget{{PIDFilename: "removed.pid", RenewSignal: "SIGHUP"}}
+	s.sidecar.setupHealth()
+	s.sidecar.setupStatus()
+
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+	jwtSVID := newTestJWTSVID(t, "aud", time.Now().Add(time.Hour))
+	for _, jwtConfig := range config.JWTSVIDs {
+		jwtSVIDPath := path.Join(config.CertDir, jwtConfig.JWTSVIDFilename)
+		s.sidecar.recordJWTSVIDWrite(jwtConfig.JWTSVIDFilename, []*jwtsvid.SVID{jwtSVID}, nil)
+		s.sidecar.health.setJWTWriteStatus(jwtSVIDPath, writeStatusWritten)
+		s.sidecar.jwtSVIDs[jwtSVIDPath] = jwtSVID
+		s.sidecar.lastUpdates[jwtSVIDPath] = time.Now()
+	}
+	s.sidecar.recordJWTBundleWrite(jwtbundle.NewSet(), nil)
+	s.sidecar.health.setJWTWriteStatus(path.Join(config.CertDir, "jwt_bundle.json"), writeStatusWritten)
+
+	// A configuration that can't be applied leaves the running one in place.
+	// The JWT source can't be created, as nothing serves the Workload API.
+	failing := *config
+	failing.JWTSVIDs = append(failing.JWTSVIDs, JWTConfig{JWTAudience: "other", JWTSVIDFilename: "added.token"})
+	failCtx, failCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer failCancel()
+	err := s.sidecar.applyConfig(failCtx, &failing)
+	require.ErrorContains(t, err, "unable to connect to the Workload API")
+	assert.Same(t, config, s.sidecar.cfg())
+
+	// JWT SVIDs can be dropped, and the X.509 SVID moved
+	newConfig := *config
+	newConfig.SVIDFilename = "new_svid.pem"
+	newConfig.JWTSVIDs = nil
+	newConfig.NotifyTargets = []NotifyTarget{{PIDFilename: "added.pid", RenewSignal: "SIGUSR1"}}
+	require.NoError(t, s.sidecar.applyConfig(ctx, &newConfig))
+	defer s.sidecar.closeClients()
+	assert.Same(t, &newConfig, s.sidecar.cfg())
+
+	// The X.509 SVID has to be written to its new path before it is healthy
+	// again, while the JWT bundle that is still configured stays written
+	jwtBundlePath := path.Join(config.CertDir, "jwt_bundle.json")
+	health := s.sidecar.GetHealth()
+	assert.Equal(t, writeStatusUnwritten, *health.FileWriteStatuses.X509WriteStatus)
+	assert.Equal(t, map[string]string{jwtBundlePath: writeStatusWritten}, health.FileWriteStatuses.JWTWriteStatus)
+	assert.Equal(t, map[string]string{
+		newConfig.NotifyTargets[0].String(): notifyStatusUnsignalled,
+	}, health.NotifyTargetStatuses)
+
+	status := s.sidecar.Status()
+	assert.Equal(t, path.Join(config.CertDir, "new_svid.pem"), status.X509SVID.File)
+	assert.Nil(t, status.X509SVID.LastWrite)
+	assert.Empty(t, status.JWTSVIDs)
+	assert.NotNil(t, status.JWTBundle.LastWrite)
+
+	assert.Empty(t, s.sidecar.jwtSVIDs)
+	assert.Empty(t, s.sidecar.lastUpdates)
+}
+
+// Moving cert_dir forgets the JWT SVIDs written to the previous one, so
+// health and status don't report tokens that are no longer written
+func TestSidecar_ForgetRemovedOutputsCertDir(t *testing.T) {
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	config := s.sidecar.config
+	config.JWTSVIDs = []JWTConfig{{JWTAudience: "aud", JWTSVIDFilename: "jwt.token"}}
+	oldPath := path.Join(config.CertDir, "jwt.token")
+	s.sidecar.jwtSVIDs[oldPath] = newTestJWTSVID(t, "aud", time.Now().Add(time.Hour))
+	s.sidecar.lastUpdates[oldPath] = time.Now()
+
+	newConfig := *config
+	newConfig.CertDir = t.TempDir()
+	s.sidecar.config = &newConfig
+	s.sidecar.forgetRemovedOutputs(config)
+
+	assert.Empty(t, s.sidecar.jwtSVIDs)
+	assert.Empty(t, s.sidecar.lastUpdates)
+}
@@ -1,65 +1,117 @@
 package sidecar
 
 import (
-	"bytes"
 	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
 	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
 	"github.com/spiffe/go-spiffe/v2/workloadapi"
 	"github.com/spiffe/spiffe-helper/pkg/disk"
 	"github.com/spiffe/spiffe-helper/pkg/util"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 // Event hooks used by unit tests to coordinate goroutines
 type hooks struct {
-	certReady        func(svids *workloadapi.X509Context)
-	cmdExit          func(os.ProcessState)
-	pidFileSignalled func(pid int, err error)
+	certReady             func(svids *workloadapi.X509Context)
+	cmdExit               func(os.ProcessState)
+	pidFileSignalled      func(pid int, err error)
+	notifyTargetSignalled func(target NotifyTarget, pids []int, err error)
 }
 
 // Sidecar is the component that consumes the Workload API and renews certs
 type Sidecar struct {
+	// The configuration is replaced as a whole by Reload, so it must be
+	// read through cfg()
 	config         *Config
+	configMu       sync.RWMutex
 	client         *workloadapi.Client
 	jwtSource      *workloadapi.JWTSource
 	processRunning bool
 	process        *os.Process
+	cmd            *exec.Cmd
 
-	// Mutex to protect processRunning
-	mu sync.Mutex
+	// Closed once the running process has exited and been reaped
+	processExited chan struct{}
+
+	// Set once the helper is shutting down, so 'cmd' is not launched again
+	stopping bool
 
-	// Health server
-	health Health
+	// Mutex to protect processRunning and stopping
+	mu sync.Mutex
 
-	// stdio to connect to the 'cmd' to run. These are used in tests to
-	// capture and/or redirect I/O from the guest command. In future they
-	// could also be exposed via Config to allow a user of this package to
-	// redirect I/O in custom sidecars. These have the same semantics as
-	// https://pkg.go.dev/os/exec#Cmd
+	// Credential types whose notifications are being debounced, links to
+	// the spans of the updates that caused them, when the first of them was
+	// received, the timer that sends them and when notifications were last
+	// sent
+	pendingNotifications     []string
+	pendingNotificationLinks []trace.Link
+	pendingNotificationsFrom time.Time
+	notifyTimer              *time.Timer
+	lastNotification         time.Time
+	notifyMu                 sync.Mutex
+
+	// The last X.509 SVIDs, X.509 bundles and JWT SVIDs written to disk.
+	// SVIDs are keyed by their path in CertDir.
+	x509SVIDs     map[string]*x509svid.SVID
+	x509Bundles   *x509bundle.Set
+	jwtSVIDs      map[string]*jwtsvid.SVID
+	credentialsMu sync.RWMutex
+
+	// When each SVID file was last written successfully, keyed by path, and
+	// when the sidecar was created, which stands in for files not written yet
+	lastUpdates map[string]time.Time
+	started     time.Time
+
+	// Health of the files written and notify targets signalled
+	health healthState
+
+	// Prometheus metrics
+	metrics *metrics
+
+	// Traces credential updates and notifications
+	tracer trace.Tracer
+
+	// Details of the credentials last written to each output
+	outputs   Status
+	outputsMu sync.RWMutex
+
+	// stdio to connect to the 'cmd' to run, from Config.CmdStdin, CmdStdout
+	// and CmdStderr or the helper's own stdio. Tests replace them to
+	// capture and/or redirect I/O from the guest command. These have the
+	// same semantics as https://pkg.go.dev/os/exec#Cmd, except for
+	// StdinNull.
 	stdin  io.Reader
 	stdout io.Writer
 	stderr io.Writer
 
+	// Configurations to apply with Reload, received by RunDaemon
+	reloads chan reloadRequest
+
 	// Used for synchronization in unit tests
 	hooks hooks
 }
 
 type Health struct {
-	FileWriteStatuses FileWriteStatuses `json:"file_write_statuses"`
+	FileWriteStatuses    FileWriteStatuses `json:"file_write_statuses"`
+	NotifyTargetStatuses map[string]string `json:"notify_target_statuses,omitempty"`
 }
 
 type FileWriteStatuses struct {
@@ -67,6 +119,14 @@ type FileWriteStatuses struct {
 	JWTWriteStatus  map[string]string `json:"jwt_write_status"`
 }
 
+const (
+	// How long to wait for the output of 'cmd' to be copied once it has exited
+	cmdWaitDelay = 5 * time.Second
+
+	defaultCmdStopSignal  = "SIGTERM"
+	defaultCmdStopTimeout = 10 * time.Second
+)
+
 const (
 	writeStatusUnwritten = "unwritten"
 	writeStatusFailed    = "failed"
@@ -76,66 +136,130 @@ const (
 // New creates a new SPIFFE sidecar
 func New(config *Config) *Sidecar {
 	s := &Sidecar{
-		config: config,
-		health: Health{
-			FileWriteStatuses: FileWriteStatuses{
-				JWTWriteStatus: make(map[string]string),
-			},
-		},
-		stdin:  os.Stdin,
-		stdout: os.Stdout,
-		stderr: os.Stderr,
+		config:      config,
+		health:      newHealthState(),
+		x509SVIDs:   make(map[string]*x509svid.SVID),
+		jwtSVIDs:    make(map[string]*jwtsvid.SVID),
+		lastUpdates: make(map[string]time.Time),
+		started:     time.Now(),
+		metrics:     newMetrics(),
+		tracer:      newTracer(config.TracerProvider),
+		reloads:     make(chan reloadRequest),
+		stdin:       os.Stdin,
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
 		hooks: hooks{
-			certReady:        func(*workloadapi.X509Context) {},
-			cmdExit:          func(os.ProcessState) {},
-			pidFileSignalled: func(int, error) {},
+			certReady:             func(*workloadapi.X509Context) {},
+			cmdExit:               func(os.ProcessState) {},
+			pidFileSignalled:      func(int, error) {},
+			notifyTargetSignalled: func(NotifyTarget, []int, error) {},
 		},
 	}
 
+	if config.CmdStdin != nil {
+		s.stdin = config.CmdStdin
+	}
+	if config.CmdStdout != nil {
+		s.stdout = config.CmdStdout
+	}
+	if config.CmdStderr != nil {
+		s.stderr = config.CmdStderr
+	}
+
 	s.setupHealth()
+	s.setupStatus()
 	return s
 }
 
+// setupHealth marks the outputs and notification targets that have no health
+// yet as unwritten and unsignalled, and drops those no longer configured
 func (s *Sidecar) setupHealth() {
-	if s.x509Enabled() {
-		writeStatus := writeStatusUnwritten
-		s.health.FileWriteStatuses.X509WriteStatus = &writeStatus
-	}
-	if s.jwtBundleEnabled() {
-		jwtBundleFilePath := path.Join(s.config.CertDir, s.config.JWTBundleFilename)
-		s.health.FileWriteStatuses.JWTWriteStatus[jwtBundleFilePath] = writeStatusUnwritten
-	}
-	for _, jwtConfig := range s.config.JWTSVIDs {
-		jwtSVIDFilename := path.Join(s.config.CertDir, jwtConfig.JWTSVIDFilename)
-		s.health.FileWriteStatuses.JWTWriteStatus[jwtSVIDFilename] = writeStatusUnwritten
-	}
+	config := s.cfg()
+	var jwtPaths []string
+	if config.jwtBundleEnabled() {
+		jwtPaths = append(jwtPaths, path.Join(config.CertDir, config.JWTBundleFilename))
+	}
+	for _, jwtConfig := range config.JWTSVIDs {
+		jwtPaths = append(jwtPaths, path.Join(config.CertDir, jwtConfig.JWTSVIDFilename))
+	}
+	var targets []string
+	for _, target := range config.NotifyTargets {
+		targets = append(targets, target.String())
+	}
+
+	s.health.update(func(health *Health) {
+		switch {
+		case !config.x509Enabled():
+			health.FileWriteStatuses.X509WriteStatus = nil
+		case health.FileWriteStatuses.X509WriteStatus == nil:
+			writeStatus := writeStatusUnwritten
+			health.FileWriteStatuses.X509WriteStatus = &writeStatus
+		}
+
+		maps.DeleteFunc(health.FileWriteStatuses.JWTWriteStatus, func(jwtPath string, _ string) bool {
+			return !slices.Contains(jwtPaths, jwtPath)
+		})
+		for _, jwtPath := range jwtPaths {
+			if _, ok := health.FileWriteStatuses.JWTWriteStatus[jwtPath]; !ok {
+				health.FileWriteStatuses.JWTWriteStatus[jwtPath] = writeStatusUnwritten
+			}
+		}
+
+		maps.DeleteFunc(health.NotifyTargetStatuses, func(target string, _ string) bool {
+			return !slices.Contains(targets, target)
+		})
+		for _, target := range targets {
+			if _, ok := health.NotifyTargetStatuses[target]; !ok {
+				health.NotifyTargetStatuses[target] = notifyStatusUnsignalled
+			}
+		}
+	})
 }
 
-// RunDaemon starts the main loop
+// RunDaemon starts the main loop. The watchers are restarted whenever a new
+// configuration is applied with Reload.
 func (s *Sidecar) RunDaemon(ctx context.Context) error {
 	if err := s.setupClients(ctx); err != nil {
 		return err
 	}
-	if s.client != nil {
-		defer s.client.Close()
-	}
-	if s.jwtSource != nil {
-		defer s.jwtSource.Close()
+	defer s.closeClients()
+	defer s.stopNotifications()
+
+	var err error
+	for {
+		var reload *reloadRequest
+		reload, err = s.runDaemonTasks(ctx)
+		if reload == nil {
+			break
+		}
+		reload.result <- s.applyConfig(ctx, reload.config)
 	}
 
+	// Hold the return until 'cmd' has been reaped, so that the helper
+	// doesn't exit leaving it orphaned
+	s.stopNotifications()
+	s.stopProcess()
+
+	return err
+}
+
+// runDaemonTasks watches for credentials with the current configuration until
+// ctx is done, a watcher fails or a reload is requested, which is returned
+func (s *Sidecar) runDaemonTasks(ctx context.Context) (*reloadRequest, error) {
+	config := s.cfg()
 	var tasks []func(context.Context) error
 
-	if s.config.ParallelRequests > 0 {
-		s.config.Log.Info("Starting in continuous parallel request mode")
+	if config.ParallelRequests > 0 {
+		config.Log.Info("Starting in continuous parallel request mode")
 		tasks = append(tasks, s.runParallelDaemon)
 	} else {
-		s.config.Log.Info("Starting in standard daemon mode")
+		config.Log.Info("Starting in standard daemon mode")
 		if s.x509Enabled() {
-			s.config.Log.Info("Watching for X509 Context")
+			config.Log.Info("Watching for X509 Context")
 			tasks = append(tasks, s.watchX509Context)
 		}
 		if s.jwtBundleEnabled() {
-			s.config.Log.Info("Watching for JWT Bundles")
+			config.Log.Info("Watching for JWT Bundles")
 			tasks = append(tasks, s.watchJWTBundles)
 		}
 		if s.jwtSVIDsEnabled() {
@@ -143,29 +267,39 @@ func (s *Sidecar) RunDaemon(ctx context.Context) error {
 		}
 	}
 
-	err := util.RunTasks(ctx, tasks...)
-	if err != nil && !errors.Is(err, context.Canceled) {
-		return nil
-	}
+	tasksCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- util.RunTasks(tasksCtx, tasks...)
+	}()
 
-	return err
+	select {
+	case err := <-done:
+		return nil, err
+	case reload := <-s.reloads:
+		cancel()
+		<-done
+		return &reload, nil
+	}
 }
 
 func (s *Sidecar) runParallelDaemon(ctx context.Context) error {
+	config := s.cfg()
 	var wg sync.WaitGroup
 
-	for i := 0; i < s.config.ParallelRequests; i++ {
+	for i := 0; i < config.ParallelRequests; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			s.config.Log.Debugf("Starting parallel worker %d", workerID)
+			config.Log.Debugf("Starting parallel worker %d", workerID)
 
 			for {
 				_ = s.fetchAllCredentials(ctx)
 
 				select {
 				case <-ctx.Done():
-					s.config.Log.Debugf("Stopping parallel worker %d", workerID)
+					config.Log.Debugf("Stopping parallel worker %d", workerID)
 					return
 				default:
 				}
@@ -175,139 +309,217 @@ func (s *Sidecar) runParallelDaemon(ctx context.Context) error {
 
 	<-ctx.Done()
 
-	s.config.Log.Info("Shutdown signal received, waiting for parallel workers to stop...")
+	config.Log.Info("Shutdown signal received, waiting for parallel workers to stop...")
 	wg.Wait()
-	s.config.Log.Info("All parallel workers stopped.")
+	config.Log.Info("All parallel workers stopped.")
 
 	return nil
 }
 
 func (s *Sidecar) Run(ctx context.Context) error {
+	config := s.cfg()
 	if err := s.setupClients(ctx); err != nil {
 		return err
 	}
-	if s.client != nil {
-		defer s.client.Close()
-	}
-	if s.jwtSource != nil {
-		defer s.jwtSource.Close()
-	}
+	defer s.closeClients()
 
-	if s.config.ParallelRequests > 0 {
-		s.config.Log.Infof("Running a burst of %d parallel requests", s.config.ParallelRequests)
-		return util.RunTasksInParallel(ctx, s.fetchAllCredentials, s.config.ParallelRequests)
+	if config.ParallelRequests > 0 {
+		config.Log.Infof("Running a burst of %d parallel requests", config.ParallelRequests)
+		return util.RunTasksInParallel(ctx, s.fetchAllCredentials, config.ParallelRequests)
 	}
 
 	return s.fetchAllCredentials(ctx)
 }
 
-func (s *Sidecar) fetchAllCredentials(ctx context.Context) error {
+func (s *Sidecar) fetchAllCredentials(ctx context.Context) (err error) {
+	config := s.cfg()
+	ctx, span := s.startSpan(ctx, SpanFetchAllCredentials)
+	defer func() { endSpan(span, err) }()
+
 	if s.x509Enabled() {
-		s.config.Log.Debug("Fetching x509 certificates")
+		config.Log.Debug("Fetching x509 certificates")
 		if err := s.fetchAndWriteX509Context(ctx); err != nil {
-			s.config.Log.WithError(err).Error("Error fetching x509 certificates")
+			config.Log.WithFields(errorFields(err)).Error("Error fetching x509 certificates")
 			return err
 		}
-		s.config.Log.Info("Successfully fetched x509 certificates")
+		config.Log.Info("Successfully fetched x509 certificates")
 	}
 
 	if s.jwtBundleEnabled() {
-		s.config.Log.Debug("Fetching JWT Bundle")
+		config.Log.Debug("Fetching JWT Bundle")
 		if err := s.fetchAndWriteJWTBundle(ctx); err != nil {
-			s.config.Log.WithError(err).Error("Error fetching JWT bundle")
+			config.Log.WithFields(errorFields(err)).Error("Error fetching JWT bundle")
 			return err
 		}
-		s.config.Log.Info("Successfully fetched JWT bundle")
+		config.Log.Info("Successfully fetched JWT bundle")
 	}
 
 	if s.jwtSVIDsEnabled() {
-		s.config.Log.Debug("Fetching JWT SVIDs")
+		config.Log.Debug("Fetching JWT SVIDs")
 		if err := s.fetchAndWriteJWTSVIDs(ctx); err != nil {
-			s.config.Log.WithError(err).Error("Error fetching JWT SVIDs")
+			config.Log.WithFields(errorFields(err)).Error("Error fetching JWT SVIDs")
 			return err
 		}
-		s.config.Log.Info("Successfully fetched JWT SVIDs")
+		config.Log.Info("Successfully fetched JWT SVIDs")
 	}
 
 	return nil
 }
 
 func (s *Sidecar) setupClients(ctx context.Context) error {
+	client, jwtSource, err := s.newClients(ctx)
+	if err != nil {
+		return err
+	}
+	s.client = client
+	s.jwtSource = jwtSource
+	return nil
+}
+
+// newClients connects to the Workload API for the credentials enabled in the
+// current configuration
+func (s *Sidecar) newClients(ctx context.Context) (*workloadapi.Client, *workloadapi.JWTSource, error) {
+	var client *workloadapi.Client
 	if s.x509Enabled() || s.jwtBundleEnabled() {
-		client, err := workloadapi.New(ctx, s.getWorkloadAPIAddress())
+		var err error
+		client, err = workloadapi.New(ctx, s.getWorkloadAPIAddress())
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		s.client = client
 	}
 
+	var jwtSource *workloadapi.JWTSource
 	if s.jwtSVIDsEnabled() {
-		jwtSource, err := workloadapi.NewJWTSource(ctx, workloadapi.WithClientOptions(s.getWorkloadAPIAddress()))
+		var err error
+		jwtSource, err = workloadapi.NewJWTSource(ctx, workloadapi.WithClientOptions(s.getWorkloadAPIAddress()))
 		if err != nil {
-			return err
+			if client != nil {
+				client.Close()
+			}
+			return nil, nil, err
 		}
-		s.jwtSource = jwtSource
 	}
 
-	return nil
+	return client, jwtSource, nil
 }
 
-func (s *Sidecar) updateCertificates(svidResponse *workloadapi.X509Context) {
-	s.config.Log.Debug("Updating X.509 certificates")
-	if err := disk.WriteX509Context(svidResponse, s.config.AddIntermediatesToBundle, s.config.IncludeFederatedDomains, s.config.CertDir, s.config.SVIDFilename, s.config.SVIDKeyFilename, s.config.SVIDBundleFilename, s.config.CertFileMode, s.config.KeyFileMode, s.config.Hint); err != nil {
-		s.config.Log.WithError(err).Error("Unable to dump bundle")
-		writeStatus := writeStatusFailed
-		s.health.FileWriteStatuses.X509WriteStatus = &writeStatus
-		return
+func (s *Sidecar) closeClients() {
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
 	}
-	writeStatus := writeStatusWritten
-	s.health.FileWriteStatuses.X509WriteStatus = &writeStatus
-	s.config.Log.Info("X.509 certificates updated")
-
-	if s.config.Cmd != "" {
-		if err := s.signalProcess(); err != nil {
-			s.config.Log.WithError(err).Error("Unable to signal process")
+	if s.jwtSource != nil {
+		s.jwtSource.Close()
+		s.jwtSource = nil
+	}
+}
+
+func (s *Sidecar) updateCertificates(ctx context.Context, svidResponse *workloadapi.X509Context) {
+	config := s.cfg()
+	ctx, span := s.startSpan(ctx, SpanUpdateCertificates)
+	var err error
+	defer func() { endSpan(span, err) }()
+
+	config.Log.Debug("Updating X.509 certificates")
+	var errs []error
+	written := 0
+	for _, x509SVID := range config.x509SVIDs() {
+		svidPath := path.Join(config.CertDir, x509SVID.SVIDFilename)
+		if err := s.writeX509SVID(ctx, x509SVID, svidResponse); err != nil {
+			config.Log.WithFields(errorFields(err)).WithField(LogFieldFile, svidPath).Error("Unable to dump bundle")
+			errs = append(errs, err)
+			continue
 		}
-	}
+		written++
+
+		log := config.Log.WithFields(logrus.Fields{
+			LogFieldFile:        svidPath,
+			LogFieldTrustDomain: x509TrustDomains(svidResponse.Bundles),
+		})
+		svid, svidErr := disk.GetX509SVID(svidResponse, x509SVID.Hint, x509SVID.SPIFFEID)
+		if svidErr == nil {
+			log = log.WithField(LogFieldSPIFFEID, svid.ID.String())
+			span.SetAttributes(attrSPIFFEID.String(svid.ID.String()))
+		}
+		log.Info("X.509 certificates updated")
 
-	if s.config.PIDFilename != "" {
-		if err := s.signalPID(); err != nil {
-			s.config.Log.WithError(err).Error("Unable to signal PID file")
+		s.credentialsMu.Lock()
+		s.lastUpdates[svidPath] = time.Now()
+		if svid != nil {
+			s.x509SVIDs[svidPath] = svid
 		}
+		s.credentialsMu.Unlock()
+	}
+	err = errors.Join(errs...)
+	if written == 0 {
+		s.health.setX509WriteStatus(writeStatusFailed)
+		return
 	}
 
-	if s.config.ReloadExternalProcess != nil {
-		if err := s.config.ReloadExternalProcess(); err != nil {
-			s.config.Log.WithError(err).Error("Unable to reload external process")
-		}
+	s.credentialsMu.Lock()
+	s.x509Bundles = svidResponse.Bundles
+	s.credentialsMu.Unlock()
+	s.observeX509Rotation(svidResponse)
+
+	// Updated once the SVIDs are stored, so subscribers see their new
+	// expiry. The outputs that were written are still notified when others
+	// failed.
+	if err != nil {
+		s.health.setX509WriteStatus(writeStatusFailed)
+	} else {
+		s.health.setX509WriteStatus(writeStatusWritten)
 	}
 
+	s.notifyCredentialUpdate(ctx, CredentialTypeX509)
+
 	s.hooks.certReady(svidResponse)
 }
 
-func (s *Sidecar) signalProcess() error {
+func (s *Sidecar) signalProcess(ctx context.Context) (err error) {
+	config := s.cfg()
+	_, span := s.startSpan(ctx, SpanSignalCmd)
+	defer func() { endSpan(span, err) }()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.stopping {
+		return errors.New("not running cmd while shutting down")
+	}
+
+	span.SetAttributes(attrCmdLaunched.Bool(!s.processRunning))
 	if !s.processRunning {
-		cmdArgs, err := getCmdArgs(s.config.CmdArgs)
+		if s.process != nil {
+			s.metrics.cmdRestarts.Inc()
+		}
+
+		cmdArgs, err := s.cmdArgs()
 		if err != nil {
 			return fmt.Errorf("error parsing cmd arguments: %w", err)
 		}
 
-		cmd := exec.Command(s.config.Cmd, cmdArgs...) // #nosec
-
+		cmd := exec.Command(config.Cmd, cmdArgs...) // #nosec
+		cmd.Env = s.cmdEnv()
 		cmd.Stdin = s.stdin
+		if s.stdin == StdinNull {
+			cmd.Stdin = nil
+		}
 		cmd.Stdout = s.stdout
 		cmd.Stderr = s.stderr
+		// Don't wait forever for output from any of its children still
+		// holding stdout or stderr open after the process exits
+		cmd.WaitDelay = cmdWaitDelay
 		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("error executing process \"%v\": %w", s.config.Cmd, err)
+			return fmt.Errorf("error executing process \"%v\": %w", config.Cmd, err)
 		}
+		s.cmd = cmd
 		s.process = cmd.Process
+		s.processExited = make(chan struct{})
 		s.processRunning = true
 		go s.checkProcessExit()
 	} else {
-		if err := SignalProcess(s.process, s.config.RenewSignal); err != nil {
+		if err := SignalProcess(s.process, config.RenewSignal); err != nil {
+			s.metrics.signalFailures.WithLabelValues("cmd").Inc()
 			return err
 		}
 	}
@@ -315,60 +527,140 @@ func (s *Sidecar) signalProcess() error {
 	return nil
 }
 
-func (s *Sidecar) signalPID() error {
-	pid, err := func() (int, error) {
-		fileBytes, err := os.ReadFile(s.config.PIDFilename)
-		if err != nil {
-			return 0, fmt.Errorf("failed to read pid file \"%s\": %w", s.config.PIDFilename, err)
-		}
-
-		pid, err := strconv.Atoi(string(bytes.TrimSpace(fileBytes)))
-		if err != nil {
-			return 0, fmt.Errorf("failed to parse pid file \"%s\": %w", s.config.PIDFilename, err)
-		}
-
-		pidProcess, err := os.FindProcess(pid)
-		if err != nil {
-			return pid, fmt.Errorf("failed to find process id %d: %w", pid, err)
-		}
-
-		return pid, SignalProcess(pidProcess, s.config.RenewSignal)
-	}()
+func (s *Sidecar) signalPID(ctx context.Context) error {
+	config := s.cfg()
+	_, span := s.startSpan(ctx, SpanSignalPIDFile, attrFiles.StringSlice([]string{config.PIDFilename}))
+	pid, err := signalPIDFile(config.PIDFilename, config.RenewSignal)
+	endSpan(span, err)
+	if err != nil {
+		s.metrics.signalFailures.WithLabelValues("pid_file_name=" + config.PIDFilename).Inc()
+	}
 	s.hooks.pidFileSignalled(pid, err)
 	return err
 }
 
 func (s *Sidecar) checkProcessExit() {
+	config := s.cfg()
 	s.mu.Lock()
 	if !s.processRunning {
 		panic("checkProcessExit called with no process running")
 	}
 
-	proc := s.process
+	cmd := s.cmd
+	processExited := s.processExited
 	s.mu.Unlock()
 
-	state, err := proc.Wait()
-	if err != nil {
-		s.config.Log.Errorf("error waiting for process exit: %v", err)
+	// Waiting on the command rather than the process also waits for its
+	// output to be copied to stdout and stderr
+	var exitErr *exec.ExitError
+	if err := cmd.Wait(); err != nil && !errors.As(err, &exitErr) {
+		config.Log.Errorf("error waiting for process exit: %v", err)
+	}
+	for _, w := range []io.Writer{s.stdout, s.stderr} {
+		if f, ok := w.(flusher); ok {
+			f.Flush()
+		}
 	}
 
-	s.hooks.cmdExit(*state)
+	config.Log.WithFields(logrus.Fields{
+		"cmd": config.Cmd,
+		"pid": cmd.Process.Pid,
+	}).Infof("Process exited: %s", cmd.ProcessState)
 
 	s.mu.Lock()
 	s.processRunning = false
 	s.mu.Unlock()
+
+	s.hooks.cmdExit(*cmd.ProcessState)
+	close(processExited)
+}
+
+// cmdLaunched reports whether 'cmd' has been launched, even if it has exited
+// since
+func (s *Sidecar) cmdLaunched() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.process != nil
+}
+
+// stopProcess stops 'cmd' if it is running. It is sent CmdStopSignal, and
+// killed if it hasn't exited once CmdStopTimeout has elapsed. stopProcess
+// returns once the process has been reaped, and 'cmd' is not launched again
+// afterwards.
+func (s *Sidecar) stopProcess() {
+	config := s.cfg()
+	s.mu.Lock()
+	s.stopping = true
+	running := s.processRunning
+	proc := s.process
+	processExited := s.processExited
+	s.mu.Unlock()
+
+	if !running {
+		return
+	}
+
+	stopSignal := config.CmdStopSignal
+	if stopSignal == "" {
+		stopSignal = defaultCmdStopSignal
+	}
+	stopTimeout := config.CmdStopTimeout
+	if stopTimeout == 0 {
+		stopTimeout = defaultCmdStopTimeout
+	}
+
+	log := config.Log.WithFields(logrus.Fields{
+		"cmd": config.Cmd,
+		"pid": proc.Pid,
+	})
+	log.Infof("Stopping process with %s", stopSignal)
+	if err := SignalProcess(proc, stopSignal); err != nil {
+		log.WithError(err).Warn("Unable to signal process to stop, killing it")
+		stopTimeout = 0
+	}
+
+	timer := time.NewTimer(stopTimeout)
+	defer timer.Stop()
+	select {
+	case <-processExited:
+		return
+	case <-timer.C:
+	}
+
+	if stopTimeout > 0 {
+		log.Warnf("Process did not exit within %s, killing it", stopTimeout)
+	}
+	if err := proc.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		log.WithError(err).Error("Unable to kill process")
+		return
+	}
+	<-processExited
 }
 
 func (s *Sidecar) fetchJWTSVIDs(ctx context.Context, jwtAudience string, jwtExtraAudiences []string) ([]*jwtsvid.SVID, error) {
-	jwtSVIDs, err := s.jwtSource.FetchJWTSVIDs(ctx, jwtsvid.Params{Audience: jwtAudience, ExtraAudiences: jwtExtraAudiences})
+	config := s.cfg()
+	var jwtSVIDs []*jwtsvid.SVID
+	err := s.fetch(ctx, CredentialTypeJWTSVID, func(ctx context.Context) (err error) {
+		jwtSVIDs, err = s.jwtSource.FetchJWTSVIDs(ctx, jwtsvid.Params{Audience: jwtAudience, ExtraAudiences: jwtExtraAudiences})
+		return err
+	})
 	if err != nil {
-		s.config.Log.Errorf("Unable to fetch JWT SVID: %v", err)
+		config.Log.WithFields(errorFields(err)).WithField(LogFieldAudience, jwtAudience).Error("Unable to fetch JWT SVID")
 		return nil, err
 	}
 	for _, jwtSVID := range jwtSVIDs {
+		_, span := s.startSpan(ctx, SpanValidateJWTSVID,
+			attrSPIFFEID.String(jwtSVID.ID.String()),
+			attrAudience.String(jwtAudience),
+		)
 		_, err = jwtsvid.ParseAndValidate(jwtSVID.Marshal(), s.jwtSource, []string{jwtAudience})
+		endSpan(span, err)
 		if err != nil {
-			s.config.Log.Errorf("Unable to parse or validate token: %v", err)
+			config.Log.WithError(err).WithFields(logrus.Fields{
+				LogFieldSPIFFEID: jwtSVID.ID.String(),
+				LogFieldAudience: jwtAudience,
+			}).Error("Unable to parse or validate token")
 			return nil, err
 		}
 	}
@@ -397,24 +689,47 @@ func getRefreshInterval(svid *jwtsvid.SVID) time.Duration {
 	return time.Until(svid.Expiry)/2 + time.Second
 }
 
-func (s *Sidecar) performJWTSVIDUpdate(ctx context.Context, jwtAudience string, jwtExtraAudiences []string, jwtSVIDFilename string) ([]*jwtsvid.SVID, error) {
-	s.config.Log.Debug("Updating JWT SVID")
+func (s *Sidecar) performJWTSVIDUpdate(ctx context.Context, jwtAudience string, jwtExtraAudiences []string, jwtSVIDFilename string) (_ []*jwtsvid.SVID, err error) {
+	config := s.cfg()
+	ctx, span := s.startSpan(ctx, SpanPerformJWTSVIDUpdate, attrAudience.String(jwtAudience))
+	defer func() { endSpan(span, err) }()
+
+	jwtSVIDPath := path.Join(config.CertDir, jwtSVIDFilename)
+	log := config.Log.WithFields(logrus.Fields{
+		LogFieldFile:     jwtSVIDPath,
+		LogFieldAudience: jwtAudience,
+	})
+	log.Debug("Updating JWT SVID")
 
 	jwtSVIDs, err := s.fetchJWTSVIDs(ctx, jwtAudience, jwtExtraAudiences)
 	if err != nil {
-		s.config.Log.Errorf("Unable to update JWT SVID: %v", err)
+		log.WithFields(errorFields(err)).Error("Unable to update JWT SVID")
 		return nil, err
 	}
 
-	jwtSVIDPath := path.Join(s.config.CertDir, jwtSVIDFilename)
-	if err = disk.WriteJWTSVID(jwtSVIDs, s.config.CertDir, jwtSVIDFilename, s.config.JWTSVIDFileMode, s.config.Hint); err != nil {
-		s.config.Log.Errorf("Unable to update JWT SVID: %v", err)
-		s.health.FileWriteStatuses.JWTWriteStatus[jwtSVIDPath] = writeStatusFailed
+	err = s.writeJWTSVID(ctx, jwtSVIDs, jwtSVIDFilename)
+	if err != nil {
+		log.WithError(err).Error("Unable to update JWT SVID")
+		s.health.setJWTWriteStatus(jwtSVIDPath, writeStatusFailed)
 		return nil, err
 	}
-	s.health.FileWriteStatuses.JWTWriteStatus[jwtSVIDPath] = writeStatusWritten
 
-	s.config.Log.Info("JWT SVID updated")
+	jwtSVID, svidErr := disk.GetJWTSVID(jwtSVIDs, config.Hint)
+	s.credentialsMu.Lock()
+	s.lastUpdates[jwtSVIDPath] = time.Now()
+	if svidErr == nil {
+		s.jwtSVIDs[jwtSVIDPath] = jwtSVID
+	}
+	s.credentialsMu.Unlock()
+
+	s.health.setJWTWriteStatus(jwtSVIDPath, writeStatusWritten)
+
+	if svidErr == nil {
+		log = log.WithField(LogFieldSPIFFEID, jwtSVID.ID.String())
+		span.SetAttributes(attrSPIFFEID.String(jwtSVID.ID.String()))
+	}
+	log.Info("JWT SVID updated")
+	s.notifyCredentialUpdate(ctx, CredentialTypeJWTSVID)
 	return jwtSVIDs, nil
 }
 
@@ -447,15 +762,19 @@ func (s *Sidecar) updateJWTSVID(ctx context.Context, jwtAudience string, jwtExtr
 }
 
 func (s *Sidecar) x509Enabled() bool {
-	return s.config.SVIDFilename != "" && s.config.SVIDKeyFilename != "" && s.config.SVIDBundleFilename != ""
+	return s.cfg().x509Enabled()
+}
+
+func (s *Sidecar) x509Files() []string {
+	return s.cfg().x509Files()
 }
 
 func (s *Sidecar) jwtBundleEnabled() bool {
-	return s.config.JWTBundleFilename != ""
+	return s.cfg().jwtBundleEnabled()
 }
 
 func (s *Sidecar) jwtSVIDsEnabled() bool {
-	return len(s.config.JWTSVIDs) > 0
+	return len(s.cfg().JWTSVIDs) > 0
 }
 
 type x509Watcher struct {
@@ -464,15 +783,16 @@ type x509Watcher struct {
 
 func (w x509Watcher) OnX509ContextUpdate(svids *workloadapi.X509Context) {
 	for _, svid := range svids.SVIDs {
-		w.sidecar.config.Log.WithField("spiffe_id", svid.ID).Info("Received update")
+		w.sidecar.cfg().Log.WithField(LogFieldSPIFFEID, svid.ID.String()).Info("Received update")
 	}
 
-	w.sidecar.updateCertificates(svids)
+	w.sidecar.updateCertificates(context.Background(), svids)
 }
 
 func (w x509Watcher) OnX509ContextWatchError(err error) {
 	if status.Code(err) != codes.Canceled {
-		w.sidecar.config.Log.Errorf("Error while watching x509 context: %v", err)
+		w.sidecar.cfg().Log.WithFields(errorFields(err)).Error("Error while watching x509 context")
+		w.sidecar.metrics.watchReconnects.WithLabelValues(CredentialTypeX509).Inc()
 	}
 }
 
@@ -496,45 +816,126 @@ type JWTBundlesWatcher struct {
 }
 
 func (w JWTBundlesWatcher) OnJWTBundlesUpdate(jwkSet *jwtbundle.Set) {
-	w.sidecar.config.Log.Debug("Updating JWT bundle")
-	jwtBundleFilePath := path.Join(w.sidecar.config.CertDir, w.sidecar.config.JWTBundleFilename)
-	if err := disk.WriteJWTBundleSet(jwkSet, w.sidecar.config.CertDir, w.sidecar.config.JWTBundleFilename, w.sidecar.config.JWTBundleFileMode); err != nil {
-		w.sidecar.config.Log.Errorf("Error writing JWT Bundle to disk: %v", err)
-		w.sidecar.health.FileWriteStatuses.JWTWriteStatus[jwtBundleFilePath] = writeStatusFailed
+	config := w.sidecar.cfg()
+	ctx, span := w.sidecar.startSpan(context.Background(), SpanUpdateJWTBundle)
+	var err error
+	defer func() { endSpan(span, err) }()
+
+	config.Log.Debug("Updating JWT bundle")
+	jwtBundleFilePath := path.Join(config.CertDir, config.JWTBundleFilename)
+	err = w.sidecar.writeJWTBundleSet(ctx, jwkSet)
+	if err != nil {
+		config.Log.WithError(err).WithField(LogFieldFile, jwtBundleFilePath).Error("Error writing JWT Bundle to disk")
+		w.sidecar.health.setJWTWriteStatus(jwtBundleFilePath, writeStatusFailed)
 		return
 	}
-	w.sidecar.health.FileWriteStatuses.JWTWriteStatus[jwtBundleFilePath] = writeStatusWritten
+	w.sidecar.health.setJWTWriteStatus(jwtBundleFilePath, writeStatusWritten)
 
-	w.sidecar.config.Log.Info("JWT bundle updated")
+	config.Log.WithFields(logrus.Fields{
+		LogFieldFile:        jwtBundleFilePath,
+		LogFieldTrustDomain: jwtTrustDomains(jwkSet),
+	}).Info("JWT bundle updated")
+	w.sidecar.notifyCredentialUpdate(ctx, CredentialTypeJWTBundle)
 }
 
 func (w JWTBundlesWatcher) OnJWTBundlesWatchError(err error) {
 	if status.Code(err) != codes.Canceled {
-		w.sidecar.config.Log.Errorf("Error while watching JWT bundles: %v", err)
+		w.sidecar.cfg().Log.WithFields(errorFields(err)).Error("Error while watching JWT bundles")
+		w.sidecar.metrics.watchReconnects.WithLabelValues(CredentialTypeJWTBundle).Inc()
 	}
 }
 
 func (s *Sidecar) CheckLiveness() bool {
-	for _, writeStatus := range s.health.FileWriteStatuses.JWTWriteStatus {
+	health := s.health.snapshot()
+	for _, writeStatus := range health.FileWriteStatuses.JWTWriteStatus {
 		if writeStatus == writeStatusFailed {
 			return false
 		}
 	}
-	if s.x509Enabled() && *s.health.FileWriteStatuses.X509WriteStatus == writeStatusFailed {
+	if health.FileWriteStatuses.X509WriteStatus != nil && *health.FileWriteStatuses.X509WriteStatus == writeStatusFailed {
 		return false
 	}
-	return true
+	return s.svidsUpdatedWithin(s.cfg().LivenessUpdateWindow)
 }
 
 func (s *Sidecar) CheckReadiness() bool {
-	for _, writeStatus := range s.health.FileWriteStatuses.JWTWriteStatus {
+	health := s.health.snapshot()
+	for _, writeStatus := range health.FileWriteStatuses.JWTWriteStatus {
 		if writeStatus != writeStatusWritten {
 			return false
 		}
 	}
-	return !s.x509Enabled() || *s.health.FileWriteStatuses.X509WriteStatus == writeStatusWritten
+	if health.FileWriteStatuses.X509WriteStatus != nil && *health.FileWriteStatuses.X509WriteStatus != writeStatusWritten {
+		return false
+	}
+	return s.svidsValidFor(s.cfg().ReadinessMinLifetime)
 }
 
-func (s *Sidecar) GetHealth() Health {
-	return s.health
+// svidsUpdatedWithin reports whether every SVID file has been written
+// successfully within the given window, or since the sidecar was created if
+// it hasn't been written yet. A zero window disables the check.
+func (s *Sidecar) svidsUpdatedWithin(window time.Duration) bool {
+	if window == 0 {
+		return true
+	}
+
+	s.credentialsMu.RLock()
+	defer s.credentialsMu.RUnlock()
+	for _, svidPath := range s.cfg().svidFiles() {
+		lastUpdate, ok := s.lastUpdates[svidPath]
+		if !ok {
+			lastUpdate = s.started
+		}
+		if time.Since(lastUpdate) > window {
+			return false
+		}
+	}
+	return true
+}
+
+// svidsValidFor reports whether every SVID written to disk remains valid for
+// at least the given lifetime. A zero lifetime disables the check.
+func (s *Sidecar) svidsValidFor(lifetime time.Duration) bool {
+	if lifetime == 0 {
+		return true
+	}
+
+	s.credentialsMu.RLock()
+	defer s.credentialsMu.RUnlock()
+	for _, x509SVID := range s.x509SVIDs {
+		if time.Until(x509SVID.Certificates[0].NotAfter) < lifetime {
+			return false
+		}
+	}
+	for _, jwtSVID := range s.jwtSVIDs {
+		if time.Until(jwtSVID.Expiry) < lifetime {
+			return false
+		}
+	}
+	return true
+}
+
+// X509SVIDExpiry returns the expiry of the last X.509 SVID written to disk
+// by the first X.509 SVID output, or the zero time if none has been written
+// yet.
+func (s *Sidecar) X509SVIDExpiry() time.Time {
+	s.credentialsMu.RLock()
+	defer s.credentialsMu.RUnlock()
+	x509SVID := s.x509SVID()
+	if x509SVID == nil {
+		return time.Time{}
+	}
+	return x509SVID.Certificates[0].NotAfter
+}
+
+// x509SVID returns the last X.509 SVID written to disk by the first X.509
+// SVID output, which is the one passed to 'cmd' and served by the health
+// server, or nil if none has been written yet. credentialsMu must be held.
+func (s *Sidecar) x509SVID() *x509svid.SVID {
+	config := s.cfg()
+	x509SVIDs := config.x509SVIDs()
+	if len(x509SVIDs) == 0 {
+		return nil
+	}
+	return s.x509SVIDs[path.Join(config.CertDir, x509SVIDs[0].SVIDFilename)]
 }
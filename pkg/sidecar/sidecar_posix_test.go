@@ -19,6 +19,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
 	"github.com/stretchr/testify/require"
 )
 
@@ -343,3 +345,144 @@ func TestSidecar_TestCmdRunsLongRunning(t *testing.T) {
 	// for it to time out. There's no need to wait here, the test is done.
 	require.NoError(t, s.sidecar.process.Signal(syscall.SIGTERM))
 }
+
+// Notification targets are signalled only for the credential types they are
+// interested in, each with its own signal.
+func TestSidecar_TestNotifyTargets(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// Both targets signal this test process, with different signals
+	sigListener := make(chan os.Signal, 2)
+	signal.Notify(sigListener, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigListener)
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	pidfile := path.Join(t.TempDir(), "pidfile")
+	require.NoError(t, os.WriteFile(pidfile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0600))
+
+	config := s.sidecar.config
+	config.Cmd = ""
+	config.JWTBundleFilename = "jwt_bundle.json"
+	config.NotifyTargets = []NotifyTarget{
+		{
+			PIDFilename:     pidfile,
+			RenewSignal:     SignalName(syscall.SIGUSR1),
+			CredentialTypes: []string{CredentialTypeJWTBundle},
+		},
+		{
+			PIDFilename:     pidfile,
+			RenewSignal:     SignalName(syscall.SIGUSR2),
+			CredentialTypes: []string{CredentialTypeX509},
+		},
+	}
+
+	expectSignalled := func(expectSignal syscall.Signal) {
+		t.Helper()
+		select {
+		case result := <-s.notifyTargetSignalledChan:
+			require.NoError(t, result.err)
+			require.Equal(t, []int{os.Getpid()}, result.pids)
+			require.Equal(t, SignalName(expectSignal), result.target.RenewSignal)
+		case <-ctx.Done():
+			require.NoError(t, ctx.Err())
+		}
+
+		select {
+		case sig := <-sigListener:
+			require.Equal(t, expectSignal, sig)
+		case <-ctx.Done():
+			require.NoError(t, ctx.Err())
+		}
+
+		// No other target may be signalled for the same update
+		select {
+		case result := <-s.notifyTargetSignalledChan:
+			require.Fail(t, "unexpected notification", "target %v signalled", result.target)
+		default:
+		}
+	}
+
+	// A JWT bundle rotation signals only the JWT bundle target
+	td := spiffeid.RequireTrustDomainFromString("example.test")
+	JWTBundlesWatcher{sidecar: s.sidecar}.OnJWTBundlesUpdate(jwtbundle.NewSet(jwtbundle.New(td)))
+	expectSignalled(syscall.SIGUSR1)
+
+	// An X.509 rotation signals only the X.509 target
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+	expectSignalled(syscall.SIGUSR2)
+}
+
+// Validate that 'cmd' is stopped with the stop signal when the helper shuts
+// down, and killed if it doesn't exit within the timeout
+func TestSidecar_TestCmdStop(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		stopSignal     string
+		script         string
+		expectExitCode int
+		expectSignal   syscall.Signal
+	}{
+		{
+			name:           "exits on SIGTERM",
+			script:         "trap 'exit 3' TERM",
+			expectExitCode: 3,
+		},
+		{
+			name:           "exits on custom stop signal",
+			stopSignal:     "SIGUSR2",
+			script:         "trap 'exit 4' USR2",
+			expectExitCode: 4,
+		},
+		{
+			name:           "killed after timeout",
+			script:         "trap '' TERM",
+			expectExitCode: -1,
+			expectSignal:   syscall.SIGKILL,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newSidecarTest(t)
+			defer s.Close(t)
+
+			config := s.sidecar.config
+			config.Cmd = "sh"
+			config.CmdArgv = []string{"-c", tc.script + "; echo ready; while :; do sleep 0.1; done"}
+			config.CmdStopSignal = tc.stopSignal
+			config.CmdStopTimeout = time.Second
+
+			// Wait for the traps to be installed before stopping it
+			stdout, err := os.Create(path.Join(t.TempDir(), "stdout"))
+			require.NoError(t, err)
+			defer stdout.Close()
+			s.sidecar.stdout = stdout
+
+			require.NoError(t, s.sidecar.signalProcess(context.Background()))
+			require.Eventually(t, func() bool {
+				output, err := os.ReadFile(stdout.Name())
+				return err == nil && string(output) == "ready\n"
+			}, defaultTimeout, 10*time.Millisecond)
+
+			start := time.Now()
+			s.sidecar.stopProcess()
+
+			// The process has been reaped by the time stopProcess returns
+			s.sidecar.mu.Lock()
+			require.False(t, s.sidecar.processRunning)
+			s.sidecar.mu.Unlock()
+
+			state := <-s.cmdExitChan
+			require.Equal(t, tc.expectExitCode, state.ExitCode())
+			if tc.expectSignal != 0 {
+				require.Equal(t, tc.expectSignal, state.Sys().(syscall.WaitStatus).Signal())
+				require.GreaterOrEqual(t, time.Since(start), time.Second)
+			}
+
+			// cmd is not relaunched once stopped
+			require.EqualError(t, s.sidecar.signalProcess(context.Background()), "not running cmd while shutting down")
+		})
+	}
+}
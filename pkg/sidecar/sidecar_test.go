@@ -486,6 +486,376 @@ func TestSidecar_RunDaemon(t *testing.T) {
 	}
 }
 
+// Each X.509 SVID output writes its own SVID, in its own format, and
+// failing to write one doesn't stop the others from being written
+func TestSidecar_X509SVIDs(t *testing.T) {
+	rootCA := spiffetest.NewCA(t)
+	intermediateCA := rootCA.CreateCA()
+	federatedCA := spiffetest.NewCA(t)
+
+	newSVID := func(id, hint string) *x509svid.SVID {
+		certs, key := intermediateCA.CreateX509SVID(id)
+		require.Len(t, certs, 2)
+		return &x509svid.SVID{ID: spiffeid.RequireFromString(id), Certificates: certs, PrivateKey: key, Hint: hint}
+	}
+	webSVID := newSVID(exampleSpiffeID, "web")
+	dbSVID := newSVID("spiffe://example.test/db", "db")
+	x509Context := &workloadapi.X509Context{
+		Bundles: x509bundle.NewSet(
+			x509bundle.FromX509Authorities(webSVID.ID.TrustDomain(), rootCA.Roots()),
+			x509bundle.FromX509Authorities(spiffeid.RequireTrustDomainFromString("federated.test"), federatedCA.Roots()),
+		),
+		SVIDs: []*x509svid.SVID{webSVID, dbSVID},
+	}
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	config := s.sidecar.config
+	config.Cmd = ""
+	config.X509SVIDs = []X509SVIDConfig{
+		{
+			SPIFFEID:                 "spiffe://example.test/db",
+			SVIDFilename:             "db.pem",
+			SVIDKeyFilename:          "db_key.pem",
+			SVIDBundleFilename:       "db_bundle.pem",
+			AddIntermediatesToBundle: true,
+		},
+		{
+			Hint:                    "web",
+			SVIDFilename:            "web.pem",
+			SVIDKeyFilename:         "web_key.pem",
+			SVIDBundleFilename:      "web_bundle.pem",
+			IncludeFederatedDomains: true,
+		},
+	}
+	s.sidecar.setupStatus()
+
+	s.sidecar.updateCertificates(context.Background(), x509Context)
+	assert.Equal(t, writeStatusWritten, *s.sidecar.health.snapshot().FileWriteStatuses.X509WriteStatus)
+
+	for _, output := range []struct {
+		svidFile, keyFile, bundleFile string
+		certs                         []*x509.Certificate
+		key                           crypto.Signer
+		bundle                        []*x509.Certificate
+	}{
+		// The top level output writes the default SVID
+		{
+			svidFile:   "svid.pem",
+			keyFile:    "svid_key.pem",
+			bundleFile: "svid_bundle.pem",
+			certs:      webSVID.Certificates,
+			key:        webSVID.PrivateKey,
+			bundle:     rootCA.Roots(),
+		},
+		{
+			svidFile:   "db.pem",
+			keyFile:    "db_key.pem",
+			bundleFile: "db_bundle.pem",
+			certs:      dbSVID.Certificates[:1],
+			key:        dbSVID.PrivateKey,
+			bundle:     append(rootCA.Roots(), dbSVID.Certificates[1:]...),
+		},
+		{
+			svidFile:   "web.pem",
+			keyFile:    "web_key.pem",
+			bundleFile: "web_bundle.pem",
+			certs:      webSVID.Certificates,
+			key:        webSVID.PrivateKey,
+			bundle:     append(rootCA.Roots(), federatedCA.Roots()...),
+		},
+	} {
+		certs, err := util.LoadCertificates(path.Join(config.CertDir, output.svidFile))
+		require.NoError(t, err)
+		assert.Equal(t, output.certs, certs, output.svidFile)
+
+		key, err := util.LoadPrivateKey(path.Join(config.CertDir, output.keyFile))
+		require.NoError(t, err)
+		assert.Equal(t, output.key, key, output.keyFile)
+
+		bundle, err := util.LoadCertificates(path.Join(config.CertDir, output.bundleFile))
+		require.NoError(t, err)
+		assert.Equal(t, output.bundle, bundle, output.bundleFile)
+	}
+
+	// The blocks are reported apart from the top level output
+	status := s.sidecar.Status()
+	require.NotNil(t, status.X509SVID)
+	assert.Equal(t, exampleSpiffeID, status.X509SVID.SPIFFEID)
+	require.Len(t, status.X509SVIDs, 2)
+	assert.Equal(t, path.Join(config.CertDir, "db.pem"), status.X509SVIDs[0].File)
+	assert.Equal(t, "spiffe://example.test/db", status.X509SVIDs[0].SPIFFEID)
+	assert.Equal(t, "web", status.X509SVIDs[1].Hint)
+
+	// The first output is the helper's own identity
+	svid, err := s.sidecar.GetX509SVID()
+	require.NoError(t, err)
+	assert.Same(t, webSVID, svid)
+
+	// An SVID missing from the response fails its output only
+	x509Context = &workloadapi.X509Context{Bundles: x509Context.Bundles, SVIDs: []*x509svid.SVID{webSVID}}
+	s.sidecar.updateCertificates(context.Background(), x509Context)
+	select {
+	case <-s.certReadyChan:
+	default:
+		require.Fail(t, "the outputs that were written must be reported")
+	}
+	assert.Equal(t, writeStatusFailed, *s.sidecar.health.snapshot().FileWriteStatuses.X509WriteStatus)
+	status = s.sidecar.Status()
+	assert.Empty(t, status.X509SVID.LastError)
+	assert.Equal(t, `failed to find the x509 SVID of "spiffe://example.test/db"`, status.X509SVIDs[0].LastError)
+	assert.Empty(t, status.X509SVIDs[1].LastError)
+}
+
+// Bursts of credential updates are coalesced into a single notification,
+// while the files on disk are kept current.
+func TestSidecar_NotifyDebounce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	reloads := make(chan time.Time, 10)
+	config := s.sidecar.config
+	config.Cmd = ""
+	config.NotifyDebounce = 200 * time.Millisecond
+	config.NotifyMinInterval = time.Second
+	config.ReloadExternalProcess = func() error {
+		reloads <- time.Now()
+		return nil
+	}
+	defer s.sidecar.stopNotifications()
+
+	waitForReload := func() time.Time {
+		t.Helper()
+		select {
+		case reloaded := <-reloads:
+			return reloaded
+		case <-ctx.Done():
+			require.NoError(t, ctx.Err())
+			return time.Time{}
+		}
+	}
+
+	// A burst of rotations results in a single reload once the burst is over
+	start := time.Now()
+	var svid testX509SVID
+	for range 3 {
+		svid = newTestX509SVID(t, s.rootCA)
+		s.MockUpdateX509Certificate(ctx, t, svid)
+	}
+	firstReload := waitForReload()
+	require.GreaterOrEqual(t, firstReload.Sub(start), config.NotifyDebounce)
+
+	// The files were updated without waiting for the notification
+	certs, err := util.LoadCertificates(path.Join(config.CertDir, config.SVIDFilename))
+	require.NoError(t, err)
+	require.Equal(t, svid.svidChain, certs)
+
+	select {
+	case <-reloads:
+		require.Fail(t, "burst of rotations should only reload once")
+	case <-time.After(2 * config.NotifyDebounce):
+	}
+
+	// Another rotation soon after is held back until the minimum interval
+	// since the last notifications were sent has passed
+	s.sidecar.notifyMu.Lock()
+	lastNotification := s.sidecar.lastNotification
+	s.sidecar.notifyMu.Unlock()
+	s.MockUpdateX509Certificate(ctx, t, newTestX509SVID(t, s.rootCA))
+	secondReload := waitForReload()
+	require.GreaterOrEqual(t, secondReload.Sub(lastNotification), config.NotifyMinInterval)
+}
+
+// A steady stream of updates can't postpone notifications beyond the
+// maximum delay, and 'cmd' is launched without waiting for the debounce.
+func TestSidecar_NotifyMaxDelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	reloads := make(chan time.Time, 10)
+	config := s.sidecar.config
+	config.NotifyDebounce = 5 * time.Second
+	config.ReloadExternalProcess = func() error {
+		reloads <- time.Now()
+		return nil
+	}
+	defer s.sidecar.stopNotifications()
+
+	// 'cmd' isn't running yet, so it is launched right away
+	s.MockUpdateX509Certificate(ctx, t, newTestX509SVID(t, s.rootCA))
+	select {
+	case <-s.cmdExitChan:
+	case <-time.After(time.Second):
+		require.Fail(t, "cmd should be launched without waiting for the debounce")
+	}
+	<-reloads
+
+	config.Cmd = ""
+	config.NotifyDebounce = 200 * time.Millisecond
+	config.NotifyMaxDelay = 500 * time.Millisecond
+	start := time.Now()
+	var reloaded time.Time
+	for reloaded.IsZero() {
+		s.MockUpdateX509Certificate(ctx, t, newTestX509SVID(t, s.rootCA))
+		select {
+		case reloaded = <-reloads:
+		case <-time.After(config.NotifyDebounce / 2):
+		case <-ctx.Done():
+			require.NoError(t, ctx.Err())
+		}
+	}
+	require.GreaterOrEqual(t, reloaded.Sub(start), config.NotifyMaxDelay)
+	require.Less(t, reloaded.Sub(start), 2*config.NotifyMaxDelay)
+}
+
+// Only the first launch of 'cmd' skips the debounce, so a short-lived 'cmd'
+// is still rate limited once it has exited
+func TestSidecar_NotifyShortLivedCmd(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	config := s.sidecar.config
+	config.NotifyDebounce = 100 * time.Millisecond
+	config.NotifyMinInterval = time.Second
+	defer s.sidecar.stopNotifications()
+
+	s.MockUpdateX509Certificate(ctx, t, newTestX509SVID(t, s.rootCA))
+	select {
+	case <-s.cmdExitChan:
+	case <-time.After(time.Second):
+		require.Fail(t, "cmd should be launched without waiting for the debounce")
+	}
+
+	// Two quick rotations after it has exited launch it once, no sooner
+	// than the minimum interval
+	s.sidecar.notifyMu.Lock()
+	lastNotification := s.sidecar.lastNotification
+	s.sidecar.notifyMu.Unlock()
+	for range 2 {
+		s.MockUpdateX509Certificate(ctx, t, newTestX509SVID(t, s.rootCA))
+	}
+	select {
+	case <-s.cmdExitChan:
+	case <-ctx.Done():
+		require.NoError(t, ctx.Err())
+	}
+	require.GreaterOrEqual(t, time.Since(lastNotification), config.NotifyMinInterval)
+
+	select {
+	case <-s.cmdExitChan:
+		require.Fail(t, "cmd should only run once for both rotations")
+	case <-time.After(2 * config.NotifyDebounce):
+	}
+}
+
+// Targets selecting the same processes for different signals or credential
+// types each have their own health status
+func TestNotifyTarget_String(t *testing.T) {
+	for _, tt := range []struct {
+		target   NotifyTarget
+		expected string
+	}{
+		{
+			target:   NotifyTarget{PIDFilename: "/run/app.pid", RenewSignal: "SIGHUP"},
+			expected: "pid_file_name=/run/app.pid,renew_signal=SIGHUP",
+		},
+		{
+			target:   NotifyTarget{SystemdUnit: "envoy.service", SystemdUnitAction: "restart", CredentialTypes: []string{CredentialTypeX509}},
+			expected: "systemd_unit=envoy.service,systemd_unit_action=restart,credential_types=x509",
+		},
+		{
+			target:   NotifyTarget{ProcessName: "envoy", CgroupPath: "/app", RenewSignal: "SIGUSR1", CredentialTypes: []string{CredentialTypeJWTSVID, CredentialTypeJWTBundle}},
+			expected: "process_name=envoy,cgroup_path=/app,renew_signal=SIGUSR1,credential_types=jwt_svid+jwt_bundle",
+		},
+	} {
+		assert.Equal(t, tt.expected, tt.target.String())
+	}
+
+	log, _ := test.NewNullLogger()
+	s := New(&Config{
+		Log: log,
+		NotifyTargets: []NotifyTarget{
+			{ProcessName: "envoy", RenewSignal: "SIGHUP", CredentialTypes: []string{CredentialTypeX509}},
+			{ProcessName: "envoy", RenewSignal: "SIGUSR1", CredentialTypes: []string{CredentialTypeJWTSVID}},
+		},
+	})
+	assert.Len(t, s.GetHealth().NotifyTargetStatuses, 2)
+}
+
+func TestSidecar_CmdEnv(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Setenv("SPIFFE_HELPER_TEST_INHERITED", "inherited")
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	config := s.sidecar.config
+	config.Cmd = ""
+	config.JWTBundleFilename = "jwt_bundle.json"
+	config.JWTSVIDs = []JWTConfig{
+		{JWTAudience: "aud-0", JWTSVIDFilename: "jwt-0.token"},
+		{JWTAudience: "aud-1", JWTSVIDFilename: "jwt-1.token"},
+	}
+	config.CmdEnv = map[string]string{
+		"APP_MODE": "production",
+		// Explicit settings win over the variables set by the helper
+		EnvTrustDomain: "overridden.test",
+	}
+
+	envMap := func() map[string]string {
+		// Later entries take precedence, as they do for os/exec
+		env := make(map[string]string)
+		for _, kv := range s.sidecar.cmdEnv() {
+			key, value, _ := strings.Cut(kv, "=")
+			env[key] = value
+		}
+		return env
+	}
+
+	// Before any credentials are received only the file locations are known
+	env := envMap()
+	assert.Equal(t, "inherited", env["SPIFFE_HELPER_TEST_INHERITED"])
+	assert.Equal(t, "production", env["APP_MODE"])
+	assert.Equal(t, config.CertDir, env[EnvCertDir])
+	assert.Equal(t, path.Join(config.CertDir, "svid.pem"), env[EnvSVIDFile])
+	assert.Equal(t, path.Join(config.CertDir, "svid_key.pem"), env[EnvSVIDKeyFile])
+	assert.Equal(t, path.Join(config.CertDir, "svid_bundle.pem"), env[EnvSVIDBundleFile])
+	assert.Equal(t, path.Join(config.CertDir, "jwt_bundle.json"), env[EnvJWTBundleFile])
+	assert.Equal(t, path.Join(config.CertDir, "jwt-0.token"), env[EnvJWTSVIDFilePrefix+"0"])
+	assert.Equal(t, path.Join(config.CertDir, "jwt-1.token"), env[EnvJWTSVIDFilePrefix+"1"])
+	assert.NotContains(t, env, EnvSPIFFEID)
+	assert.NotContains(t, env, EnvSVIDExpiry)
+
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+	jwtSVID := newTestJWTSVID(t, "aud-1", time.Now().Add(time.Hour))
+	s.sidecar.jwtSVIDs[path.Join(config.CertDir, "jwt-1.token")] = jwtSVID
+
+	// JWT SVID contents are only included when enabled
+	env = envMap()
+	assert.Equal(t, exampleSpiffeID, env[EnvSPIFFEID])
+	assert.Equal(t, "overridden.test", env[EnvTrustDomain])
+	assert.Equal(t, svid.svidChain[0].NotAfter.UTC().Format(time.RFC3339), env[EnvSVIDExpiry])
+	assert.NotContains(t, env, EnvJWTSVIDPrefix+"1")
+
+	config.CmdEnvIncludeJWTSVIDs = true
+	env = envMap()
+	assert.NotContains(t, env, EnvJWTSVIDPrefix+"0")
+	assert.Equal(t, jwtSVID.Marshal(), env[EnvJWTSVIDPrefix+"1"])
+}
+
 func TestGetCmdArgs(t *testing.T) {
 	cases := []struct {
 		name         string
@@ -602,9 +972,9 @@ func TestSignalProcessWithScript(t *testing.T) {
 	s.sidecar.config.RenewSignal = "SIGWINCH"
 
 	// Run signalProcess() twice. The second should only signal the process with SIGWINCH which is basically a no op.
-	err := s.sidecar.signalProcess()
+	err := s.sidecar.signalProcess(context.Background())
 	require.NoError(t, err)
-	err = s.sidecar.signalProcess()
+	err = s.sidecar.signalProcess(context.Background())
 	require.NoError(t, err)
 
 	// Give the script some time to run
@@ -679,7 +1049,7 @@ func TestNew(t *testing.T) {
 			sidecar := New(config)
 			assert.NotNil(t, sidecar)
 			assert.Equal(t, config, sidecar.config)
-			assert.Equal(t, c.expectedFileWriteStatuses, sidecar.health.FileWriteStatuses)
+			assert.Equal(t, c.expectedFileWriteStatuses, sidecar.GetHealth().FileWriteStatuses)
 		})
 	}
 }
@@ -687,15 +1057,56 @@ func TestNew(t *testing.T) {
 func Test_CheckReadiness(t *testing.T) {
 	sidecar := Sidecar{
 		config: &Config{},
-		health: Health{
-			FileWriteStatuses: FileWriteStatuses{
-				X509WriteStatus: nil,
-			},
-		},
+		health: newHealthState(),
 	}
 	assert.True(t, sidecar.CheckReadiness())
 }
 
+func TestSidecar_ExpiryAwareHealth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	config := s.sidecar.config
+	config.Cmd = ""
+
+	// The checks are disabled by default
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+	s.sidecar.jwtSVIDs[path.Join(config.CertDir, "jwt.token")] = newTestJWTSVID(t, "aud", time.Now().Add(time.Minute))
+	assert.True(t, s.sidecar.CheckReadiness())
+	assert.True(t, s.sidecar.CheckLiveness())
+
+	// The test X.509 SVID is valid for an hour
+	config.ReadinessMinLifetime = 30 * time.Second
+	assert.True(t, s.sidecar.CheckReadiness())
+	config.ReadinessMinLifetime = 2 * time.Hour
+	assert.False(t, s.sidecar.CheckReadiness())
+
+	// The JWT SVID expires within the threshold
+	config.ReadinessMinLifetime = 5 * time.Minute
+	assert.False(t, s.sidecar.CheckReadiness())
+	delete(s.sidecar.jwtSVIDs, path.Join(config.CertDir, "jwt.token"))
+	assert.True(t, s.sidecar.CheckReadiness())
+
+	// Liveness fails once an SVID hasn't been updated within the window
+	config.LivenessUpdateWindow = time.Minute
+	assert.True(t, s.sidecar.CheckLiveness())
+	svidPath := path.Join(config.CertDir, config.SVIDFilename)
+	s.sidecar.lastUpdates[svidPath] = time.Now().Add(-2 * time.Minute)
+	assert.False(t, s.sidecar.CheckLiveness())
+	s.MockUpdateX509Certificate(ctx, t, svid)
+	assert.True(t, s.sidecar.CheckLiveness())
+
+	// SVID files that were never written count from when the sidecar was created
+	config.JWTSVIDs = []JWTConfig{{JWTAudience: "aud", JWTSVIDFilename: "jwt.token"}}
+	assert.True(t, s.sidecar.CheckLiveness())
+	s.sidecar.started = time.Now().Add(-2 * time.Minute)
+	assert.False(t, s.sidecar.CheckLiveness())
+}
+
 func onWindows() bool {
 	return runtime.GOOS == "windows"
 }
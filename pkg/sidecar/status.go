@@ -0,0 +1,271 @@
+package sidecar
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"github.com/spiffe/spiffe-helper/pkg/disk"
+)
+
+// Status describes every output of the sidecar and the credentials last
+// written to it
+type Status struct {
+	// The X.509 SVID output configured with Config.SVIDFilename, and those
+	// of Config.X509SVIDs
+	X509SVID  *X509SVIDStatus   `json:"x509_svid,omitempty"`
+	X509SVIDs []*X509SVIDStatus `json:"x509_svids,omitempty"`
+	JWTSVIDs  []*JWTSVIDStatus  `json:"jwt_svids,omitempty"`
+	JWTBundle *JWTBundleStatus  `json:"jwt_bundle,omitempty"`
+}
+
+// OutputStatus describes the last attempts to write an output
+type OutputStatus struct {
+	File      string     `json:"file"`
+	LastWrite *time.Time `json:"last_write,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// X509SVIDStatus describes the X.509 SVID, key and bundle files
+type X509SVIDStatus struct {
+	OutputStatus
+	KeyFile            string     `json:"key_file"`
+	BundleFile         string     `json:"bundle_file"`
+	SPIFFEID           string     `json:"spiffe_id,omitempty"`
+	Hint               string     `json:"hint,omitempty"`
+	SerialNumber       string     `json:"serial_number,omitempty"`
+	NotBefore          *time.Time `json:"not_before,omitempty"`
+	NotAfter           *time.Time `json:"not_after,omitempty"`
+	ChainLength        int        `json:"chain_length,omitempty"`
+	KeyType            string     `json:"key_type,omitempty"`
+	SHA256Fingerprint  string     `json:"sha256_fingerprint,omitempty"`
+	BundleTrustDomains []string   `json:"bundle_trust_domains,omitempty"`
+}
+
+// JWTSVIDStatus describes a JWT SVID file
+type JWTSVIDStatus struct {
+	OutputStatus
+	Audience       string     `json:"audience"`
+	ExtraAudiences []string   `json:"extra_audiences,omitempty"`
+	SPIFFEID       string     `json:"spiffe_id,omitempty"`
+	Hint           string     `json:"hint,omitempty"`
+	Expiry         *time.Time `json:"expiry,omitempty"`
+}
+
+// JWTBundleStatus describes the JWT bundle file
+type JWTBundleStatus struct {
+	OutputStatus
+	TrustDomains []string `json:"trust_domains,omitempty"`
+}
+
+func (s *Sidecar) setupStatus() {
+	config := s.cfg()
+	s.outputsMu.Lock()
+	defer s.outputsMu.Unlock()
+
+	// Outputs that were already written before a reload keep their status
+	previous := s.outputs
+	s.outputs = Status{}
+	for i, x509SVID := range config.x509SVIDs() {
+		x509Status := &X509SVIDStatus{
+			OutputStatus: OutputStatus{File: path.Join(config.CertDir, x509SVID.SVIDFilename)},
+			KeyFile:      path.Join(config.CertDir, x509SVID.SVIDKeyFilename),
+			BundleFile:   path.Join(config.CertDir, x509SVID.SVIDBundleFilename),
+			Hint:         x509SVID.Hint,
+		}
+		if previousStatus := previous.x509SVIDStatus(x509Status.File); previousStatus != nil &&
+			previousStatus.KeyFile == x509Status.KeyFile && previousStatus.BundleFile == x509Status.BundleFile {
+			x509Status = previousStatus
+			x509Status.Hint = x509SVID.Hint
+		}
+		if i == 0 && config.topLevelX509Enabled() {
+			s.outputs.X509SVID = x509Status
+		} else {
+			s.outputs.X509SVIDs = append(s.outputs.X509SVIDs, x509Status)
+		}
+	}
+	for _, jwtConfig := range config.JWTSVIDs {
+		jwtSVIDStatus := &JWTSVIDStatus{
+			OutputStatus:   OutputStatus{File: path.Join(config.CertDir, jwtConfig.JWTSVIDFilename)},
+			Audience:       jwtConfig.JWTAudience,
+			ExtraAudiences: jwtConfig.JWTExtraAudiences,
+			Hint:           config.Hint,
+		}
+		for _, previousStatus := range previous.JWTSVIDs {
+			if previousStatus.File == jwtSVIDStatus.File && previousStatus.Audience == jwtSVIDStatus.Audience &&
+				slices.Equal(previousStatus.ExtraAudiences, jwtSVIDStatus.ExtraAudiences) {
+				jwtSVIDStatus = previousStatus
+				jwtSVIDStatus.Hint = config.Hint
+				break
+			}
+		}
+		s.outputs.JWTSVIDs = append(s.outputs.JWTSVIDs, jwtSVIDStatus)
+	}
+	if s.jwtBundleEnabled() {
+		jwtBundleStatus := &JWTBundleStatus{
+			OutputStatus: OutputStatus{File: path.Join(config.CertDir, config.JWTBundleFilename)},
+		}
+		if previous.JWTBundle != nil && previous.JWTBundle.File == jwtBundleStatus.File {
+			jwtBundleStatus = previous.JWTBundle
+		}
+		s.outputs.JWTBundle = jwtBundleStatus
+	}
+}
+
+// Status returns a snapshot of the status of every output
+func (s *Sidecar) Status() Status {
+	s.outputsMu.RLock()
+	defer s.outputsMu.RUnlock()
+
+	var snapshot Status
+	if s.outputs.X509SVID != nil {
+		x509SVID := *s.outputs.X509SVID
+		snapshot.X509SVID = &x509SVID
+	}
+	for _, x509SVID := range s.outputs.X509SVIDs {
+		x509SVID := *x509SVID
+		snapshot.X509SVIDs = append(snapshot.X509SVIDs, &x509SVID)
+	}
+	for _, jwtSVID := range s.outputs.JWTSVIDs {
+		jwtSVID := *jwtSVID
+		snapshot.JWTSVIDs = append(snapshot.JWTSVIDs, &jwtSVID)
+	}
+	if s.outputs.JWTBundle != nil {
+		jwtBundle := *s.outputs.JWTBundle
+		snapshot.JWTBundle = &jwtBundle
+	}
+	return snapshot
+}
+
+// x509SVIDStatus returns the status of the X.509 SVID output writing the SVID
+// to file, or nil if there is none
+func (status *Status) x509SVIDStatus(file string) *X509SVIDStatus {
+	if status.X509SVID != nil && status.X509SVID.File == file {
+		return status.X509SVID
+	}
+	for _, x509Status := range status.X509SVIDs {
+		if x509Status.File == file {
+			return x509Status
+		}
+	}
+	return nil
+}
+
+// recordX509Write records the outcome of writing an X.509 context to the
+// files of an X.509 SVID output in the status, metrics and audit log
+func (s *Sidecar) recordX509Write(x509SVID X509SVIDConfig, x509Context *workloadapi.X509Context, err error) {
+	s.observeX509Write(x509SVID, x509Context, err)
+	s.auditX509Write(x509SVID, x509Context, err)
+
+	s.outputsMu.Lock()
+	defer s.outputsMu.Unlock()
+
+	x509Status := s.outputs.x509SVIDStatus(path.Join(s.cfg().CertDir, x509SVID.SVIDFilename))
+	if x509Status == nil {
+		return
+	}
+	if err != nil {
+		x509Status.LastError = err.Error()
+		return
+	}
+
+	now := time.Now()
+	x509Status.LastWrite = &now
+	x509Status.LastError = ""
+
+	if svid, err := disk.GetX509SVID(x509Context, x509SVID.Hint, x509SVID.SPIFFEID); err == nil {
+		leaf := svid.Certificates[0]
+		fingerprint := sha256.Sum256(leaf.Raw)
+		x509Status.SPIFFEID = svid.ID.String()
+		x509Status.SerialNumber = leaf.SerialNumber.Text(16)
+		x509Status.NotBefore = &leaf.NotBefore
+		x509Status.NotAfter = &leaf.NotAfter
+		x509Status.ChainLength = len(svid.Certificates)
+		x509Status.KeyType = keyType(leaf)
+		x509Status.SHA256Fingerprint = hex.EncodeToString(fingerprint[:])
+	}
+
+	x509Status.BundleTrustDomains = x509TrustDomains(x509Context.Bundles)
+}
+
+// recordJWTSVIDWrite records the outcome of writing a JWT SVID to disk in
+// the status, metrics and audit log
+func (s *Sidecar) recordJWTSVIDWrite(jwtSVIDFilename string, jwtSVIDs []*jwtsvid.SVID, err error) {
+	config := s.cfg()
+	s.observeJWTSVIDWrite(jwtSVIDFilename, jwtSVIDs, err)
+	s.auditJWTSVIDWrite(jwtSVIDFilename, jwtSVIDs, err)
+
+	s.outputsMu.Lock()
+	defer s.outputsMu.Unlock()
+
+	jwtSVIDFile := path.Join(config.CertDir, jwtSVIDFilename)
+	idx := slices.IndexFunc(s.outputs.JWTSVIDs, func(jwtStatus *JWTSVIDStatus) bool {
+		return jwtStatus.File == jwtSVIDFile
+	})
+	if idx < 0 {
+		return
+	}
+	jwtStatus := s.outputs.JWTSVIDs[idx]
+	if err != nil {
+		jwtStatus.LastError = err.Error()
+		return
+	}
+
+	now := time.Now()
+	jwtStatus.LastWrite = &now
+	jwtStatus.LastError = ""
+
+	if jwtSVID, err := disk.GetJWTSVID(jwtSVIDs, config.Hint); err == nil {
+		jwtStatus.SPIFFEID = jwtSVID.ID.String()
+		jwtStatus.Expiry = &jwtSVID.Expiry
+	}
+}
+
+// recordJWTBundleWrite records the outcome of writing the JWT bundles to
+// disk in the status, metrics and audit log
+func (s *Sidecar) recordJWTBundleWrite(jwtBundleSet *jwtbundle.Set, err error) {
+	s.observeJWTBundleWrite(jwtBundleSet, err)
+	s.auditJWTBundleWrite(jwtBundleSet, err)
+
+	s.outputsMu.Lock()
+	defer s.outputsMu.Unlock()
+
+	bundleStatus := s.outputs.JWTBundle
+	if bundleStatus == nil {
+		return
+	}
+	if err != nil {
+		bundleStatus.LastError = err.Error()
+		return
+	}
+
+	now := time.Now()
+	bundleStatus.LastWrite = &now
+	bundleStatus.LastError = ""
+
+	bundleStatus.TrustDomains = jwtTrustDomains(jwtBundleSet)
+}
+
+// keyType describes the public key of a certificate, e.g. "ECDSA P-256"
+func keyType(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
@@ -0,0 +1,87 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSidecar_Status(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	config := s.sidecar.config
+	config.Cmd = ""
+	config.JWTBundleFilename = "jwt_bundle.json"
+	config.JWTSVIDs = []JWTConfig{{JWTAudience: "aud", JWTExtraAudiences: []string{"extra"}, JWTSVIDFilename: "jwt.token"}}
+	s.sidecar.setupStatus()
+
+	// Outputs are listed before anything is written
+	status := s.sidecar.Status()
+	require.NotNil(t, status.X509SVID)
+	assert.Equal(t, path.Join(config.CertDir, "svid.pem"), status.X509SVID.File)
+	assert.Equal(t, path.Join(config.CertDir, "svid_key.pem"), status.X509SVID.KeyFile)
+	assert.Equal(t, path.Join(config.CertDir, "svid_bundle.pem"), status.X509SVID.BundleFile)
+	assert.Nil(t, status.X509SVID.LastWrite)
+	require.Len(t, status.JWTSVIDs, 1)
+	assert.Equal(t, "aud", status.JWTSVIDs[0].Audience)
+	assert.Equal(t, []string{"extra"}, status.JWTSVIDs[0].ExtraAudiences)
+	require.NotNil(t, status.JWTBundle)
+	assert.Equal(t, path.Join(config.CertDir, "jwt_bundle.json"), status.JWTBundle.File)
+
+	// X.509 SVID details are captured when written
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+
+	leaf := svid.svidChain[0]
+	fingerprint := sha256.Sum256(leaf.Raw)
+	status = s.sidecar.Status()
+	assert.NotNil(t, status.X509SVID.LastWrite)
+	assert.Empty(t, status.X509SVID.LastError)
+	assert.Equal(t, exampleSpiffeID, status.X509SVID.SPIFFEID)
+	assert.Equal(t, leaf.SerialNumber.Text(16), status.X509SVID.SerialNumber)
+	assert.Equal(t, leaf.NotBefore, *status.X509SVID.NotBefore)
+	assert.Equal(t, leaf.NotAfter, *status.X509SVID.NotAfter)
+	assert.Equal(t, 1, status.X509SVID.ChainLength)
+	assert.Equal(t, "ECDSA P-256", status.X509SVID.KeyType)
+	assert.Equal(t, hex.EncodeToString(fingerprint[:]), status.X509SVID.SHA256Fingerprint)
+	assert.Equal(t, []string{"example.test"}, status.X509SVID.BundleTrustDomains)
+
+	// Failures keep the details of the credentials last written
+	s.sidecar.recordX509Write(config.x509SVIDs()[0], nil, errors.New("disk full"))
+	status = s.sidecar.Status()
+	assert.Equal(t, "disk full", status.X509SVID.LastError)
+	assert.Equal(t, exampleSpiffeID, status.X509SVID.SPIFFEID)
+
+	// JWT SVIDs report their SPIFFE ID and expiry
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	jwtSVID := newTestJWTSVID(t, "aud", expiry)
+	s.sidecar.recordJWTSVIDWrite("jwt.token", []*jwtsvid.SVID{jwtSVID}, nil)
+	status = s.sidecar.Status()
+	assert.NotNil(t, status.JWTSVIDs[0].LastWrite)
+	assert.Equal(t, exampleSpiffeID, status.JWTSVIDs[0].SPIFFEID)
+	assert.True(t, expiry.Equal(*status.JWTSVIDs[0].Expiry))
+
+	// JWT bundles report their trust domains
+	bundleSet := jwtbundle.NewSet(
+		jwtbundle.New(spiffeid.RequireTrustDomainFromString("example.test")),
+		jwtbundle.New(spiffeid.RequireTrustDomainFromString("federated.test")),
+	)
+	JWTBundlesWatcher{sidecar: s.sidecar}.OnJWTBundlesUpdate(bundleSet)
+	status = s.sidecar.Status()
+	assert.NotNil(t, status.JWTBundle.LastWrite)
+	assert.Equal(t, []string{"example.test", "federated.test"}, status.JWTBundle.TrustDomains)
+}
@@ -0,0 +1,102 @@
+package sidecar
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Values of CmdStdin that don't pass any input to 'cmd'
+var (
+	// StdinNull connects the stdin of 'cmd' to the null device
+	StdinNull io.Reader = stdinMode("null")
+
+	// StdinClosed connects the stdin of 'cmd' to a pipe that is closed
+	// straight away
+	StdinClosed io.Reader = stdinMode("closed")
+)
+
+type stdinMode string
+
+func (stdinMode) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// Longest line LogWriter buffers before logging it, so a process writing
+// without newlines can't grow the buffer without bound
+const maxLogLineLength = 64 * 1024
+
+// LogWriter is an io.Writer that logs every line written to it as a separate
+// entry. It can be used as CmdStdout or CmdStderr to re-emit the output of
+// 'cmd' through the helper's logger, keeping logs structured.
+type LogWriter struct {
+	log   logrus.FieldLogger
+	level logrus.Level
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewLogWriter returns a LogWriter logging at the given level. log should
+// carry fields identifying the process and stream, e.g. "cmd".
+func NewLogWriter(log logrus.FieldLogger, level logrus.Level) *LogWriter {
+	return &LogWriter{
+		log:   log,
+		level: level,
+	}
+}
+
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.logLine(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLogLineLength {
+		w.logLine(w.buf)
+		w.buf = nil
+	}
+
+	return len(p), nil
+}
+
+// Flush logs any incomplete last line. It is called once the process has
+// exited.
+func (w *LogWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.logLine(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *LogWriter) logLine(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	switch w.level {
+	case logrus.ErrorLevel:
+		w.log.Error(string(line))
+	case logrus.WarnLevel:
+		w.log.Warn(string(line))
+	case logrus.DebugLevel:
+		w.log.Debug(string(line))
+	default:
+		w.log.Info(string(line))
+	}
+}
+
+// flusher is implemented by writers buffering output that must be written
+// once the process exits
+type flusher interface {
+	Flush()
+}
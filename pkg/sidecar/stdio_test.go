@@ -0,0 +1,117 @@
+package sidecar
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spiffe/spiffe-helper/pkg/logfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogWriter(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	w := NewLogWriter(log.WithField("cmd", "envoy"), logrus.WarnLevel)
+
+	_, err := w.Write([]byte("first line\r\nsecond "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("line\n\nincomplete"))
+	require.NoError(t, err)
+
+	entries := hook.AllEntries()
+	require.Len(t, entries, 3)
+	assert.Equal(t, "first line", entries[0].Message)
+	assert.Equal(t, "second line", entries[1].Message)
+	assert.Equal(t, "", entries[2].Message)
+	for _, entry := range entries {
+		assert.Equal(t, logrus.WarnLevel, entry.Level)
+		assert.Equal(t, "envoy", entry.Data["cmd"])
+	}
+
+	// Incomplete lines are logged on flush
+	w.Flush()
+	require.Len(t, hook.AllEntries(), 4)
+	assert.Equal(t, "incomplete", hook.LastEntry().Message)
+	w.Flush()
+	require.Len(t, hook.AllEntries(), 4)
+
+	// Overlong lines are logged without waiting for a newline
+	_, err = w.Write([]byte(strings.Repeat("x", maxLogLineLength+1)))
+	require.NoError(t, err)
+	require.Len(t, hook.AllEntries(), 5)
+	assert.Len(t, hook.LastEntry().Message, maxLogLineLength+1)
+}
+
+func TestSidecar_CmdStdio(t *testing.T) {
+	if onWindows() {
+		t.Skip("Skipping tests that invoke unix shell commands on Windows")
+	}
+
+	for _, tc := range []struct {
+		name          string
+		expectedStdin string
+	}{
+		{
+			name:          "null stdin",
+			expectedStdin: "/dev/null",
+		},
+		{
+			name:          "closed stdin",
+			expectedStdin: "pipe",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			s := newSidecarTest(t)
+			defer s.Close(t)
+
+			stdoutLog, hook := test.NewNullLogger()
+			stderrFile := path.Join(t.TempDir(), "stderr.log")
+
+			s.sidecar.stdin = StdinNull
+			if tc.expectedStdin == "pipe" {
+				s.sidecar.stdin = StdinClosed
+			}
+			s.sidecar.stdout = NewLogWriter(stdoutLog.WithField("cmd", "sh"), logrus.InfoLevel)
+			s.sidecar.stderr = logfile.New(stderrFile, 0, 0)
+
+			config := s.sidecar.config
+			config.Cmd = "sh"
+			config.CmdArgv = []string{"-c", `
+				if [ "$(readlink /proc/self/fd/0 2>/dev/null || echo /dev/null)" = /dev/null ]; then echo "stdin: /dev/null"; else echo "stdin: pipe"; fi
+				cat
+				echo "to stderr" >&2
+				printf "no newline"`}
+
+			svid := newTestX509SVID(t, s.rootCA)
+			s.MockUpdateX509Certificate(ctx, t, svid)
+
+			select {
+			case state := <-s.cmdExitChan:
+				require.Equal(t, 0, state.ExitCode())
+			case <-ctx.Done():
+				require.Fail(t, "timed out waiting for cmd to exit")
+			}
+
+			entries := hook.AllEntries()
+			require.Len(t, entries, 2)
+			if _, err := os.Stat("/proc/self/fd/0"); err == nil {
+				assert.Equal(t, "stdin: "+tc.expectedStdin, entries[0].Message)
+			}
+			assert.Equal(t, "no newline", entries[1].Message)
+			assert.Equal(t, "sh", entries[1].Data["cmd"])
+
+			stderr, err := os.ReadFile(stderrFile)
+			require.NoError(t, err)
+			assert.Equal(t, "to stderr\n", string(stderr))
+		})
+	}
+}
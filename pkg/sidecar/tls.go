@@ -0,0 +1,43 @@
+package sidecar
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// The Sidecar is an x509svid.Source and x509bundle.Source for the X.509 SVID
+// and bundles it last wrote, so the helper can serve TLS with its own
+// identity
+var (
+	_ x509svid.Source   = (*Sidecar)(nil)
+	_ x509bundle.Source = (*Sidecar)(nil)
+)
+
+// GetX509SVID returns the X.509 SVID last written to disk by the first X.509
+// SVID output
+func (s *Sidecar) GetX509SVID() (*x509svid.SVID, error) {
+	s.credentialsMu.RLock()
+	defer s.credentialsMu.RUnlock()
+
+	x509SVID := s.x509SVID()
+	if x509SVID == nil {
+		return nil, errors.New("no X.509 SVID has been received yet")
+	}
+	return x509SVID, nil
+}
+
+// GetX509BundleForTrustDomain returns the X.509 bundle last written to disk
+// for the trust domain
+func (s *Sidecar) GetX509BundleForTrustDomain(trustDomain spiffeid.TrustDomain) (*x509bundle.Bundle, error) {
+	s.credentialsMu.RLock()
+	defer s.credentialsMu.RUnlock()
+
+	if s.x509Bundles == nil {
+		return nil, fmt.Errorf("no X.509 bundle has been received yet for trust domain %q", trustDomain)
+	}
+	return s.x509Bundles.GetX509BundleForTrustDomain(trustDomain)
+}
@@ -0,0 +1,51 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSidecar_X509Source(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+	s.sidecar.config.Cmd = ""
+
+	// Nothing is served before an SVID is received
+	_, err := s.sidecar.GetX509SVID()
+	require.EqualError(t, err, "no X.509 SVID has been received yet")
+	_, err = s.sidecar.GetX509BundleForTrustDomain(spiffeid.RequireTrustDomainFromString("example.test"))
+	require.EqualError(t, err, `no X.509 bundle has been received yet for trust domain "example.test"`)
+
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+
+	gotSVID, err := s.sidecar.GetX509SVID()
+	require.NoError(t, err)
+	assert.Equal(t, svid.spiffeID, gotSVID.ID)
+
+	// The sidecar can serve and verify mTLS with its own identity
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := tls.Server(serverConn, tlsconfig.MTLSServerConfig(s.sidecar, s.sidecar, tlsconfig.AuthorizeID(svid.spiffeID)))
+	client := tls.Client(clientConn, tlsconfig.MTLSClientConfig(s.sidecar, s.sidecar, tlsconfig.AuthorizeID(svid.spiffeID)))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.HandshakeContext(ctx)
+	}()
+	require.NoError(t, client.HandshakeContext(ctx))
+	require.NoError(t, <-errCh)
+}
@@ -0,0 +1,60 @@
+package sidecar
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/spiffe/spiffe-helper/pkg/sidecar"
+
+// Names of the spans covering credential updates and the notifications
+// they cause
+const (
+	SpanFetchAllCredentials   = "fetchAllCredentials"
+	SpanUpdateCertificates    = "updateCertificates"
+	SpanUpdateJWTBundle       = "updateJWTBundle"
+	SpanPerformJWTSVIDUpdate  = "performJWTSVIDUpdate"
+	SpanFetch                 = "fetch"
+	SpanValidateJWTSVID       = "validateJWTSVID"
+	SpanWriteFiles            = "writeFiles"
+	SpanSendNotifications     = "sendNotifications"
+	SpanSignalCmd             = "signalCmd"
+	SpanSignalPIDFile         = "signalPIDFile"
+	SpanReloadExternalProcess = "reloadExternalProcess"
+	SpanNotifyTarget          = "notifyTarget"
+)
+
+// Attributes of the spans, named after the matching log fields
+const (
+	attrCredentialType  = attribute.Key("spiffe_helper.credential_type")
+	attrCredentialTypes = attribute.Key("spiffe_helper.credential_types")
+	attrSPIFFEID        = attribute.Key("spiffe_helper." + LogFieldSPIFFEID)
+	attrFiles           = attribute.Key("spiffe_helper.files")
+	attrAudience        = attribute.Key("spiffe_helper." + LogFieldAudience)
+	attrNotifyTarget    = attribute.Key("spiffe_helper.notify_target")
+	attrCmdLaunched     = attribute.Key("spiffe_helper.cmd_launched")
+)
+
+func newTracer(tracerProvider trace.TracerProvider) trace.Tracer {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	return tracerProvider.Tracer(tracerName)
+}
+
+func (s *Sidecar) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the outcome of the operation covered by the span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,127 @@
+package sidecar
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSidecar_Tracing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newSidecarTest(t)
+	defer s.Close(t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	s.sidecar.tracer = newTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	config := s.sidecar.config
+	config.Cmd = ""
+	config.NotifyTargets = []NotifyTarget{{PIDFilename: path.Join(config.CertDir, "missing.pid"), RenewSignal: "SIGHUP"}}
+
+	svid := newTestX509SVID(t, s.rootCA)
+	s.MockUpdateX509Certificate(ctx, t, svid)
+	<-s.notifyTargetSignalledChan
+
+	// The update span ends once certReady has been called
+	require.Eventually(t, func() bool {
+		_, ok := findSpan(exporter.GetSpans(), SpanUpdateCertificates)
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	spans := exporter.GetSpans()
+
+	update, _ := findSpan(spans, SpanUpdateCertificates)
+	assert.Equal(t, codes.Unset, update.Status.Code)
+	assert.Equal(t, exampleSpiffeID, spanAttribute(update, attrSPIFFEID).AsString())
+
+	write, ok := findSpan(spans, SpanWriteFiles)
+	require.True(t, ok)
+	assert.Equal(t, update.SpanContext.SpanID(), write.Parent.SpanID())
+	assert.Equal(t, CredentialTypeX509, spanAttribute(write, attrCredentialType).AsString())
+	assert.Equal(t, []string{
+		path.Join(config.CertDir, "svid.pem"),
+		path.Join(config.CertDir, "svid_key.pem"),
+		path.Join(config.CertDir, "svid_bundle.pem"),
+	}, spanAttribute(write, attrFiles).AsStringSlice())
+
+	notifications, ok := findSpan(spans, SpanSendNotifications)
+	require.True(t, ok)
+	assert.Equal(t, update.SpanContext.SpanID(), notifications.Parent.SpanID())
+	assert.Equal(t, []string{CredentialTypeX509}, spanAttribute(notifications, attrCredentialTypes).AsStringSlice())
+
+	// Failures to notify are recorded on the span of the target
+	notifyTarget, ok := findSpan(spans, SpanNotifyTarget)
+	require.True(t, ok)
+	assert.Equal(t, notifications.SpanContext.SpanID(), notifyTarget.Parent.SpanID())
+	assert.Equal(t, codes.Error, notifyTarget.Status.Code)
+	assert.Contains(t, notifyTarget.Status.Description, "failed to read pid file")
+	require.Len(t, notifyTarget.Events, 1)
+	assert.Equal(t, "exception", notifyTarget.Events[0].Name)
+}
+
+func TestSidecar_TracingDebouncedNotifications(t *testing.T) {
+	log, _ := test.NewNullLogger()
+	s := New(&Config{
+		Log:            log,
+		NotifyDebounce: 10 * time.Millisecond,
+	})
+	defer s.stopNotifications()
+
+	exporter := tracetest.NewInMemoryExporter()
+	s.tracer = newTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	x509Ctx, x509Span := s.startSpan(context.Background(), SpanUpdateCertificates)
+	s.notifyCredentialUpdate(x509Ctx, CredentialTypeX509)
+	x509Span.End()
+	bundleCtx, bundleSpan := s.startSpan(context.Background(), SpanUpdateJWTBundle)
+	s.notifyCredentialUpdate(bundleCtx, CredentialTypeJWTBundle)
+	bundleSpan.End()
+
+	var notifications tracetest.SpanStub
+	require.Eventually(t, func() bool {
+		var ok bool
+		notifications, ok = findSpan(exporter.GetSpans(), SpanSendNotifications)
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	// Coalesced notifications start a trace of their own, linked to the
+	// updates that caused them
+	assert.False(t, notifications.Parent.IsValid())
+	require.Len(t, notifications.Links, 2)
+	assert.Equal(t, x509Span.SpanContext(), notifications.Links[0].SpanContext)
+	assert.Equal(t, bundleSpan.SpanContext(), notifications.Links[1].SpanContext)
+	assert.Equal(t, []string{CredentialTypeX509, CredentialTypeJWTBundle}, spanAttribute(notifications, attrCredentialTypes).AsStringSlice())
+}
+
+func TestNewTracer(t *testing.T) {
+	// The global TracerProvider doesn't record spans unless one is registered
+	tracer := newTracer(nil)
+	_, span := tracer.Start(context.Background(), SpanFetchAllCredentials)
+	defer span.End()
+	assert.False(t, span.IsRecording())
+}
+
+func findSpan(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	attrs := attribute.NewSet(span.Attributes...)
+	value, _ := attrs.Value(key)
+	return value
+}
@@ -12,7 +12,7 @@ import (
 )
 
 func (s *Sidecar) getWorkloadAPIAddress() workloadapi.ClientOption {
-	return workloadapi.WithAddr("unix://" + s.config.AgentAddress)
+	return workloadapi.WithAddr("unix://" + s.cfg().AgentAddress)
 }
 
 func SignalProcess(process *os.Process, renewSignal string) error {
@@ -13,10 +13,14 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
 	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
 	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
 	"github.com/spiffe/go-spiffe/v2/workloadapi"
 	"github.com/spiffe/spiffe-helper/test/spiffetest"
@@ -35,6 +39,14 @@ type pidFileSignalledResult struct {
 	err error
 }
 
+// Whenever an attempt is made to signal a notification target, the outcome is
+// sent in messages on a channel with this type.
+type notifyTargetSignalledResult struct {
+	target NotifyTarget
+	pids   []int
+	err    error
+}
+
 // sidecarTest is a helper struct to create a sidecar instance for testing.
 // Each should be used for one sidecar instance only, then disposed.
 type sidecarTest struct {
@@ -50,6 +62,9 @@ type sidecarTest struct {
 
 	// Channel for receiving PID file signalling results
 	pidFileSignalledChan chan pidFileSignalledResult
+
+	// Channel for receiving notification target signalling results
+	notifyTargetSignalledChan chan notifyTargetSignalledResult
 }
 
 // Create a new sidecar test instance. It needs to be configured
@@ -78,9 +93,10 @@ func newSidecarTest(t *testing.T) *sidecarTest {
 		}),
 
 		// Observers for internal state	transitions
-		cmdExitChan:          make(chan os.ProcessState, 2),
-		pidFileSignalledChan: make(chan pidFileSignalledResult, 2),
-		certReadyChan:        make(chan *workloadapi.X509Context, 2),
+		cmdExitChan:               make(chan os.ProcessState, 2),
+		pidFileSignalledChan:      make(chan pidFileSignalledResult, 2),
+		notifyTargetSignalledChan: make(chan notifyTargetSignalledResult, 4),
+		certReadyChan:             make(chan *workloadapi.X509Context, 2),
 	}
 
 	s.sidecar.hooks = hooks{
@@ -99,6 +115,13 @@ func newSidecarTest(t *testing.T) *sidecarTest {
 				err: err,
 			}
 		},
+		notifyTargetSignalled: func(target NotifyTarget, pids []int, err error) {
+			s.notifyTargetSignalledChan <- notifyTargetSignalledResult{
+				target: target,
+				pids:   pids,
+				err:    err,
+			}
+		},
 	}
 	s.watcher = &x509Watcher{s.sidecar}
 
@@ -205,3 +228,28 @@ func (svid testX509SVID) x509Context() *workloadapi.X509Context {
 		SVIDs:   svid.svid,
 	}
 }
+
+// Create a JWT SVID for exampleSpiffeID with the given audience and expiry,
+// as if issued by the workload api server. The signature is not validated
+// when parsed, so any key will do.
+func newTestJWTSVID(t *testing.T, audience string, expiry time.Time) *jwtsvid.SVID {
+	t.Helper()
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: spiffetest.NewEC256Key(t)},
+		new(jose.SignerOptions).WithType("JWT"),
+	)
+	require.NoError(t, err)
+
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Subject:  exampleSpiffeID,
+		Audience: []string{audience},
+		Expiry:   jwt.NewNumericDate(expiry),
+		IssuedAt: jwt.NewNumericDate(time.Now()),
+	}).CompactSerialize()
+	require.NoError(t, err)
+
+	svid, err := jwtsvid.ParseInsecure(token, []string{audience})
+	require.NoError(t, err)
+	return svid
+}
@@ -11,7 +11,7 @@ import (
 )
 
 func (s *Sidecar) getWorkloadAPIAddress() workloadapi.ClientOption {
-	return workloadapi.WithNamedPipeName(s.config.AgentAddress)
+	return workloadapi.WithNamedPipeName(s.cfg().AgentAddress)
 }
 
 func SignalProcess(_ *os.Process, _ string) error {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
 	"sync"
 	"time"
 
@@ -46,7 +47,7 @@ func (s *Sidecar) watchJWTBundles(ctx context.Context) error {
 
 func (s *Sidecar) watchJWTSVIDs(ctx context.Context) error {
 	var wg sync.WaitGroup
-	for _, jwtConfig := range s.config.JWTSVIDs {
+	for _, jwtConfig := range s.cfg().JWTSVIDs {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -65,15 +66,17 @@ func (s *Sidecar) fetchAndWriteX509Context(ctx context.Context) error {
 	// Retry PermissionDenied errors. We may get a few of these before the cert is minted
 	err := retry.OnError(backoff, func(err error) bool {
 		return status.Code(err) == codes.PermissionDenied
-	}, func() (err error) {
-		x509Context, err = s.client.FetchX509Context(ctx)
-		return err
+	}, func() error {
+		return s.fetch(ctx, CredentialTypeX509, func(ctx context.Context) (err error) {
+			x509Context, err = s.client.FetchX509Context(ctx)
+			return err
+		})
 	})
 	if err != nil {
 		return err
 	}
 
-	return disk.WriteX509Context(x509Context, s.config.AddIntermediatesToBundle, s.config.IncludeFederatedDomains, s.config.CertDir, s.config.SVIDFilename, s.config.SVIDKeyFilename, s.config.SVIDBundleFilename, s.config.CertFileMode, s.config.KeyFileMode, s.config.Hint)
+	return s.writeX509Context(ctx, x509Context)
 }
 
 func (s *Sidecar) fetchAndWriteJWTBundle(ctx context.Context) error {
@@ -82,20 +85,22 @@ func (s *Sidecar) fetchAndWriteJWTBundle(ctx context.Context) error {
 	// Retry PermissionDenied errors. We may get a few of these before the cert is minted
 	err := retry.OnError(backoff, func(err error) bool {
 		return status.Code(err) == codes.PermissionDenied
-	}, func() (err error) {
-		jwtBundleSet, err = s.client.FetchJWTBundles(ctx)
-		return err
+	}, func() error {
+		return s.fetch(ctx, CredentialTypeJWTBundle, func(ctx context.Context) (err error) {
+			jwtBundleSet, err = s.client.FetchJWTBundles(ctx)
+			return err
+		})
 	})
 	if err != nil {
 		return err
 	}
 
-	return disk.WriteJWTBundleSet(jwtBundleSet, s.config.CertDir, s.config.JWTBundleFilename, s.config.JWTBundleFileMode)
+	return s.writeJWTBundleSet(ctx, jwtBundleSet)
 }
 
 func (s *Sidecar) fetchAndWriteJWTSVIDs(ctx context.Context) error {
 	var errs []error
-	for _, jwtConfig := range s.config.JWTSVIDs {
+	for _, jwtConfig := range s.cfg().JWTSVIDs {
 		if err := s.fetchAndWriteJWTSVID(ctx, jwtConfig.JWTAudience, jwtConfig.JWTSVIDFilename); err != nil {
 			errs = append(errs, fmt.Errorf("unable to fetch JWT SVID for audience %q: %w", jwtConfig.JWTAudience, err))
 		}
@@ -110,13 +115,80 @@ func (s *Sidecar) fetchAndWriteJWTSVID(ctx context.Context, audience, jwtSVIDFil
 	// Retry PermissionDenied errors. We may get a few of these before the cert is minted
 	err := retry.OnError(backoff, func(err error) bool {
 		return status.Code(err) == codes.PermissionDenied
-	}, func() (err error) {
-		jwtSVIDs, err = s.jwtSource.FetchJWTSVIDs(ctx, jwtsvid.Params{Audience: audience})
-		return err
+	}, func() error {
+		return s.fetch(ctx, CredentialTypeJWTSVID, func(ctx context.Context) (err error) {
+			jwtSVIDs, err = s.jwtSource.FetchJWTSVIDs(ctx, jwtsvid.Params{Audience: audience})
+			return err
+		})
 	})
 	if err != nil {
 		return err
 	}
 
-	return disk.WriteJWTSVID(jwtSVIDs, s.config.CertDir, jwtSVIDFilename, s.config.JWTSVIDFileMode, s.config.Hint)
+	return s.writeJWTSVID(ctx, jwtSVIDs, jwtSVIDFilename)
+}
+
+// fetch runs a single Workload API call for credentials of the given type in
+// a span of its own and observes how long it took
+func (s *Sidecar) fetch(ctx context.Context, credentialType string, fetch func(context.Context) error) error {
+	ctx, span := s.startSpan(ctx, SpanFetch, attrCredentialType.String(credentialType))
+	start := time.Now()
+	err := fetch(ctx)
+	s.observeFetch(credentialType, start, err)
+	endSpan(span, err)
+	return err
+}
+
+// writeX509Context writes the files of every X.509 SVID output and records
+// the outcome of each
+func (s *Sidecar) writeX509Context(ctx context.Context, x509Context *workloadapi.X509Context) error {
+	config := s.cfg()
+	var errs []error
+	for _, x509SVID := range config.x509SVIDs() {
+		if err := s.writeX509SVID(ctx, x509SVID, x509Context); err != nil {
+			errs = append(errs, fmt.Errorf("unable to write %q: %w", path.Join(config.CertDir, x509SVID.SVIDFilename), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// writeX509SVID writes the X.509 SVID, key and bundle files of an output and
+// records the outcome
+func (s *Sidecar) writeX509SVID(ctx context.Context, x509SVID X509SVIDConfig, x509Context *workloadapi.X509Context) error {
+	config := s.cfg()
+	_, span := s.startSpan(ctx, SpanWriteFiles,
+		attrCredentialType.String(CredentialTypeX509),
+		attrFiles.StringSlice(config.x509SVIDFiles(x509SVID)),
+	)
+	err := disk.WriteX509Context(x509Context, x509SVID.AddIntermediatesToBundle, x509SVID.IncludeFederatedDomains, config.CertDir, x509SVID.SVIDFilename, x509SVID.SVIDKeyFilename, x509SVID.SVIDBundleFilename, x509SVID.CertFileMode, x509SVID.KeyFileMode, x509SVID.Hint, x509SVID.SPIFFEID)
+	s.recordX509Write(x509SVID, x509Context, err)
+	endSpan(span, err)
+	return err
+}
+
+// writeJWTBundleSet writes the JWT bundle file and records the outcome
+func (s *Sidecar) writeJWTBundleSet(ctx context.Context, jwtBundleSet *jwtbundle.Set) error {
+	config := s.cfg()
+	_, span := s.startSpan(ctx, SpanWriteFiles,
+		attrCredentialType.String(CredentialTypeJWTBundle),
+		attrFiles.StringSlice([]string{path.Join(config.CertDir, config.JWTBundleFilename)}),
+	)
+	err := disk.WriteJWTBundleSet(jwtBundleSet, config.CertDir, config.JWTBundleFilename, config.JWTBundleFileMode)
+	s.recordJWTBundleWrite(jwtBundleSet, err)
+	endSpan(span, err)
+	return err
+}
+
+// writeJWTSVID writes a JWT SVID file and records the outcome
+func (s *Sidecar) writeJWTSVID(ctx context.Context, jwtSVIDs []*jwtsvid.SVID, jwtSVIDFilename string) error {
+	config := s.cfg()
+	_, span := s.startSpan(ctx, SpanWriteFiles,
+		attrCredentialType.String(CredentialTypeJWTSVID),
+		attrFiles.StringSlice([]string{path.Join(config.CertDir, jwtSVIDFilename)}),
+	)
+	err := disk.WriteJWTSVID(jwtSVIDs, config.CertDir, jwtSVIDFilename, config.JWTSVIDFileMode, config.Hint)
+	s.recordJWTSVIDWrite(jwtSVIDFilename, jwtSVIDs, err)
+	endSpan(span, err)
+	return err
 }
@@ -0,0 +1,85 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	listenPIDEnv     = "LISTEN_PID"
+	listenFDsEnv     = "LISTEN_FDS"
+	listenFDNamesEnv = "LISTEN_FDNAMES"
+)
+
+// listenFDsStart is the first file descriptor passed with socket
+// activation. Tests replace it.
+var listenFDsStart = 3
+
+// Listener is a socket passed by the service manager with socket activation
+type Listener struct {
+	net.Listener
+
+	// FileDescriptorName= of the socket, which defaults to the name of the
+	// socket unit
+	Name string
+}
+
+// Listeners returns the sockets passed to this process by the service
+// manager with socket activation, in order, or none if there are none. The
+// environment variables describing them are unset, so that processes
+// launched by the helper don't take the sockets for theirs.
+func Listeners() ([]Listener, error) {
+	pidEnv := os.Getenv(listenPIDEnv)
+	if pidEnv == "" {
+		return nil, nil
+	}
+	fdsEnv := os.Getenv(listenFDsEnv)
+	namesEnv := os.Getenv(listenFDNamesEnv)
+	for _, env := range []string{listenPIDEnv, listenFDsEnv, listenFDNamesEnv} {
+		os.Unsetenv(env)
+	}
+
+	pid, err := strconv.Atoi(pidEnv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", listenPIDEnv, err)
+	}
+	// The sockets may be meant for another process, e.g. a parent shell
+	if pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(fdsEnv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", listenFDsEnv, err)
+	}
+	if count < 0 {
+		return nil, errors.New(listenFDsEnv + " must be positive")
+	}
+	names := strings.Split(namesEnv, ":")
+
+	listeners := make([]Listener, 0, count)
+	for i := range count {
+		fd := listenFDsStart + i
+		var name string
+		if i < len(names) {
+			name = names[i]
+		}
+
+		// FileListener works on a copy of the file descriptor
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, fmt.Errorf("socket %d passed by systemd is not a listening socket: %w", fd, err)
+		}
+		listeners = append(listeners, Listener{Listener: listener, Name: name})
+	}
+
+	return listeners, nil
+}
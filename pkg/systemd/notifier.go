@@ -0,0 +1,126 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// How often the notifier checks the sidecar for readiness and status
+// changes that happen without a health update, e.g. as time passes, when
+// the watchdog doesn't require a shorter interval.
+const defaultPollInterval = time.Second
+
+// Sidecar is the state of the helper reported to the service manager
+type Sidecar interface {
+	CheckLiveness() bool
+	CheckReadiness() bool
+	X509SVIDExpiry() time.Time
+	SubscribeHealth() (<-chan struct{}, func())
+}
+
+// Notifier reports the helper's state to systemd: READY=1 once the first
+// credentials are written, WATCHDOG=1 keep-alives while the helper is live,
+// and a STATUS= line with the X.509 SVID expiry.
+type Notifier struct {
+	log     logrus.FieldLogger
+	sidecar Sidecar
+
+	socketPath       string
+	watchdogInterval time.Duration
+	watchdogErr      error
+	pollInterval     time.Duration
+}
+
+// NewNotifier returns a notifier for the notification socket and watchdog
+// passed to this process. The environment variables describing them are
+// unset, so that processes launched by the helper don't notify systemd on
+// its behalf.
+func NewNotifier(log logrus.FieldLogger, sidecar Sidecar) *Notifier {
+	socketPath := os.Getenv(notifySocketEnv)
+	watchdogInterval, err := WatchdogInterval()
+	for _, env := range []string{notifySocketEnv, watchdogUsecEnv, watchdogPIDEnv} {
+		os.Unsetenv(env)
+	}
+
+	return &Notifier{
+		log:              log,
+		sidecar:          sidecar,
+		socketPath:       socketPath,
+		watchdogInterval: watchdogInterval,
+		watchdogErr:      err,
+		pollInterval:     defaultPollInterval,
+	}
+}
+
+// Run sends notifications until the context is cancelled, then reports
+// STOPPING=1.
+func (n *Notifier) Run(ctx context.Context) error {
+	watchdogInterval, err := n.watchdogInterval, n.watchdogErr
+	if err != nil {
+		return err
+	}
+
+	// systemd recommends pinging at half the watchdog interval
+	pollInterval := n.pollInterval
+	if watchdogInterval > 0 && watchdogInterval/2 < pollInterval {
+		pollInterval = watchdogInterval / 2
+	}
+	if watchdogInterval > 0 {
+		n.log.Infof("Sending systemd watchdog notifications every %s", pollInterval)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	// Readiness and status are reported as soon as the health is updated,
+	// rather than on the next tick
+	changes, unsubscribe := n.sidecar.SubscribeHealth()
+	defer unsubscribe()
+
+	ready := false
+	lastStatus := ""
+	for {
+		if !ready && n.sidecar.CheckReadiness() {
+			ready = true
+			n.notify("READY=1")
+			n.log.Info("Notified systemd that the service is ready")
+		}
+
+		if status := n.status(ready); status != lastStatus {
+			lastStatus = status
+			n.notify("STATUS=" + status)
+		}
+
+		if watchdogInterval > 0 && n.sidecar.CheckLiveness() {
+			n.notify("WATCHDOG=1")
+		}
+
+		select {
+		case <-ctx.Done():
+			n.notify("STOPPING=1")
+			return ctx.Err()
+		case <-ticker.C:
+		case <-changes:
+		}
+	}
+}
+
+func (n *Notifier) status(ready bool) string {
+	if !ready {
+		return "Waiting for credentials"
+	}
+	if expiry := n.sidecar.X509SVIDExpiry(); !expiry.IsZero() {
+		return fmt.Sprintf("X.509 SVID expires at %s", expiry.UTC().Format(time.RFC3339))
+	}
+	return "Credentials written"
+}
+
+func (n *Notifier) notify(state string) {
+	if _, err := notify(n.socketPath, state); err != nil {
+		n.log.WithError(err).Warn("Unable to notify systemd")
+	}
+}
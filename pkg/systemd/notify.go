@@ -0,0 +1,88 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	notifySocketEnv = "NOTIFY_SOCKET"
+	watchdogUsecEnv = "WATCHDOG_USEC"
+	watchdogPIDEnv  = "WATCHDOG_PID"
+)
+
+// NotifySocketSet reports whether the process was started by systemd with a
+// notification socket, i.e. as a Type=notify service.
+func NotifySocketSet() bool {
+	return os.Getenv(notifySocketEnv) != ""
+}
+
+// Notify sends a state string to the service manager using the sd_notify
+// protocol, e.g. "READY=1". Several assignments can be sent at once by
+// separating them with newlines. It returns false without error if no
+// notification socket is configured.
+func Notify(state string) (bool, error) {
+	return notify(os.Getenv(notifySocketEnv), state)
+}
+
+// notify sends a state string to the notification socket at socketPath
+func notify(socketPath string, state string) (bool, error) {
+	socketAddr := &net.UnixAddr{
+		Name: socketPath,
+		Net:  "unixgram",
+	}
+	if socketAddr.Name == "" {
+		return false, nil
+	}
+	// Abstract sockets are passed with a leading '@'
+	if socketAddr.Name[0] == '@' {
+		socketAddr.Name = "\x00" + socketAddr.Name[1:]
+	}
+
+	conn, err := net.DialUnix(socketAddr.Net, nil, socketAddr)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to notification socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to write to notification socket: %w", err)
+	}
+
+	return true, nil
+}
+
+// WatchdogInterval returns the interval within which the service manager
+// expects "WATCHDOG=1" keep-alive notifications, or zero if the watchdog is
+// not enabled for this process.
+func WatchdogInterval() (time.Duration, error) {
+	usecEnv := os.Getenv(watchdogUsecEnv)
+	if usecEnv == "" {
+		return 0, nil
+	}
+
+	usec, err := strconv.ParseInt(usecEnv, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", watchdogUsecEnv, err)
+	}
+	if usec <= 0 {
+		return 0, errors.New(watchdogUsecEnv + " must be positive")
+	}
+
+	// The watchdog may be meant for another process, e.g. a parent shell
+	if pidEnv := os.Getenv(watchdogPIDEnv); pidEnv != "" {
+		pid, err := strconv.Atoi(pidEnv)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s: %w", watchdogPIDEnv, err)
+		}
+		if pid != os.Getpid() {
+			return 0, nil
+		}
+	}
+
+	return time.Duration(usec) * time.Microsecond, nil
+}
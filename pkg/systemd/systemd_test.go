@@ -0,0 +1,304 @@
+//go:build !windows
+// +build !windows
+
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// listenNotifySocket creates a fake service manager notification socket and
+// points NOTIFY_SOCKET at it.
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	// Socket paths are limited in length, so avoid the long test temp dir
+	dir, err := os.MkdirTemp("", "sd")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socketPath := path.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	t.Setenv(notifySocketEnv, socketPath)
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv(notifySocketEnv, "")
+	sent, err := Notify("READY=1")
+	require.NoError(t, err)
+	assert.False(t, sent)
+	assert.False(t, NotifySocketSet())
+
+	conn := listenNotifySocket(t)
+	assert.True(t, NotifySocketSet())
+
+	sent, err = Notify("READY=1\nSTATUS=Running")
+	require.NoError(t, err)
+	assert.True(t, sent)
+	assert.Equal(t, "READY=1\nSTATUS=Running", readNotification(t, conn))
+
+	t.Setenv(notifySocketEnv, "/nonexistent/notify.sock")
+	_, err = Notify("READY=1")
+	require.ErrorContains(t, err, "failed to connect to notification socket")
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	for _, tt := range []struct {
+		name             string
+		watchdogUsec     string
+		watchdogPID      string
+		expectedInterval time.Duration
+		expectError      string
+	}{
+		{
+			name: "watchdog disabled",
+		},
+		{
+			name:             "watchdog enabled",
+			watchdogUsec:     "30000000",
+			expectedInterval: 30 * time.Second,
+		},
+		{
+			name:             "watchdog enabled for this process",
+			watchdogUsec:     "500000",
+			watchdogPID:      strconv.Itoa(os.Getpid()),
+			expectedInterval: 500 * time.Millisecond,
+		},
+		{
+			name:         "watchdog enabled for another process",
+			watchdogUsec: "500000",
+			watchdogPID:  "1",
+		},
+		{
+			name:         "invalid watchdog interval",
+			watchdogUsec: "soon",
+			expectError:  "failed to parse WATCHDOG_USEC",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(watchdogUsecEnv, tt.watchdogUsec)
+			t.Setenv(watchdogPIDEnv, tt.watchdogPID)
+
+			interval, err := WatchdogInterval()
+			if tt.expectError != "" {
+				require.ErrorContains(t, err, tt.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedInterval, interval)
+		})
+	}
+}
+
+type fakeSidecar struct {
+	ready   atomic.Bool
+	live    atomic.Bool
+	expiry  time.Time
+	changes chan struct{}
+}
+
+func (s *fakeSidecar) CheckLiveness() bool {
+	return s.live.Load()
+}
+
+func (s *fakeSidecar) CheckReadiness() bool {
+	return s.ready.Load()
+}
+
+func (s *fakeSidecar) X509SVIDExpiry() time.Time {
+	return s.expiry
+}
+
+func (s *fakeSidecar) SubscribeHealth() (<-chan struct{}, func()) {
+	return s.changes, func() {}
+}
+
+func TestNotifier(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv(watchdogUsecEnv, "100000")
+	t.Setenv(watchdogPIDEnv, "")
+
+	sidecar := &fakeSidecar{
+		expiry: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	log, _ := test.NewNullLogger()
+	notifier := NewNotifier(log, sidecar)
+
+	// 'cmd' doesn't inherit the notification socket or watchdog
+	for _, env := range []string{notifySocketEnv, watchdogUsecEnv, watchdogPIDEnv} {
+		_, ok := os.LookupEnv(env)
+		assert.False(t, ok, env)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- notifier.Run(ctx)
+	}()
+
+	// Not ready yet, and not live so no watchdog pings
+	assert.Equal(t, "STATUS=Waiting for credentials", readNotification(t, conn))
+
+	// Watchdog pings start once the sidecar is live
+	sidecar.live.Store(true)
+	assert.Equal(t, "WATCHDOG=1", readNotification(t, conn))
+
+	// Readiness is reported once, followed by the SVID expiry
+	sidecar.ready.Store(true)
+	notification := readNotification(t, conn)
+	for notification == "WATCHDOG=1" {
+		notification = readNotification(t, conn)
+	}
+	assert.Equal(t, "READY=1", notification)
+	assert.Equal(t, "STATUS=X.509 SVID expires at 2030-01-02T03:04:05Z", readNotification(t, conn))
+	assert.Equal(t, "WATCHDOG=1", readNotification(t, conn))
+
+	cancel()
+	for notification := readNotification(t, conn); notification != "STOPPING=1"; notification = readNotification(t, conn) {
+		assert.Equal(t, "WATCHDOG=1", notification)
+	}
+	require.ErrorIs(t, <-errCh, context.Canceled)
+}
+
+func TestNotifier_HealthUpdates(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv(watchdogUsecEnv, "")
+	t.Setenv(watchdogPIDEnv, "")
+
+	sidecar := &fakeSidecar{
+		changes: make(chan struct{}),
+	}
+	log, _ := test.NewNullLogger()
+	notifier := NewNotifier(log, sidecar)
+	notifier.pollInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- notifier.Run(ctx)
+	}()
+	assert.Equal(t, "STATUS=Waiting for credentials", readNotification(t, conn))
+
+	// Readiness is reported as soon as the health is updated, without
+	// waiting for the poll interval
+	sidecar.ready.Store(true)
+	sidecar.changes <- struct{}{}
+	assert.Equal(t, "READY=1", readNotification(t, conn))
+	assert.Equal(t, "STATUS=Credentials written", readNotification(t, conn))
+
+	cancel()
+	assert.Equal(t, "STOPPING=1", readNotification(t, conn))
+	require.ErrorIs(t, <-errCh, context.Canceled)
+}
+
+func TestApplyUnitAction(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := path.Join(dir, "args")
+	fakeSystemctl := path.Join(dir, "systemctl")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\n[ \"$3\" != \"missing.service\" ] || { echo 'Unit missing.service not found.' >&2; exit 5; }\n[ \"$3\" != \"stuck.service\" ] || exec sleep 10\n"
+	require.NoError(t, os.WriteFile(fakeSystemctl, []byte(script), 0700)) // #nosec
+
+	oldSystemctlPath, oldSystemctlTimeout := systemctlPath, systemctlTimeout
+	systemctlPath, systemctlTimeout = fakeSystemctl, 100*time.Millisecond
+	defer func() { systemctlPath, systemctlTimeout = oldSystemctlPath, oldSystemctlTimeout }()
+
+	ctx := context.Background()
+	require.NoError(t, ApplyUnitAction(ctx, "nginx.service", UnitActionReload))
+	args, err := os.ReadFile(argsFile)
+	require.NoError(t, err)
+	assert.Equal(t, "--no-block reload nginx.service\n", string(args))
+
+	err = ApplyUnitAction(ctx, "missing.service", UnitActionRestart)
+	require.ErrorContains(t, err, "systemctl restart missing.service failed: exit status 5: Unit missing.service not found.")
+
+	// A stuck systemctl doesn't hold up the notifications
+	start := time.Now()
+	err = ApplyUnitAction(ctx, "stuck.service", UnitActionReload)
+	require.ErrorContains(t, err, "systemctl reload stuck.service failed: signal: killed")
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	err = ApplyUnitAction(ctx, "nginx.service", "stop")
+	require.EqualError(t, err, "unknown systemd unit action \"stop\"")
+}
+
+func TestListeners(t *testing.T) {
+	t.Setenv(listenPIDEnv, "")
+	listeners, err := Listeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+
+	// Sockets meant for another process are left alone
+	t.Setenv(listenPIDEnv, "1")
+	t.Setenv(listenFDsEnv, "1")
+	listeners, err = Listeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+
+	// Pass two sockets at consecutive file descriptors that are free, which
+	// Listeners takes over
+	const fdsStart = 100
+	oldListenFDsStart := listenFDsStart
+	listenFDsStart = fdsStart
+	defer func() { listenFDsStart = oldListenFDsStart }()
+	var sockets []net.Listener
+	for i := range 2 {
+		socket, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer socket.Close()
+		file, err := socket.(*net.TCPListener).File()
+		require.NoError(t, err)
+		require.NoError(t, unix.Dup2(int(file.Fd()), fdsStart+i))
+		require.NoError(t, file.Close())
+		sockets = append(sockets, socket)
+	}
+
+	t.Setenv(listenPIDEnv, strconv.Itoa(os.Getpid()))
+	t.Setenv(listenFDsEnv, "2")
+	t.Setenv(listenFDNamesEnv, "health:grpc")
+	listeners, err = Listeners()
+	require.NoError(t, err)
+	require.Len(t, listeners, 2)
+	defer listeners[0].Close()
+	defer listeners[1].Close()
+	assert.Equal(t, "health", listeners[0].Name)
+	assert.Equal(t, sockets[0].Addr().String(), listeners[0].Addr().String())
+	assert.Equal(t, "grpc", listeners[1].Name)
+	assert.Equal(t, sockets[1].Addr().String(), listeners[1].Addr().String())
+
+	// The variables are unset, so they are only used once
+	for _, env := range []string{listenPIDEnv, listenFDsEnv, listenFDNamesEnv} {
+		_, set := os.LookupEnv(env)
+		assert.False(t, set, env)
+	}
+
+	t.Setenv(listenPIDEnv, strconv.Itoa(os.Getpid()))
+	t.Setenv(listenFDsEnv, "many")
+	_, err = Listeners()
+	require.ErrorContains(t, err, "failed to parse LISTEN_FDS")
+}
@@ -0,0 +1,48 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Actions that can be applied to a unit when credentials are rotated
+const (
+	UnitActionReload          = "reload"
+	UnitActionRestart         = "restart"
+	UnitActionTryRestart      = "try-restart"
+	UnitActionReloadOrRestart = "reload-or-restart"
+)
+
+// UnitActions lists every supported unit action
+var UnitActions = []string{UnitActionReload, UnitActionRestart, UnitActionTryRestart, UnitActionReloadOrRestart}
+
+// systemctlPath is the systemctl executable, and systemctlTimeout how long
+// it is given to queue a job. Tests replace them.
+var (
+	systemctlPath    = "systemctl"
+	systemctlTimeout = 10 * time.Second
+)
+
+// ApplyUnitAction asks systemd to reload or restart a unit through systemctl.
+// The job is queued without waiting for it to complete, so a slow unit
+// doesn't hold up credential rotation, and systemctl is killed if it doesn't
+// return within systemctlTimeout.
+func ApplyUnitAction(ctx context.Context, unit string, action string) error {
+	if !slices.Contains(UnitActions, action) {
+		return fmt.Errorf("unknown systemd unit action %q", action)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, systemctlTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, systemctlPath, "--no-block", action, unit) // #nosec
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s %s failed: %w: %s", action, unit, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
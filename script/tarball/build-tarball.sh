@@ -27,6 +27,9 @@ cp "${REPODIR}"/LICENSE "${STAGING}"
 # Copy in the README.md
 cp "${REPODIR}"/README.md "${STAGING}"
 
+# Copy in the JSON Schema of the configuration
+cp "${REPODIR}"/spiffe-helper.schema.json "${STAGING}"
+
 # Copy in the SPIFFE Helper binary
 cp "${REPODIR}"/spiffe-helper "${STAGING}"
 